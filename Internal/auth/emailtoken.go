@@ -0,0 +1,26 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+)
+
+// bytes of randomness in a token mailed to a user
+const emailTokenLen = 32
+
+// NewEmailToken returns a random token to mail to a user and the hash to
+// store for it. the token is URL safe so it can be put in a link as is
+func NewEmailToken() (token, hash string, err error) {
+	secret := make([]byte, emailTokenLen)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", fmt.Errorf("email token: %w", err)
+	}
+	token = base64.RawURLEncoding.EncodeToString(secret)
+	return token, HashEmailToken(token), nil
+}
+
+// HashEmailToken is the form a mailed token is stored and looked up in
+func HashEmailToken(token string) string {
+	return HashRefreshToken(token)
+}
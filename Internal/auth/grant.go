@@ -0,0 +1,41 @@
+package auth
+
+import "context"
+
+// roles with a meaning of their own in authorization rules
+const (
+	// RoleAny admits every authenticated caller
+	RoleAny = "*"
+	// RoleSelf admits every authenticated caller, but only to act on the
+	// user record whose ID is their subject
+	RoleSelf = "self"
+)
+
+// Grant is how far the authorization policy let a caller into a method
+type Grant int
+
+const (
+	// GrantFull places no restriction on the records the caller touches
+	GrantFull Grant = iota
+	// GrantSelf restricts the caller to their own user record
+	GrantSelf
+	// GrantAny lets a caller admitted only by RoleAny touch every record,
+	// but not see what is reserved for the roles listed next to it, such as
+	// deleted users
+	GrantAny
+)
+
+type grantKey struct{}
+
+// WithGrant stores the grant the policy decided on in ctx
+func WithGrant(ctx context.Context, g Grant) context.Context {
+	return context.WithValue(ctx, grantKey{}, g)
+}
+
+// GrantFromContext returns the grant stored in ctx, if the policy decided on
+// one. without one, e.g. when no policy is installed, the grant is GrantFull
+// and ok is false, what only unrestricted checks may rely on
+func GrantFromContext(ctx context.Context) (g Grant, ok bool) {
+	g, ok = ctx.Value(grantKey{}).(Grant)
+	return g, ok
+}
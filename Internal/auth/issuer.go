@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// IssuerConfig is how access tokens handed out for a session are signed and
+// how long they stay valid
+type IssuerConfig struct {
+	// HMACSecret signs the tokens with HS256, the same secret the Verifier
+	// checks them with
+	HMACSecret []byte
+	// Issuer and Audience become the iss and aud claims when set
+	Issuer   string
+	Audience string
+	// lifetime of an access token
+	AccessTTL time.Duration
+}
+
+// TokenPair is a short lived access token to send as the bearer token and
+// the opaque refresh token of session SessionID that buys the next pair.
+// ExpiresAt is when the access token expires
+type TokenPair struct {
+	SessionID    string
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+}
+
+// Issuer signs HS256 tokens for authenticated users
+type Issuer struct {
+	cfg IssuerConfig
+	now func() time.Time
+}
+
+// NewIssuer creates an Issuer signing with the secret in cfg
+func NewIssuer(cfg IssuerConfig) (*Issuer, error) {
+	if len(cfg.HMACSecret) == 0 {
+		return nil, ErrNoKeys
+	}
+	return &Issuer{cfg: cfg, now: time.Now}, nil
+}
+
+// Issue signs an access token for principal and reports when it expires
+func (i *Issuer) Issue(principal Principal) (token string, expiresAt time.Time, err error) {
+	now := i.now()
+	expiresAt = now.Add(i.cfg.AccessTTL)
+	c := claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Subject:   principal.Subject,
+			Issuer:    i.cfg.Issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+		Roles: principal.Roles,
+	}
+	if i.cfg.Audience != "" {
+		c.Audience = jwt.ClaimStrings{i.cfg.Audience}
+	}
+	token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString(i.cfg.HMACSecret)
+	return token, expiresAt, err
+}
@@ -0,0 +1,35 @@
+// Package auth verifies bearer tokens and carries the authenticated caller
+// through the request context
+package auth
+
+import (
+	"context"
+	"slices"
+)
+
+// Principal is the authenticated caller of an RPC
+type Principal struct {
+	// Subject identifies the caller, the "sub" claim of its token
+	Subject string
+	// Roles granted to the caller, the "roles" claim of its token
+	Roles []string
+}
+
+// HasRole reports whether the principal was granted role
+func (p *Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+type principalKey struct{}
+
+// WithPrincipal stores p in ctx
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the caller of the request ctx belongs to, if
+// it was authenticated
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// bytes of randomness in a refresh token on top of its session ID
+const refreshSecretLen = 32
+
+// NewRefreshToken returns an opaque refresh token for session sessionID and
+// the hash to store for it. the token is "<session id>.<random secret>" so
+// the session can be found without storing the token itself
+func NewRefreshToken(sessionID string) (token, hash string, err error) {
+	secret := make([]byte, refreshSecretLen)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", fmt.Errorf("refresh token: %w", err)
+	}
+	token = sessionID + "." + base64.RawURLEncoding.EncodeToString(secret)
+	return token, HashRefreshToken(token), nil
+}
+
+// ParseRefreshToken returns the session ID a refresh token was issued for.
+// ok is false when token was not made by NewRefreshToken
+func ParseRefreshToken(token string) (sessionID string, ok bool) {
+	sessionID, secret, found := strings.Cut(token, ".")
+	if !found || uuid.Validate(sessionID) != nil {
+		return "", false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(secret)
+	if err != nil || len(raw) != refreshSecretLen {
+		return "", false
+	}
+	return sessionID, true
+}
+
+// HashRefreshToken is the form a refresh token is stored and compared in.
+// the token is already high entropy so a fast hash is enough
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,226 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/yishak-cs/CleanGrpc/Internal/auth"
+)
+
+var secret = []byte("0123456789abcdef0123456789abcdef")
+
+func writeFile(t *testing.T, name string, content []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key any, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return signed
+}
+
+// validClaims expire in an hour
+func validClaims(sub string, roles ...string) jwt.MapClaims {
+	return jwt.MapClaims{"sub": sub, "roles": roles, "exp": time.Now().Add(time.Hour).Unix()}
+}
+
+func jwksFile(t *testing.T, keys map[string]*rsa.PublicKey) string {
+	t.Helper()
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for kid, key := range keys {
+		set.Keys = append(set.Keys, map[string]string{
+			"kty": "RSA", "kid": kid, "use": "sig", "alg": "RS256",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	// keys meant for something else are ignored
+	set.Keys = append(set.Keys, map[string]string{"kty": "EC", "kid": "ec", "crv": "P-256"})
+	raw, _ := json.Marshal(set)
+	return writeFile(t, "jwks.json", raw)
+}
+
+func TestVerifier_HS256(t *testing.T) {
+	verifier, err := auth.NewVerifier(auth.VerifierConfig{HMACSecret: secret})
+	assert.NoError(t, err)
+
+	// Test case: A valid token yields its subject and roles
+	principal, err := verifier.Verify(sign(t, jwt.SigningMethodHS256, secret, "", validClaims("42", "admin")))
+	assert.NoError(t, err)
+	assert.Equal(t, &auth.Principal{Subject: "42", Roles: []string{"admin"}}, principal)
+	assert.True(t, principal.HasRole("admin"))
+	assert.False(t, principal.HasRole("user"))
+
+	// Test case: Bad signatures, expiry and missing claims are rejected
+	rejected := map[string]string{
+		"wrong secret": sign(t, jwt.SigningMethodHS256, []byte("another-secret-another-secret-xx"), "", validClaims("42")),
+		"expired":      sign(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"sub": "42", "exp": time.Now().Add(-time.Hour).Unix()}),
+		"no expiry":    sign(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"sub": "42"}),
+		"no subject":   sign(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"exp": time.Now().Add(time.Hour).Unix()}),
+		"not yet":      sign(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"sub": "42", "exp": time.Now().Add(2 * time.Hour).Unix(), "nbf": time.Now().Add(time.Hour).Unix()}),
+		"alg none":     sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", validClaims("42")),
+		"HS512":        sign(t, jwt.SigningMethodHS512, secret, "", validClaims("42")),
+		"garbage":      "not.a.token",
+	}
+	for name, token := range rejected {
+		_, err := verifier.Verify(token)
+		assert.Error(t, err, name)
+	}
+
+	// Test case: Small clock skew is tolerated
+	_, err = verifier.Verify(sign(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"sub": "42", "exp": time.Now().Add(-10 * time.Second).Unix()}))
+	assert.NoError(t, err)
+}
+
+func TestVerifier_RS256(t *testing.T) {
+	current, _ := rsa.GenerateKey(rand.Reader, 2048)
+	previous, _ := rsa.GenerateKey(rand.Reader, 2048)
+	stranger, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	keys, err := auth.LoadJWKS(jwksFile(t, map[string]*rsa.PublicKey{"current": &current.PublicKey, "previous": &previous.PublicKey}))
+	assert.NoError(t, err)
+	assert.Len(t, keys, 2)
+
+	verifier, err := auth.NewVerifier(auth.VerifierConfig{RSAKeys: keys, Issuer: "https://issuer.example", Audience: "cleangrpc"})
+	assert.NoError(t, err)
+	claims := func() jwt.MapClaims {
+		c := validClaims("7", "user")
+		c["iss"] = "https://issuer.example"
+		c["aud"] = "cleangrpc"
+		return c
+	}
+
+	// Test case: Tokens signed by any key of the set are accepted by kid
+	for kid, key := range map[string]*rsa.PrivateKey{"current": current, "previous": previous} {
+		principal, err := verifier.Verify(sign(t, jwt.SigningMethodRS256, key, kid, claims()))
+		assert.NoError(t, err, kid)
+		assert.Equal(t, "7", principal.Subject)
+	}
+
+	// Test case: Unknown keys, wrong kids and wrong issuer or audience are rejected
+	wrongIssuer := claims()
+	wrongIssuer["iss"] = "https://evil.example"
+	wrongAudience := claims()
+	wrongAudience["aud"] = "other"
+	rejected := map[string]string{
+		"unknown key":    sign(t, jwt.SigningMethodRS256, stranger, "current", claims()),
+		"unknown kid":    sign(t, jwt.SigningMethodRS256, current, "rotated", claims()),
+		"no kid":         sign(t, jwt.SigningMethodRS256, current, "", claims()),
+		"wrong issuer":   sign(t, jwt.SigningMethodRS256, current, "current", wrongIssuer),
+		"wrong audience": sign(t, jwt.SigningMethodRS256, current, "current", wrongAudience),
+		// the public key used as an HMAC secret must not verify
+		"alg confusion": sign(t, jwt.SigningMethodHS256, current.PublicKey.N.Bytes(), "current", claims()),
+	}
+	for name, token := range rejected {
+		_, err := verifier.Verify(token)
+		assert.Error(t, err, name)
+	}
+
+	// Test case: A set with a single key does not need a kid
+	single, err := auth.NewVerifier(auth.VerifierConfig{RSAKeys: map[string]*rsa.PublicKey{"only": &current.PublicKey}})
+	assert.NoError(t, err)
+	_, err = single.Verify(sign(t, jwt.SigningMethodRS256, current, "", validClaims("7")))
+	assert.NoError(t, err)
+}
+
+func TestIssuer(t *testing.T) {
+	issuer, err := auth.NewIssuer(auth.IssuerConfig{
+		HMACSecret: secret, Issuer: "cleangrpc", Audience: "users", AccessTTL: 15 * time.Minute,
+	})
+	assert.NoError(t, err)
+	verifier, _ := auth.NewVerifier(auth.VerifierConfig{HMACSecret: secret, Issuer: "cleangrpc", Audience: "users"})
+
+	// Test case: The access token verifies as the principal it was issued to
+	start := time.Now()
+	token, expiresAt, err := issuer.Issue(auth.Principal{Subject: "7", Roles: []string{"user"}})
+	assert.NoError(t, err)
+	assert.WithinDuration(t, start.Add(15*time.Minute), expiresAt, 2*time.Second)
+	principal, err := verifier.Verify(token)
+	assert.NoError(t, err)
+	assert.Equal(t, &auth.Principal{Subject: "7", Roles: []string{"user"}}, principal)
+
+	// Test case: Signing needs a secret
+	_, err = auth.NewIssuer(auth.IssuerConfig{})
+	assert.ErrorIs(t, err, auth.ErrNoKeys)
+}
+
+func TestRefreshToken(t *testing.T) {
+	sessionID := "0b5cbd8e-5a8a-4b8f-9d3e-4c0f6a1f2e3d"
+
+	// Test case: Tokens name their session and are stored only as a hash
+	token, hash, err := auth.NewRefreshToken(sessionID)
+	assert.NoError(t, err)
+	assert.NotContains(t, hash, token)
+	assert.Equal(t, auth.HashRefreshToken(token), hash)
+	parsed, ok := auth.ParseRefreshToken(token)
+	assert.True(t, ok)
+	assert.Equal(t, sessionID, parsed)
+
+	// Test case: Every token of a session is different
+	again, againHash, _ := auth.NewRefreshToken(sessionID)
+	assert.NotEqual(t, token, again)
+	assert.NotEqual(t, hash, againHash)
+
+	// Test case: Anything else is not a refresh token
+	for _, bad := range []string{"", sessionID, "not-a-uuid." + token[len(sessionID)+1:], sessionID + ".short", sessionID + ".!!"} {
+		_, ok := auth.ParseRefreshToken(bad)
+		assert.False(t, ok, bad)
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	// Test case: Secrets are trimmed and must be long enough
+	loaded, err := auth.LoadHMACSecret(writeFile(t, "secret", append(secret, '\n')))
+	assert.NoError(t, err)
+	assert.Equal(t, secret, loaded)
+
+	_, err = auth.LoadHMACSecret(writeFile(t, "short", []byte("hunter2")))
+	assert.ErrorContains(t, err, "at least 32 bytes")
+
+	// Test case: A key set without RS256 signing keys is an error
+	_, err = auth.LoadJWKS(writeFile(t, "empty.json", []byte(`{"keys":[{"kty":"RSA","use":"enc","n":"AQAB","e":"AQAB"}]}`)))
+	assert.ErrorContains(t, err, "no RS256 signing keys")
+
+	_, err = auth.LoadJWKS(writeFile(t, "bad.json", []byte(`{"keys":[{"kty":"RSA","kid":"k","n":"!!","e":"AQAB"}]}`)))
+	assert.ErrorContains(t, err, "modulus")
+
+	// Test case: Without any key there is nothing to verify with
+	_, err = auth.NewVerifier(auth.VerifierConfig{})
+	assert.ErrorIs(t, err, auth.ErrNoKeys)
+}
+
+func TestPrincipalContext(t *testing.T) {
+	// Test case: A context without a principal reports none
+	_, ok := auth.PrincipalFromContext(context.Background())
+	assert.False(t, ok)
+
+	// Test case: The stored principal is returned
+	principal := &auth.Principal{Subject: "42"}
+	got, ok := auth.PrincipalFromContext(auth.WithPrincipal(context.Background(), principal))
+	assert.True(t, ok)
+	assert.Same(t, principal, got)
+}
@@ -0,0 +1,564 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// prefix of every environment variable the server reads
+const envPrefix = "CLEANGRPC_"
+
+// Config is everything the server needs to start. values are resolved in
+// increasing order of precedence from the defaults, the YAML config file,
+// CLEANGRPC_* environment variables and finally command line flags
+type Config struct {
+	ListenAddr        string        `yaml:"listen_addr"`
+	DatabaseDSN       string        `yaml:"database_dsn"`
+	LogLevel          string        `yaml:"log_level"`
+	ConnectionTimeout time.Duration `yaml:"connection_timeout"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout"`
+	HealthInterval    time.Duration `yaml:"health_interval"`
+	Reflection        bool          `yaml:"reflection"`
+	MetricsAddr       string        `yaml:"metrics_addr"`
+	Tracing           Tracing       `yaml:"tracing"`
+	Auth              Auth          `yaml:"auth"`
+	Authz             Authz         `yaml:"authz"`
+	TLS               TLS           `yaml:"tls"`
+	Mail              Mail          `yaml:"mail"`
+	Purge             Purge         `yaml:"purge"`
+}
+
+// Auth configures bearer token authentication. it is enabled by giving an
+// HS256 secret, an RS256 key set or both
+type Auth struct {
+	// file holding the HS256 shared secret
+	HMACSecretFile string `yaml:"hmac_secret_file"`
+	// JSON Web Key Set file with the RS256 public keys
+	JWKSFile string `yaml:"jwks_file"`
+	// required iss and aud claims, unchecked when empty
+	Issuer   string `yaml:"issuer"`
+	Audience string `yaml:"audience"`
+	// full method names callable without a token
+	PublicMethods []string `yaml:"public_methods"`
+	// lifetime of the access tokens signed with the HS256 secret and of an
+	// unused session, i.e. its refresh token
+	AccessTokenTTL  time.Duration `yaml:"access_token_ttl"`
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl"`
+	// how often expired sessions are deleted
+	SessionSweepInterval time.Duration `yaml:"session_sweep_interval"`
+	// failed logins in a row that lock an account, 0 never locks, and for
+	// how long
+	LockoutThreshold int           `yaml:"lockout_threshold"`
+	LockoutDuration  time.Duration `yaml:"lockout_duration"`
+}
+
+func (a Auth) Enabled() bool {
+	return a.HMACSecretFile != "" || a.JWKSFile != ""
+}
+
+// Tracing selects where OpenTelemetry spans are exported to
+type Tracing struct {
+	// none, stdout or otlp
+	Exporter string `yaml:"exporter"`
+	// host:port of the OTLP gRPC collector
+	Endpoint string `yaml:"endpoint"`
+	// talk to the collector without TLS
+	Insecure bool `yaml:"insecure"`
+}
+
+// Authz lists the roles allowed to call each method once authentication is
+// enabled. "*" admits every authenticated caller and "self" admits them to
+// their own user record only. methods without a rule are open to every
+// authenticated caller. rules are only read from the config file, a file
+// entry replaces the default rule of the same method
+type Authz struct {
+	Rules map[string][]string `yaml:"rules"`
+}
+
+// methods acting on a single user record, the only ones "self" can limit
+var selfMethods = map[string]bool{
+	"/UserService/GetUser":            true,
+	"/UserService/UpdateUser":         true,
+	"/UserService/DeleteUser":         true,
+	"/UserService/SetPassword":        true,
+	"/UserService/IssueSession":       true,
+	"/UserService/ListSessions":       true,
+	"/UserService/RevokeSession":      true,
+	"/UserService/ResendVerification": true,
+}
+
+// Mail selects how emails to users, e.g. to verify their address, are sent.
+// the none driver sends nothing, which also turns email verification off
+type Mail struct {
+	// none, stdout or file
+	Driver string `yaml:"driver"`
+	// file the file driver appends messages to
+	File string `yaml:"file"`
+	From string `yaml:"from"`
+	// link mailed for verifying an address with "{token}" standing in for
+	// the token, empty mails the bare token
+	VerifyURL string `yaml:"verify_url"`
+	// how long a verification token works
+	VerifyTokenTTL time.Duration `yaml:"verify_token_ttl"`
+	// sign-in link mailed by RequestMagicLink, "{token}" as in VerifyURL
+	MagicLinkURL string        `yaml:"magic_link_url"`
+	MagicLinkTTL time.Duration `yaml:"magic_link_ttl"`
+	// sign-in links that may be requested per email address in every
+	// window, 0 means no limit
+	MagicLinkRateLimit  int           `yaml:"magic_link_rate_limit"`
+	MagicLinkRateWindow time.Duration `yaml:"magic_link_rate_window"`
+}
+
+// Purge schedules the permanent removal of soft deleted users. until then
+// UndeleteUser can bring them back
+type Purge struct {
+	// how long a deleted user is kept, 0 keeps them until PurgeUser
+	Retention time.Duration `yaml:"retention"`
+	// how often users past the retention period are removed
+	Interval time.Duration `yaml:"interval"`
+}
+
+func (p Purge) Enabled() bool {
+	return p.Retention > 0
+}
+
+// drivers emails can be sent with
+const (
+	MailNone   = "none"
+	MailStdout = "stdout"
+	MailFile   = "file"
+)
+
+// exporters spans can be sent to
+const (
+	TracingNone   = "none"
+	TracingStdout = "stdout"
+	TracingOTLP   = "otlp"
+)
+
+// TLS holds the certificate and key the server presents. both empty means
+// the server speaks plaintext. ClientCAFile turns on client certificate
+// verification, which ClientAuth makes required or optional
+type TLS struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+	ClientAuth   string `yaml:"client_auth"`
+}
+
+// how client certificates are treated once a client CA is configured
+const (
+	ClientAuthRequire  = "require"
+	ClientAuthOptional = "optional"
+)
+
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != ""
+}
+
+// Default returns the configuration used when nothing overrides it
+func Default() *Config {
+	return &Config{
+		ListenAddr:        "localhost:50000",
+		DatabaseDSN:       "test.db",
+		LogLevel:          "info",
+		ConnectionTimeout: 120 * time.Second,
+		ShutdownTimeout:   30 * time.Second,
+		HealthInterval:    10 * time.Second,
+		Tracing: Tracing{
+			Exporter: TracingNone,
+			Endpoint: "localhost:4317",
+		},
+		Auth: Auth{
+			// load balancers and probes check health without credentials and
+			// users log in before they have a token
+			PublicMethods: []string{
+				"/grpc.health.v1.Health/Check",
+				"/grpc.health.v1.Health/Watch",
+				"/UserService/Login",
+				"/UserService/RefreshSession",
+				"/UserService/VerifyEmail",
+				"/UserService/RequestMagicLink",
+				"/UserService/RedeemMagicLink",
+			},
+			AccessTokenTTL:       15 * time.Minute,
+			RefreshTokenTTL:      30 * 24 * time.Hour,
+			SessionSweepInterval: time.Hour,
+			LockoutThreshold:     5,
+			LockoutDuration:      15 * time.Minute,
+		},
+		TLS: TLS{
+			ClientAuth: ClientAuthRequire,
+		},
+		Mail: Mail{
+			Driver:              MailStdout,
+			From:                "no-reply@localhost",
+			VerifyTokenTTL:      48 * time.Hour,
+			MagicLinkTTL:        15 * time.Minute,
+			MagicLinkRateLimit:  3,
+			MagicLinkRateWindow: 15 * time.Minute,
+		},
+		// no retention, permanently removing users is for operators to opt in to
+		Purge: Purge{
+			Interval: time.Hour,
+		},
+		Authz: Authz{
+			Rules: map[string][]string{
+				"/UserService/DeleteUser":         {"admin"},
+				"/UserService/UndeleteUser":       {"admin"},
+				"/UserService/PurgeUser":          {"admin"},
+				"/UserService/BatchCreateUsers":   {"admin"},
+				"/UserService/BatchUpdateUsers":   {"admin"},
+				"/UserService/BatchDeleteUsers":   {"admin"},
+				"/UserService/ImportUsers":        {"admin"},
+				"/UserService/GetUsersList":       {"admin", "*"},
+				"/UserService/UpdateUser":         {"admin", "self"},
+				"/UserService/SetPassword":        {"admin", "self"},
+				"/UserService/IssueSession":       {"admin"},
+				"/UserService/ListSessions":       {"admin", "self"},
+				"/UserService/RevokeSession":      {"admin", "self"},
+				"/UserService/ResendVerification": {"admin", "self"},
+			},
+		},
+	}
+}
+
+// setting binds one Config field to its flag and environment variable. the
+// yaml key of the field is the flag name with dashes turned into underscores
+type setting struct {
+	flag   string
+	usage  string
+	set    func(c *Config, value string) error
+	isBool bool
+}
+
+func (s setting) env() string {
+	return envPrefix + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(s.flag))
+}
+
+func stringSetting(name, usage string, field func(c *Config) *string) setting {
+	return setting{flag: name, usage: usage, set: func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}}
+}
+
+func durationSetting(name, usage string, field func(c *Config) *time.Duration) setting {
+	return setting{flag: name, usage: usage, set: func(c *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		*field(c) = d
+		return nil
+	}}
+}
+
+func intSetting(name, usage string, field func(c *Config) *int) setting {
+	return setting{flag: name, usage: usage, set: func(c *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		*field(c) = n
+		return nil
+	}}
+}
+
+func boolSetting(name, usage string, field func(c *Config) *bool) setting {
+	return setting{flag: name, usage: usage, isBool: true, set: func(c *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		*field(c) = b
+		return nil
+	}}
+}
+
+// listSetting takes a comma separated list, empty items are dropped
+func listSetting(name, usage string, field func(c *Config) *[]string) setting {
+	return setting{flag: name, usage: usage, set: func(c *Config, value string) error {
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*field(c) = items
+		return nil
+	}}
+}
+
+var settings = []setting{
+	stringSetting("listen-addr", "host:port the gRPC server listens on", func(c *Config) *string { return &c.ListenAddr }),
+	stringSetting("database-dsn", "sqlite data source name", func(c *Config) *string { return &c.DatabaseDSN }),
+	stringSetting("log-level", "debug, info, warn or error", func(c *Config) *string { return &c.LogLevel }),
+	durationSetting("connection-timeout", "deadline for new connections to finish the handshake", func(c *Config) *time.Duration { return &c.ConnectionTimeout }),
+	durationSetting("shutdown-timeout", "how long in-flight RPCs may drain on SIGINT/SIGTERM before they are cut off", func(c *Config) *time.Duration { return &c.ShutdownTimeout }),
+	durationSetting("health-interval", "how often the database is pinged to decide the health status", func(c *Config) *time.Duration { return &c.HealthInterval }),
+	boolSetting("reflection", "register the gRPC server reflection service", func(c *Config) *bool { return &c.Reflection }),
+	stringSetting("metrics-addr", "host:port of the admin HTTP listener serving /metrics, empty disables it", func(c *Config) *string { return &c.MetricsAddr }),
+	stringSetting("tracing.exporter", "where spans are exported: none, stdout or otlp", func(c *Config) *string { return &c.Tracing.Exporter }),
+	stringSetting("tracing.endpoint", "host:port of the OTLP gRPC collector", func(c *Config) *string { return &c.Tracing.Endpoint }),
+	boolSetting("tracing.insecure", "connect to the OTLP collector without TLS", func(c *Config) *bool { return &c.Tracing.Insecure }),
+	stringSetting("auth.hmac-secret-file", "file with the HS256 secret bearer tokens are signed with, enables authentication", func(c *Config) *string { return &c.Auth.HMACSecretFile }),
+	stringSetting("auth.jwks-file", "JSON Web Key Set file with the RS256 public keys, enables authentication", func(c *Config) *string { return &c.Auth.JWKSFile }),
+	stringSetting("auth.issuer", "required iss claim of bearer tokens", func(c *Config) *string { return &c.Auth.Issuer }),
+	stringSetting("auth.audience", "required aud claim of bearer tokens", func(c *Config) *string { return &c.Auth.Audience }),
+	listSetting("auth.public-methods", "comma separated full method names callable without a token", func(c *Config) *[]string { return &c.Auth.PublicMethods }),
+	durationSetting("auth.access-token-ttl", "lifetime of access tokens handed out for a session", func(c *Config) *time.Duration { return &c.Auth.AccessTokenTTL }),
+	durationSetting("auth.refresh-token-ttl", "how long a session lives without being refreshed", func(c *Config) *time.Duration { return &c.Auth.RefreshTokenTTL }),
+	durationSetting("auth.session-sweep-interval", "how often expired sessions are deleted", func(c *Config) *time.Duration { return &c.Auth.SessionSweepInterval }),
+	intSetting("auth.lockout-threshold", "failed logins in a row that lock an account, 0 disables lockout", func(c *Config) *int { return &c.Auth.LockoutThreshold }),
+	durationSetting("auth.lockout-duration", "how long a locked account refuses logins", func(c *Config) *time.Duration { return &c.Auth.LockoutDuration }),
+	stringSetting("tls.cert-file", "PEM certificate served to clients, enables TLS", func(c *Config) *string { return &c.TLS.CertFile }),
+	stringSetting("tls.key-file", "PEM private key of tls.cert-file", func(c *Config) *string { return &c.TLS.KeyFile }),
+	stringSetting("tls.client-ca-file", "PEM CA bundle client certificates are verified against, enables mutual TLS", func(c *Config) *string { return &c.TLS.ClientCAFile }),
+	stringSetting("tls.client-auth", "require or optional client certificates once tls.client-ca-file is set", func(c *Config) *string { return &c.TLS.ClientAuth }),
+	stringSetting("mail.driver", "how emails are sent: none, stdout or file. none turns email verification off", func(c *Config) *string { return &c.Mail.Driver }),
+	stringSetting("mail.file", "file the file driver appends emails to", func(c *Config) *string { return &c.Mail.File }),
+	stringSetting("mail.from", "sender address of emails", func(c *Config) *string { return &c.Mail.From }),
+	stringSetting("mail.verify-url", "link mailed to verify an address, {token} is replaced by the token", func(c *Config) *string { return &c.Mail.VerifyURL }),
+	durationSetting("mail.verify-token-ttl", "how long an email verification token works", func(c *Config) *time.Duration { return &c.Mail.VerifyTokenTTL }),
+	stringSetting("mail.magic-link-url", "sign-in link mailed by RequestMagicLink, {token} is replaced by the token", func(c *Config) *string { return &c.Mail.MagicLinkURL }),
+	durationSetting("mail.magic-link-ttl", "how long a sign-in link works", func(c *Config) *time.Duration { return &c.Mail.MagicLinkTTL }),
+	intSetting("mail.magic-link-rate-limit", "sign-in links that may be requested per email in every window, 0 means no limit", func(c *Config) *int { return &c.Mail.MagicLinkRateLimit }),
+	durationSetting("mail.magic-link-rate-window", "window mail.magic-link-rate-limit applies to", func(c *Config) *time.Duration { return &c.Mail.MagicLinkRateWindow }),
+	durationSetting("purge.retention", "how long deleted users are kept before they are purged, 0 keeps them until PurgeUser", func(c *Config) *time.Duration { return &c.Purge.Retention }),
+	durationSetting("purge.interval", "how often deleted users past the retention period are purged", func(c *Config) *time.Duration { return &c.Purge.Interval }),
+}
+
+// Load resolves the configuration from args (without the program name),
+// getenv and the config file named by -config or CLEANGRPC_CONFIG. the
+// result is validated and every problem found is reported at once
+func Load(args []string, getenv func(string) string) (*Config, error) {
+	fs := flag.NewFlagSet("cleangrpc", flag.ContinueOnError)
+	configFile := fs.String("config", getenv(envPrefix+"CONFIG"), "path to a YAML config file")
+
+	// flags are applied last, remember the ones given in the order they came
+	type flagValue struct {
+		setting setting
+		value   string
+	}
+	var given []flagValue
+	for _, s := range settings {
+		usage := fmt.Sprintf("%s (env %s)", s.usage, s.env())
+		record := func(value string) error {
+			given = append(given, flagValue{s, value})
+			return nil
+		}
+		if s.isBool {
+			fs.BoolFunc(s.flag, usage, record)
+		} else {
+			fs.Func(s.flag, usage, record)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	cfg := Default()
+	if *configFile != "" {
+		if err := loadFile(cfg, *configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if value := getenv(s.env()); value != "" {
+			if err := s.set(cfg, value); err != nil {
+				return nil, fmt.Errorf("environment %s: %w", s.env(), err)
+			}
+		}
+	}
+
+	for _, f := range given {
+		if err := f.setting.set(cfg, f.value); err != nil {
+			return nil, fmt.Errorf("flag -%w", err)
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile overlays the YAML file at path onto cfg. unknown keys are an error
+// so a typo does not silently fall back to a default
+func loadFile(cfg *Config, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate reports every setting that would stop the server from starting
+func (c *Config) Validate() error {
+	var problems []error
+
+	if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		problems = append(problems, fmt.Errorf("listen_addr %q: %w", c.ListenAddr, err))
+	}
+	if c.DatabaseDSN == "" {
+		problems = append(problems, errors.New("database_dsn must not be empty"))
+	}
+	if _, err := c.SlogLevel(); err != nil {
+		problems = append(problems, err)
+	}
+	if c.ConnectionTimeout <= 0 {
+		problems = append(problems, fmt.Errorf("connection_timeout must be positive, got %s", c.ConnectionTimeout))
+	}
+	if c.ShutdownTimeout <= 0 {
+		problems = append(problems, fmt.Errorf("shutdown_timeout must be positive, got %s", c.ShutdownTimeout))
+	}
+	if c.HealthInterval <= 0 {
+		problems = append(problems, fmt.Errorf("health_interval must be positive, got %s", c.HealthInterval))
+	}
+	if c.MetricsAddr != "" {
+		if _, _, err := net.SplitHostPort(c.MetricsAddr); err != nil {
+			problems = append(problems, fmt.Errorf("metrics_addr %q: %w", c.MetricsAddr, err))
+		}
+	}
+	switch c.Tracing.Exporter {
+	case TracingNone, TracingStdout:
+	case TracingOTLP:
+		if _, _, err := net.SplitHostPort(c.Tracing.Endpoint); err != nil {
+			problems = append(problems, fmt.Errorf("tracing.endpoint %q: %w", c.Tracing.Endpoint, err))
+		}
+	default:
+		problems = append(problems, fmt.Errorf("tracing.exporter %q: must be none, stdout or otlp", c.Tracing.Exporter))
+	}
+	for _, file := range []string{c.Auth.HMACSecretFile, c.Auth.JWKSFile} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			problems = append(problems, fmt.Errorf("auth: %w", err))
+		}
+	}
+	for _, method := range c.Auth.PublicMethods {
+		if !validMethod(method) {
+			problems = append(problems, fmt.Errorf("auth.public_methods %q: must look like /package.Service/Method", method))
+		}
+	}
+	if c.Auth.AccessTokenTTL <= 0 {
+		problems = append(problems, fmt.Errorf("auth.access_token_ttl must be positive, got %s", c.Auth.AccessTokenTTL))
+	}
+	if c.Auth.RefreshTokenTTL <= 0 {
+		problems = append(problems, fmt.Errorf("auth.refresh_token_ttl must be positive, got %s", c.Auth.RefreshTokenTTL))
+	}
+	if c.Auth.SessionSweepInterval <= 0 {
+		problems = append(problems, fmt.Errorf("auth.session_sweep_interval must be positive, got %s", c.Auth.SessionSweepInterval))
+	}
+	if c.Auth.LockoutThreshold < 0 {
+		problems = append(problems, fmt.Errorf("auth.lockout_threshold must not be negative, got %d", c.Auth.LockoutThreshold))
+	}
+	if c.Auth.LockoutThreshold > 0 && c.Auth.LockoutDuration <= 0 {
+		problems = append(problems, fmt.Errorf("auth.lockout_duration must be positive, got %s", c.Auth.LockoutDuration))
+	}
+	for method, roles := range c.Authz.Rules {
+		if !validMethod(method) {
+			problems = append(problems, fmt.Errorf("authz.rules %q: must look like /package.Service/Method", method))
+		}
+		if len(roles) == 0 {
+			problems = append(problems, fmt.Errorf("authz.rules %q: no roles listed, the method could never be called", method))
+		}
+		if slices.Contains(roles, "self") && !selfMethods[method] {
+			problems = append(problems, fmt.Errorf("authz.rules %q: self only applies to methods acting on a single user", method))
+		}
+	}
+
+	if c.TLS.Enabled() {
+		if c.TLS.CertFile == "" || c.TLS.KeyFile == "" {
+			problems = append(problems, errors.New("tls.cert_file and tls.key_file must be set together"))
+		}
+		for _, file := range []string{c.TLS.CertFile, c.TLS.KeyFile, c.TLS.ClientCAFile} {
+			if file == "" {
+				continue
+			}
+			if _, err := os.Stat(file); err != nil {
+				problems = append(problems, fmt.Errorf("tls: %w", err))
+			}
+		}
+	} else if c.TLS.ClientCAFile != "" {
+		problems = append(problems, errors.New("tls.client_ca_file needs tls.cert_file and tls.key_file"))
+	}
+	if c.TLS.ClientAuth != ClientAuthRequire && c.TLS.ClientAuth != ClientAuthOptional {
+		problems = append(problems, fmt.Errorf("tls.client_auth %q: must be require or optional", c.TLS.ClientAuth))
+	}
+
+	switch c.Mail.Driver {
+	case MailNone, MailStdout:
+	case MailFile:
+		if c.Mail.File == "" {
+			problems = append(problems, errors.New("mail.file must be set for the file driver"))
+		}
+	default:
+		problems = append(problems, fmt.Errorf("mail.driver %q: must be none, stdout or file", c.Mail.Driver))
+	}
+	if c.Mail.VerifyURL != "" && !strings.Contains(c.Mail.VerifyURL, "{token}") {
+		problems = append(problems, fmt.Errorf("mail.verify_url %q: must contain {token}", c.Mail.VerifyURL))
+	}
+	if c.Mail.VerifyTokenTTL <= 0 {
+		problems = append(problems, fmt.Errorf("mail.verify_token_ttl must be positive, got %s", c.Mail.VerifyTokenTTL))
+	}
+	if c.Mail.MagicLinkURL != "" && !strings.Contains(c.Mail.MagicLinkURL, "{token}") {
+		problems = append(problems, fmt.Errorf("mail.magic_link_url %q: must contain {token}", c.Mail.MagicLinkURL))
+	}
+	if c.Mail.MagicLinkTTL <= 0 {
+		problems = append(problems, fmt.Errorf("mail.magic_link_ttl must be positive, got %s", c.Mail.MagicLinkTTL))
+	}
+	if c.Mail.MagicLinkRateLimit < 0 {
+		problems = append(problems, fmt.Errorf("mail.magic_link_rate_limit must not be negative, got %d", c.Mail.MagicLinkRateLimit))
+	}
+	if c.Mail.MagicLinkRateLimit > 0 && c.Mail.MagicLinkRateWindow <= 0 {
+		problems = append(problems, fmt.Errorf("mail.magic_link_rate_window must be positive, got %s", c.Mail.MagicLinkRateWindow))
+	}
+	if c.Purge.Retention < 0 {
+		problems = append(problems, fmt.Errorf("purge.retention must not be negative, got %s", c.Purge.Retention))
+	}
+	if c.Purge.Enabled() && c.Purge.Interval <= 0 {
+		problems = append(problems, fmt.Errorf("purge.interval must be positive, got %s", c.Purge.Interval))
+	}
+
+	return errors.Join(problems...)
+}
+
+// validMethod reports whether method is a full gRPC method name
+func validMethod(method string) bool {
+	service, name, ok := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	return strings.HasPrefix(method, "/") && ok && service != "" && name != ""
+}
+
+// SlogLevel converts LogLevel into the matching slog level
+func (c *Config) SlogLevel() (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		return level, fmt.Errorf("log_level %q: must be debug, info, warn or error", c.LogLevel)
+	}
+	return level, nil
+}
@@ -0,0 +1,400 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yishak-cs/CleanGrpc/Internal/config"
+)
+
+// env builds a getenv function backed by a map
+func env(vars map[string]string) func(string) string {
+	return func(key string) string { return vars[key] }
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, err := config.Load(nil, env(nil))
+
+	assert.NoError(t, err)
+	assert.Equal(t, config.Default(), cfg)
+	assert.Equal(t, "localhost:50000", cfg.ListenAddr)
+	assert.Equal(t, "test.db", cfg.DatabaseDSN)
+	assert.False(t, cfg.TLS.Enabled())
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	file := writeFile(t, "config.yaml", `
+listen_addr: "0.0.0.0:6000"
+database_dsn: from-file.db
+log_level: debug
+connection_timeout: 5s
+`)
+
+	// Test case: The file overrides the defaults
+	cfg, err := config.Load([]string{"-config", file}, env(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, "0.0.0.0:6000", cfg.ListenAddr)
+	assert.Equal(t, "from-file.db", cfg.DatabaseDSN)
+	assert.Equal(t, "debug", cfg.LogLevel)
+	assert.Equal(t, 5*time.Second, cfg.ConnectionTimeout)
+
+	// Test case: The environment overrides the file, which it can also name
+	vars := map[string]string{
+		"CLEANGRPC_CONFIG":       file,
+		"CLEANGRPC_DATABASE_DSN": "from-env.db",
+		"CLEANGRPC_LOG_LEVEL":    "warn",
+	}
+	cfg, err = config.Load(nil, env(vars))
+	assert.NoError(t, err)
+	assert.Equal(t, "0.0.0.0:6000", cfg.ListenAddr)
+	assert.Equal(t, "from-env.db", cfg.DatabaseDSN)
+	assert.Equal(t, "warn", cfg.LogLevel)
+
+	// Test case: Flags override everything
+	cfg, err = config.Load([]string{"-database-dsn", "from-flag.db", "-connection-timeout=1m"}, env(vars))
+	assert.NoError(t, err)
+	assert.Equal(t, "from-flag.db", cfg.DatabaseDSN)
+	assert.Equal(t, "warn", cfg.LogLevel)
+	assert.Equal(t, time.Minute, cfg.ConnectionTimeout)
+}
+
+func TestLoad_Health(t *testing.T) {
+	// Test case: Reflection is off and the database is pinged every 10s by default
+	cfg, err := config.Load(nil, env(nil))
+	assert.NoError(t, err)
+	assert.False(t, cfg.Reflection)
+	assert.Equal(t, 10*time.Second, cfg.HealthInterval)
+
+	// Test case: A bare boolean flag turns reflection on
+	cfg, err = config.Load([]string{"-reflection", "-health-interval", "2s"}, env(nil))
+	assert.NoError(t, err)
+	assert.True(t, cfg.Reflection)
+	assert.Equal(t, 2*time.Second, cfg.HealthInterval)
+
+	// Test case: The flag beats the environment
+	cfg, err = config.Load([]string{"-reflection=false"}, env(map[string]string{"CLEANGRPC_REFLECTION": "true"}))
+	assert.NoError(t, err)
+	assert.False(t, cfg.Reflection)
+
+	_, err = config.Load(nil, env(map[string]string{"CLEANGRPC_REFLECTION": "maybe"}))
+	assert.ErrorContains(t, err, "CLEANGRPC_REFLECTION")
+}
+
+func TestLoad_Metrics(t *testing.T) {
+	// Test case: The admin listener is off by default
+	cfg, err := config.Load(nil, env(nil))
+	assert.NoError(t, err)
+	assert.Empty(t, cfg.MetricsAddr)
+
+	// Test case: It is enabled by giving it an address
+	cfg, err = config.Load(nil, env(map[string]string{"CLEANGRPC_METRICS_ADDR": ":9090"}))
+	assert.NoError(t, err)
+	assert.Equal(t, ":9090", cfg.MetricsAddr)
+}
+
+func TestLoad_Tracing(t *testing.T) {
+	// Test case: Tracing is off by default
+	cfg, err := config.Load(nil, env(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, config.TracingNone, cfg.Tracing.Exporter)
+
+	// Test case: The OTLP exporter is configured from the file and flags
+	file := writeFile(t, "config.yaml", `
+tracing:
+  exporter: otlp
+  endpoint: collector:4317
+`)
+	cfg, err = config.Load([]string{"-config", file, "-tracing.insecure"}, env(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, config.Tracing{Exporter: config.TracingOTLP, Endpoint: "collector:4317", Insecure: true}, cfg.Tracing)
+
+	// Test case: Unknown exporters and bad endpoints are rejected
+	_, err = config.Load([]string{"-tracing.exporter", "jaeger"}, env(nil))
+	assert.ErrorContains(t, err, "tracing.exporter")
+
+	_, err = config.Load(nil, env(map[string]string{
+		"CLEANGRPC_TRACING_EXPORTER": "otlp",
+		"CLEANGRPC_TRACING_ENDPOINT": "collector",
+	}))
+	assert.ErrorContains(t, err, "tracing.endpoint")
+}
+
+func TestLoad_Auth(t *testing.T) {
+	// Test case: Authentication is off and only health checks and the sign-in methods are public by default
+	cfg, err := config.Load(nil, env(nil))
+	assert.NoError(t, err)
+	assert.False(t, cfg.Auth.Enabled())
+	assert.Equal(t, []string{
+		"/grpc.health.v1.Health/Check",
+		"/grpc.health.v1.Health/Watch",
+		"/UserService/Login",
+		"/UserService/RefreshSession",
+		"/UserService/VerifyEmail",
+		"/UserService/RequestMagicLink",
+		"/UserService/RedeemMagicLink",
+	}, cfg.Auth.PublicMethods)
+
+	// Test case: A key source enables it and lists come from the file or comma separated
+	secret := writeFile(t, "secret", "0123456789abcdef0123456789abcdef")
+	file := writeFile(t, "config.yaml", `
+auth:
+  hmac_secret_file: `+secret+`
+  issuer: https://issuer.example
+  public_methods:
+    - /grpc.health.v1.Health/Check
+`)
+	cfg, err = config.Load([]string{"-config", file}, env(nil))
+	assert.NoError(t, err)
+	assert.True(t, cfg.Auth.Enabled())
+	assert.Equal(t, "https://issuer.example", cfg.Auth.Issuer)
+	assert.Equal(t, []string{"/grpc.health.v1.Health/Check"}, cfg.Auth.PublicMethods)
+
+	cfg, err = config.Load([]string{"-config", file, "-auth.public-methods", " /UserService/GetUser, ,/UserService/ListUsers"}, env(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/UserService/GetUser", "/UserService/ListUsers"}, cfg.Auth.PublicMethods)
+
+	// Test case: Missing key files and malformed method names are rejected
+	_, err = config.Load([]string{
+		"-auth.jwks-file", filepath.Join(t.TempDir(), "jwks.json"),
+		"-auth.public-methods", "UserService/GetUser",
+	}, env(nil))
+	assert.ErrorContains(t, err, "jwks.json")
+	assert.ErrorContains(t, err, "auth.public_methods")
+}
+
+func TestLoad_Login(t *testing.T) {
+	// Test case: Short access tokens, month long refresh tokens and five strikes by default
+	cfg, err := config.Load(nil, env(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 15*time.Minute, cfg.Auth.AccessTokenTTL)
+	assert.Equal(t, 720*time.Hour, cfg.Auth.RefreshTokenTTL)
+	assert.Equal(t, time.Hour, cfg.Auth.SessionSweepInterval)
+	assert.Equal(t, 5, cfg.Auth.LockoutThreshold)
+	assert.Equal(t, 15*time.Minute, cfg.Auth.LockoutDuration)
+
+	// Test case: Environment and flags override them
+	cfg, err = config.Load([]string{"-auth.lockout-threshold", "0"}, env(map[string]string{
+		"CLEANGRPC_AUTH_ACCESS_TOKEN_TTL": "5m",
+		"CLEANGRPC_AUTH_LOCKOUT_DURATION": "1h",
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, cfg.Auth.AccessTokenTTL)
+	assert.Equal(t, 0, cfg.Auth.LockoutThreshold)
+	assert.Equal(t, time.Hour, cfg.Auth.LockoutDuration)
+
+	// Test case: Non positive lifetimes and bad thresholds are rejected
+	_, err = config.Load([]string{"-auth.refresh-token-ttl", "0s", "-auth.session-sweep-interval", "0s", "-auth.lockout-threshold", "-1"}, env(nil))
+	assert.ErrorContains(t, err, "auth.refresh_token_ttl")
+	assert.ErrorContains(t, err, "auth.session_sweep_interval")
+	assert.ErrorContains(t, err, "auth.lockout_threshold")
+
+	_, err = config.Load([]string{"-auth.lockout-threshold", "three"}, env(nil))
+	assert.ErrorContains(t, err, "auth.lockout-threshold")
+}
+
+func TestLoad_Mail(t *testing.T) {
+	// Test case: Emails are printed to stdout and verification tokens last two days by default
+	cfg, err := config.Load(nil, env(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, config.MailStdout, cfg.Mail.Driver)
+	assert.Equal(t, 48*time.Hour, cfg.Mail.VerifyTokenTTL)
+	assert.Empty(t, cfg.Mail.VerifyURL)
+
+	// Test case: Sign-in links last 15 minutes and three may be asked for every 15 minutes by default
+	assert.Equal(t, 15*time.Minute, cfg.Mail.MagicLinkTTL)
+	assert.Equal(t, 3, cfg.Mail.MagicLinkRateLimit)
+	assert.Equal(t, 15*time.Minute, cfg.Mail.MagicLinkRateWindow)
+
+	// Test case: The file driver writes where it is told
+	cfg, err = config.Load([]string{"-mail.driver", "file", "-mail.verify-url", "https://app.example/verify?t={token}"}, env(map[string]string{
+		"CLEANGRPC_MAIL_FILE": "mail.txt",
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, "mail.txt", cfg.Mail.File)
+	assert.Equal(t, "https://app.example/verify?t={token}", cfg.Mail.VerifyURL)
+
+	// Test case: Unknown drivers, a file driver without a file and links without a token are rejected
+	_, err = config.Load([]string{"-mail.driver", "smtp", "-mail.verify-url", "https://app.example/verify", "-mail.verify-token-ttl", "0s"}, env(nil))
+	assert.ErrorContains(t, err, "mail.driver")
+	assert.ErrorContains(t, err, "mail.verify_url")
+	assert.ErrorContains(t, err, "mail.verify_token_ttl")
+	_, err = config.Load([]string{"-mail.driver", "file"}, env(nil))
+	assert.ErrorContains(t, err, "mail.file")
+
+	_, err = config.Load([]string{"-mail.magic-link-url", "https://app.example/signin", "-mail.magic-link-rate-limit", "-1"}, env(nil))
+	assert.ErrorContains(t, err, "mail.magic_link_url")
+	assert.ErrorContains(t, err, "mail.magic_link_rate_limit")
+	_, err = config.Load([]string{"-mail.magic-link-rate-window", "0s"}, env(map[string]string{"CLEANGRPC_MAIL_MAGIC_LINK_TTL": "-1m"}))
+	assert.ErrorContains(t, err, "mail.magic_link_rate_window")
+	assert.ErrorContains(t, err, "mail.magic_link_ttl")
+}
+
+func TestLoad_Purge(t *testing.T) {
+	// Test case: Deleted users are kept until purged by hand by default
+	cfg, err := config.Load(nil, env(nil))
+	assert.NoError(t, err)
+	assert.False(t, cfg.Purge.Enabled())
+	assert.Equal(t, time.Duration(0), cfg.Purge.Retention)
+	assert.Equal(t, time.Hour, cfg.Purge.Interval)
+
+	// Test case: A retention turns the scheduled purge on
+	cfg, err = config.Load([]string{"-purge.retention", "720h"}, env(nil))
+	assert.NoError(t, err)
+	assert.True(t, cfg.Purge.Enabled())
+	assert.Equal(t, 720*time.Hour, cfg.Purge.Retention)
+
+	// Test case: A zero interval is fine while the scheduled purge is off
+	_, err = config.Load([]string{"-purge.interval", "0s"}, env(nil))
+	assert.NoError(t, err)
+
+	// Test case: Negative retentions and non positive intervals are rejected
+	_, err = config.Load([]string{"-purge.interval", "0s"}, env(map[string]string{"CLEANGRPC_PURGE_RETENTION": "-1h"}))
+	assert.ErrorContains(t, err, "purge.retention")
+	_, err = config.Load([]string{"-purge.retention", "720h", "-purge.interval", "0s"}, env(nil))
+	assert.ErrorContains(t, err, "purge.interval")
+}
+
+func TestLoad_Authz(t *testing.T) {
+	// Test case: Deletes, restores, purges, batches, imports, issuing sessions and listing deleted users are for admins, updates, passwords and other session methods for admins or the owner by default
+	cfg, err := config.Load(nil, env(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"/UserService/DeleteUser":         {"admin"},
+		"/UserService/UndeleteUser":       {"admin"},
+		"/UserService/PurgeUser":          {"admin"},
+		"/UserService/BatchCreateUsers":   {"admin"},
+		"/UserService/BatchUpdateUsers":   {"admin"},
+		"/UserService/BatchDeleteUsers":   {"admin"},
+		"/UserService/ImportUsers":        {"admin"},
+		"/UserService/GetUsersList":       {"admin", "*"},
+		"/UserService/UpdateUser":         {"admin", "self"},
+		"/UserService/SetPassword":        {"admin", "self"},
+		"/UserService/IssueSession":       {"admin"},
+		"/UserService/ListSessions":       {"admin", "self"},
+		"/UserService/RevokeSession":      {"admin", "self"},
+		"/UserService/ResendVerification": {"admin", "self"},
+	}, cfg.Authz.Rules)
+
+	// Test case: File rules replace the default of their method and add new ones
+	file := writeFile(t, "config.yaml", `
+authz:
+  rules:
+    /UserService/DeleteUser: [admin, support]
+    /UserService/CreateUser: [admin]
+`)
+	cfg, err = config.Load([]string{"-config", file}, env(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"/UserService/DeleteUser":         {"admin", "support"},
+		"/UserService/UndeleteUser":       {"admin"},
+		"/UserService/PurgeUser":          {"admin"},
+		"/UserService/BatchCreateUsers":   {"admin"},
+		"/UserService/BatchUpdateUsers":   {"admin"},
+		"/UserService/BatchDeleteUsers":   {"admin"},
+		"/UserService/ImportUsers":        {"admin"},
+		"/UserService/GetUsersList":       {"admin", "*"},
+		"/UserService/UpdateUser":         {"admin", "self"},
+		"/UserService/SetPassword":        {"admin", "self"},
+		"/UserService/IssueSession":       {"admin"},
+		"/UserService/ListSessions":       {"admin", "self"},
+		"/UserService/RevokeSession":      {"admin", "self"},
+		"/UserService/ResendVerification": {"admin", "self"},
+		"/UserService/CreateUser":         {"admin"},
+	}, cfg.Authz.Rules)
+
+	// Test case: Empty role lists and self on methods without an owner are rejected
+	file = writeFile(t, "bad.yaml", `
+authz:
+  rules:
+    /UserService/GetUser: []
+    /UserService/ListUsers: [self]
+    UserService.CreateUser: [admin]
+`)
+	_, err = config.Load([]string{"-config", file}, env(nil))
+	assert.ErrorContains(t, err, `"/UserService/GetUser": no roles listed`)
+	assert.ErrorContains(t, err, `"/UserService/ListUsers": self only applies`)
+	assert.ErrorContains(t, err, `"UserService.CreateUser": must look like`)
+}
+
+func TestLoad_Errors(t *testing.T) {
+	// Test case: Unknown keys in the file are rejected
+	file := writeFile(t, "typo.yaml", "listen_adr: \":1\"\n")
+	_, err := config.Load([]string{"-config", file}, env(nil))
+	assert.ErrorContains(t, err, "listen_adr")
+
+	// Test case: A missing config file is an error
+	_, err = config.Load([]string{"-config", filepath.Join(t.TempDir(), "missing.yaml")}, env(nil))
+	assert.Error(t, err)
+
+	// Test case: Malformed values are reported with their source
+	_, err = config.Load(nil, env(map[string]string{"CLEANGRPC_CONNECTION_TIMEOUT": "soon"}))
+	assert.ErrorContains(t, err, "CLEANGRPC_CONNECTION_TIMEOUT")
+
+	_, err = config.Load([]string{"-no-such-flag"}, env(nil))
+	assert.Error(t, err)
+
+	// Test case: Every validation problem is reported at once
+	_, err = config.Load([]string{
+		"-listen-addr", "no-port",
+		"-database-dsn", "",
+		"-log-level", "loud",
+		"-connection-timeout", "0s",
+		"-shutdown-timeout", "-1s",
+		"-health-interval", "0s",
+		"-metrics-addr", "9090",
+		"-tls.cert-file", "missing.pem",
+	}, env(nil))
+	assert.ErrorContains(t, err, "listen_addr")
+	assert.ErrorContains(t, err, "database_dsn")
+	assert.ErrorContains(t, err, "log_level")
+	assert.ErrorContains(t, err, "connection_timeout")
+	assert.ErrorContains(t, err, "shutdown_timeout")
+	assert.ErrorContains(t, err, "health_interval")
+	assert.ErrorContains(t, err, "metrics_addr")
+	assert.ErrorContains(t, err, "tls.cert_file and tls.key_file must be set together")
+	assert.ErrorContains(t, err, "missing.pem")
+}
+
+func TestLoad_TLS(t *testing.T) {
+	cert := writeFile(t, "cert.pem", "cert")
+	key := writeFile(t, "key.pem", "key")
+
+	cfg, err := config.Load(nil, env(map[string]string{
+		"CLEANGRPC_TLS_CERT_FILE": cert,
+		"CLEANGRPC_TLS_KEY_FILE":  key,
+	}))
+
+	assert.NoError(t, err)
+	assert.True(t, cfg.TLS.Enabled())
+	assert.Equal(t, cert, cfg.TLS.CertFile)
+	assert.Equal(t, key, cfg.TLS.KeyFile)
+	assert.Empty(t, cfg.TLS.ClientCAFile)
+	assert.Equal(t, config.ClientAuthRequire, cfg.TLS.ClientAuth)
+
+	// Test case: A client CA bundle turns on mutual TLS, optionally
+	ca := writeFile(t, "ca.pem", "ca")
+	cfg, err = config.Load([]string{
+		"-tls.cert-file", cert, "-tls.key-file", key,
+		"-tls.client-ca-file", ca, "-tls.client-auth", "optional",
+	}, env(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, ca, cfg.TLS.ClientCAFile)
+	assert.Equal(t, config.ClientAuthOptional, cfg.TLS.ClientAuth)
+
+	// Test case: Client verification without server TLS and unknown modes are rejected
+	_, err = config.Load([]string{"-tls.client-ca-file", ca, "-tls.client-auth", "sometimes"}, env(nil))
+	assert.ErrorContains(t, err, "tls.client_ca_file needs tls.cert_file and tls.key_file")
+	assert.ErrorContains(t, err, "tls.client_auth")
+}
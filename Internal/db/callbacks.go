@@ -0,0 +1,29 @@
+package db
+
+import "gorm.io/gorm"
+
+// registrar is a position in one of GORM's callback chains
+type registrar interface {
+	Register(name string, fn func(*gorm.DB)) error
+}
+
+// operationHook is the position just before and just after GORM runs the
+// statement of one operation, so hooks and scanning around it are left out
+type operationHook struct {
+	operation     string
+	before, after registrar
+}
+
+// operationHooks lists the hook positions of every operation GORM executes
+// statements for
+func operationHooks(db *gorm.DB) []operationHook {
+	cb := db.Callback()
+	return []operationHook{
+		{"create", cb.Create().Before("gorm:create"), cb.Create().After("gorm:create")},
+		{"query", cb.Query().Before("gorm:query"), cb.Query().After("gorm:query")},
+		{"update", cb.Update().Before("gorm:update"), cb.Update().After("gorm:update")},
+		{"delete", cb.Delete().Before("gorm:delete"), cb.Delete().After("gorm:delete")},
+		{"row", cb.Row().Before("gorm:row"), cb.Row().After("gorm:row")},
+		{"raw", cb.Raw().Before("gorm:raw"), cb.Raw().After("gorm:raw")},
+	}
+}
@@ -8,11 +8,23 @@ import (
 	"gorm.io/gorm"
 )
 
-func DBconn() *gorm.DB {
-	db, err := gorm.Open(sqlite.Open("test.db"), &gorm.Config{})
+// DBconn opens the sqlite database at dsn and migrates it
+func DBconn(dsn string) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
 	if err != nil {
 		log.Fatalf("There was error connecting to the database: %v", err)
 	}
-	db.AutoMigrate(&model.User{})
+	if err := Migrate(db); err != nil {
+		log.Fatalf("There was error migrating the database: %v", err)
+	}
 	return db
 }
+
+// Migrate brings the schema up to date: the GORM models first, then the
+// full-text search index that shadows the users table
+func Migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(&model.User{}, &model.Session{}, &model.EmailToken{}, &model.MagicLinkToken{}); err != nil {
+		return err
+	}
+	return migrateSearch(db)
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"gorm.io/gorm"
+)
+
+// key the start time of a statement is kept under between the callbacks
+const metricsStartKey = "cleangrpc:metrics_start"
+
+// Metrics observes how long GORM statements take and how often they fail
+type Metrics struct {
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+}
+
+// RegisterMetrics hooks query duration and error metrics into every GORM
+// operation on db and exports the sql.DB connection pool stats, all
+// registered with reg
+func RegisterMetrics(db *gorm.DB, reg prometheus.Registerer) error {
+	m := &Metrics{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "cleangrpc",
+			Subsystem: "db",
+			Name:      "query_duration_seconds",
+			Help:      "Time spent executing GORM statements, by operation.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}, []string{"operation"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "cleangrpc",
+			Subsystem: "db",
+			Name:      "query_errors_total",
+			Help:      "GORM statements that failed, by operation. record not found is not an error.",
+		}, []string{"operation"}),
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	for _, c := range []prometheus.Collector{m.duration, m.errors, collectors.NewDBStatsCollector(sqlDB, "cleangrpc")} {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+
+	for _, h := range operationHooks(db) {
+		if err := h.before.Register("cleangrpc:metrics_before_"+h.operation, m.start); err != nil {
+			return err
+		}
+		if err := h.after.Register("cleangrpc:metrics_after_"+h.operation, m.finish(h.operation)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Metrics) start(tx *gorm.DB) {
+	tx.InstanceSet(metricsStartKey, time.Now())
+}
+
+func (m *Metrics) finish(operation string) func(*gorm.DB) {
+	return func(tx *gorm.DB) {
+		if v, ok := tx.InstanceGet(metricsStartKey); ok {
+			m.duration.WithLabelValues(operation).Observe(time.Since(v.(time.Time)).Seconds())
+		}
+		if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			m.errors.WithLabelValues(operation).Inc()
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// SearchTable is the FTS5 index over users.name and users.email. it is an
+// external content table, the text itself lives in users and the triggers
+// below keep the index in step with every insert, update and delete
+const SearchTable = "users_fts"
+
+var searchSchema = []string{
+	`CREATE VIRTUAL TABLE IF NOT EXISTS users_fts USING fts5(
+		name, email,
+		content='users', content_rowid='id',
+		tokenize='unicode61 remove_diacritics 2'
+	)`,
+	`CREATE TRIGGER IF NOT EXISTS users_fts_insert AFTER INSERT ON users BEGIN
+		INSERT INTO users_fts(rowid, name, email) VALUES (new.id, new.name, new.email);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS users_fts_delete AFTER DELETE ON users BEGIN
+		INSERT INTO users_fts(users_fts, rowid, name, email) VALUES ('delete', old.id, old.name, old.email);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS users_fts_update AFTER UPDATE ON users BEGIN
+		INSERT INTO users_fts(users_fts, rowid, name, email) VALUES ('delete', old.id, old.name, old.email);
+		INSERT INTO users_fts(rowid, name, email) VALUES (new.id, new.name, new.email);
+	END`,
+}
+
+// migrateSearch creates the search index and its triggers. an index created
+// over an existing users table is rebuilt so rows written before it existed
+// are searchable too. sqlite builds without FTS5 (mattn/go-sqlite3 needs the
+// sqlite_fts5 build tag) skip the index and SearchUsers reports it missing
+func migrateSearch(db *gorm.DB) error {
+	var fts5 bool
+	if err := db.Raw("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5).Error; err != nil {
+		return err
+	}
+	if !fts5 {
+		log.Printf("full-text search disabled: sqlite was built without FTS5 (build with -tags sqlite_fts5)")
+		return nil
+	}
+
+	existed := db.Migrator().HasTable(SearchTable)
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range searchSchema {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+		if existed {
+			return nil
+		}
+		return tx.Exec(`INSERT INTO users_fts(users_fts) VALUES ('rebuild')`).Error
+	})
+}
@@ -0,0 +1,83 @@
+package db_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	database "github.com/yishak-cs/CleanGrpc/Internal/db"
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func setupTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	if err := database.Migrate(db); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	return db
+}
+
+func TestRegisterMetrics(t *testing.T) {
+	db := setupTestDB(t)
+	reg := prometheus.NewRegistry()
+	assert.NoError(t, database.RegisterMetrics(db, reg))
+
+	user := &model.User{Name: "Test User", Email: "test@example.com"}
+	assert.NoError(t, db.Create(user).Error)
+	assert.NoError(t, db.First(&model.User{}, user.ID).Error)
+	assert.Error(t, db.First(&model.User{}, user.ID+1).Error)
+	assert.NoError(t, db.Model(user).Update("name", "Renamed").Error)
+	assert.Error(t, db.Exec("INSERT INTO no_such_table VALUES (1)").Error)
+
+	// Test case: Every statement is timed under its operation
+	expected := map[string]uint64{"create": 1, "query": 2, "update": 1, "raw": 1}
+	for operation, want := range expected {
+		assert.Equal(t, want, histogramCount(t, reg, operation), operation)
+	}
+
+	// Test case: Failures are counted, a missing record is not one
+	errorsExpected := `
+# HELP cleangrpc_db_query_errors_total GORM statements that failed, by operation. record not found is not an error.
+# TYPE cleangrpc_db_query_errors_total counter
+cleangrpc_db_query_errors_total{operation="raw"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(errorsExpected), "cleangrpc_db_query_errors_total"))
+
+	// Test case: Connection pool stats are exported
+	count, err := testutil.GatherAndCount(reg, "go_sql_max_open_connections", "go_sql_open_connections", "go_sql_in_use_connections")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	// Test case: Registering twice on the same registry fails instead of double counting
+	assert.Error(t, database.RegisterMetrics(setupTestDB(t), reg))
+}
+
+// histogramCount returns how many statements of operation were observed
+func histogramCount(t *testing.T, reg *prometheus.Registry, operation string) uint64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != "cleangrpc_db_query_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "operation" && label.GetValue() == operation {
+					return metric.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+	return 0
+}
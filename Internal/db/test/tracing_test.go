@@ -0,0 +1,65 @@
+package db_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	database "github.com/yishak-cs/CleanGrpc/Internal/db"
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func spanAttr(span tracetest.SpanStub, key attribute.Key) string {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestRegisterTracing(t *testing.T) {
+	db := setupTestDB(t)
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	assert.NoError(t, database.RegisterTracing(db, tp))
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	assert.NoError(t, db.WithContext(ctx).Create(&model.User{Name: "Test User", Email: "secret@example.com"}).Error)
+	assert.NoError(t, db.WithContext(ctx).Where("email = ?", "secret@example.com").First(&model.User{}).Error)
+	assert.Error(t, db.WithContext(ctx).Exec("DELETE FROM no_such_table").Error)
+	parent.End()
+
+	spans := exporter.GetSpans()
+	if !assert.Len(t, spans, 4) {
+		return
+	}
+	create, query, raw := spans[0], spans[1], spans[2]
+
+	// Test case: Each statement is a client span under the caller's span
+	for _, span := range []tracetest.SpanStub{create, query, raw} {
+		assert.Equal(t, parent.SpanContext().SpanID(), span.Parent.SpanID())
+		assert.Equal(t, trace.SpanKindClient, span.SpanKind)
+		assert.Equal(t, "sqlite", spanAttr(span, "db.system"))
+	}
+	assert.Equal(t, "gorm.create", create.Name)
+	assert.Equal(t, "gorm.query", query.Name)
+	assert.Equal(t, "gorm.raw", raw.Name)
+
+	// Test case: The SQL is recorded with placeholders, never the values
+	assert.Contains(t, spanAttr(query, "db.query.text"), "SELECT * FROM `users` WHERE email = ?")
+	assert.NotContains(t, spanAttr(query, "db.query.text"), "secret@example.com")
+	assert.NotContains(t, spanAttr(create, "db.query.text"), "secret@example.com")
+	assert.Equal(t, "users", spanAttr(query, "db.collection.name"))
+	assert.Equal(t, "1", spanAttr(query, "db.rows_affected"))
+
+	// Test case: Failed statements are marked as errors
+	assert.Equal(t, codes.Unset, query.Status.Code)
+	assert.Equal(t, codes.Error, raw.Status.Code)
+	assert.Contains(t, raw.Status.Description, "no such table")
+}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// key the span of a statement is kept under between the callbacks
+const tracingSpanKey = "cleangrpc:tracing_span"
+
+// instrumentation scope of the database spans
+const tracerName = "github.com/yishak-cs/CleanGrpc/Internal/db"
+
+// RegisterTracing wraps every GORM statement on db in a client span that is
+// a child of the span in the statement's context. the span carries the SQL
+// with its placeholders, never the bound values
+func RegisterTracing(db *gorm.DB, tp trace.TracerProvider) error {
+	tracer := tp.Tracer(tracerName)
+	for _, h := range operationHooks(db) {
+		operation := h.operation
+		before := func(tx *gorm.DB) {
+			ctx := tx.Statement.Context
+			_, span := tracer.Start(ctx, "gorm."+operation,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(
+					semconv.DBSystemSqlite,
+					semconv.DBOperationName(operation),
+				),
+			)
+			tx.InstanceSet(tracingSpanKey, span)
+		}
+		if err := h.before.Register("cleangrpc:tracing_before_"+operation, before); err != nil {
+			return err
+		}
+		if err := h.after.Register("cleangrpc:tracing_after_"+operation, finishSpan); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func finishSpan(tx *gorm.DB) {
+	v, ok := tx.InstanceGet(tracingSpanKey)
+	if !ok {
+		return
+	}
+	span := v.(trace.Span)
+	defer span.End()
+
+	if tx.Statement.Table != "" {
+		span.SetAttributes(semconv.DBCollectionName(tx.Statement.Table))
+	}
+	span.SetAttributes(
+		semconv.DBQueryText(tx.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", tx.Statement.RowsAffected),
+	)
+	if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		span.RecordError(tx.Error)
+		span.SetStatus(codes.Error, tx.Error.Error())
+	}
+}
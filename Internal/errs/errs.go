@@ -0,0 +1,104 @@
+package errs
+
+import "errors"
+
+// Code classifies a domain error independently of the transport. the handler
+// layer decides how each code is presented to clients
+type Code int
+
+const (
+	Unknown Code = iota
+	NotFound
+	AlreadyExists
+	InvalidArgument
+	FailedPrecondition
+	PermissionDenied
+	Unauthenticated
+	ResourceExhausted
+	Aborted
+)
+
+func (c Code) String() string {
+	switch c {
+	case NotFound:
+		return "NotFound"
+	case AlreadyExists:
+		return "AlreadyExists"
+	case InvalidArgument:
+		return "InvalidArgument"
+	case FailedPrecondition:
+		return "FailedPrecondition"
+	case PermissionDenied:
+		return "PermissionDenied"
+	case Unauthenticated:
+		return "Unauthenticated"
+	case ResourceExhausted:
+		return "ResourceExhausted"
+	case Aborted:
+		return "Aborted"
+	default:
+		return "Unknown"
+	}
+}
+
+// Error is returned by the UseCase layer whenever a request fails for a
+// reason the caller can act on. Reason is a stable UPPER_SNAKE_CASE
+// identifier clients can switch on instead of matching Message, and Field
+// names the offending request field for InvalidArgument errors
+type Error struct {
+	Code    Code
+	Reason  string
+	Message string
+	Field   string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Unwrap exposes the underlying cause so errors.Is keeps working on it
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func NewNotFound(reason, message string, cause error) *Error {
+	return &Error{Code: NotFound, Reason: reason, Message: message, Err: cause}
+}
+
+func NewAlreadyExists(reason, message string) *Error {
+	return &Error{Code: AlreadyExists, Reason: reason, Message: message}
+}
+
+func NewInvalidArgument(field, reason, message string) *Error {
+	return &Error{Code: InvalidArgument, Reason: reason, Message: message, Field: field}
+}
+
+func NewFailedPrecondition(reason, message string) *Error {
+	return &Error{Code: FailedPrecondition, Reason: reason, Message: message}
+}
+
+func NewPermissionDenied(reason, message string) *Error {
+	return &Error{Code: PermissionDenied, Reason: reason, Message: message}
+}
+
+func NewUnauthenticated(reason, message string) *Error {
+	return &Error{Code: Unauthenticated, Reason: reason, Message: message}
+}
+
+func NewResourceExhausted(reason, message string) *Error {
+	return &Error{Code: ResourceExhausted, Reason: reason, Message: message}
+}
+
+func NewAborted(reason, message string) *Error {
+	return &Error{Code: Aborted, Reason: reason, Message: message}
+}
+
+// CodeOf reports the Code of the first *Error in err's chain, or Unknown
+func CodeOf(err error) Code {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return Unknown
+}
@@ -0,0 +1,80 @@
+package health
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Pinger is the part of *sql.DB the checker needs
+type Pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// Checker keeps a grpc health server in step with the database. the overall
+// server status ("") and every listed service are SERVING while the database
+// answers pings and NOT_SERVING while it does not
+type Checker struct {
+	server   *health.Server
+	db       Pinger
+	interval time.Duration
+	services []string
+	healthy  *bool
+}
+
+// NewChecker returns a Checker that pings db every interval
+func NewChecker(server *health.Server, db Pinger, interval time.Duration, services ...string) *Checker {
+	return &Checker{
+		server:   server,
+		db:       db,
+		interval: interval,
+		services: append([]string{""}, services...),
+	}
+}
+
+// Run checks right away and then once per interval until ctx is done
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		c.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check pings the database once, giving it at most one interval to answer,
+// and publishes the outcome. it must not be called concurrently with itself
+// or Run
+func (c *Checker) Check(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, c.interval)
+	defer cancel()
+
+	err := c.db.PingContext(ctx)
+	healthy := err == nil
+
+	// only log transitions so a long outage does not flood the log
+	if c.healthy == nil || *c.healthy != healthy {
+		if healthy {
+			slog.Info("database reachable")
+		} else {
+			slog.Error("database unreachable", "err", err)
+		}
+	}
+	c.healthy = &healthy
+
+	status := healthpb.HealthCheckResponse_SERVING
+	if !healthy {
+		status = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	for _, service := range c.services {
+		c.server.SetServingStatus(service, status)
+	}
+}
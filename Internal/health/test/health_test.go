@@ -0,0 +1,102 @@
+package health_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	dbhealth "github.com/yishak-cs/CleanGrpc/Internal/health"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// fakeDB answers pings with whatever error it currently holds
+type fakeDB struct {
+	mu    sync.Mutex
+	err   error
+	pings int
+}
+
+func (f *fakeDB) PingContext(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pings++
+	return f.err
+}
+
+func (f *fakeDB) set(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+func statusOf(t *testing.T, server *health.Server, service string) healthpb.HealthCheckResponse_ServingStatus {
+	t.Helper()
+	resp, err := server.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		t.Fatalf("Check(%q) failed: %v", service, err)
+	}
+	return resp.Status
+}
+
+func TestChecker_Check(t *testing.T) {
+	server := health.NewServer()
+	db := &fakeDB{}
+	checker := dbhealth.NewChecker(server, db, time.Second, "UserService")
+
+	// Test case: A reachable database means serving
+	checker.Check(context.Background())
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, statusOf(t, server, ""))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, statusOf(t, server, "UserService"))
+
+	// Test case: A failing ping flips every service to not serving
+	db.set(errors.New("database is locked"))
+	checker.Check(context.Background())
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, statusOf(t, server, ""))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, statusOf(t, server, "UserService"))
+
+	// Test case: Recovery is picked up on the next check
+	db.set(nil)
+	checker.Check(context.Background())
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, statusOf(t, server, "UserService"))
+
+	// Test case: Shutdown wins over later checks
+	server.Shutdown()
+	checker.Check(context.Background())
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, statusOf(t, server, "UserService"))
+}
+
+func TestChecker_Run(t *testing.T) {
+	server := health.NewServer()
+	db := &fakeDB{}
+	checker := dbhealth.NewChecker(server, db, 10*time.Millisecond, "UserService")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		checker.Run(ctx)
+		close(done)
+	}()
+
+	// Test case: Pings keep coming and an outage is noticed without a restart
+	db.set(errors.New("disk I/O error"))
+	assert.Eventually(t, func() bool {
+		return statusOf(t, server, "UserService") == healthpb.HealthCheckResponse_NOT_SERVING
+	}, time.Second, 5*time.Millisecond)
+
+	db.set(nil)
+	assert.Eventually(t, func() bool {
+		return statusOf(t, server, "UserService") == healthpb.HealthCheckResponse_SERVING
+	}, time.Second, 5*time.Millisecond)
+
+	// Test case: Run returns once the context is cancelled
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+}
@@ -0,0 +1,71 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages to users. implementations must be safe for
+// concurrent use
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Writer is a Mailer for local development: instead of delivering messages
+// it writes them, headers and all, to an io.Writer such as stdout or a file
+type Writer struct {
+	mu     sync.Mutex
+	w      io.Writer
+	from   string
+	closer io.Closer
+}
+
+// NewWriter returns a Writer printing messages from address from to w
+func NewWriter(w io.Writer, from string) *Writer {
+	return &Writer{w: w, from: from}
+}
+
+// OpenFile returns a Writer appending messages to the file at path, which is
+// created if missing. Close closes the file
+func OpenFile(path, from string) (*Writer, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("mail: %w", err)
+	}
+	return &Writer{w: f, from: from, closer: f}, nil
+}
+
+func (m *Writer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := fmt.Fprintf(m.w, "From: %s\nTo: %s\nSubject: %s\nDate: %s\n\n%s\n\n",
+		m.from, msg.To, msg.Subject, time.Now().Format(time.RFC1123Z), msg.Body)
+	if err != nil {
+		return fmt.Errorf("mail: %w", err)
+	}
+	return nil
+}
+
+// Close closes the file of a Writer made by OpenFile, other writers are left
+// open
+func (m *Writer) Close() error {
+	if m.closer == nil {
+		return nil
+	}
+	return m.closer.Close()
+}
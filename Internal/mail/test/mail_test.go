@@ -0,0 +1,52 @@
+package mail_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yishak-cs/CleanGrpc/Internal/mail"
+)
+
+func TestWriter(t *testing.T) {
+	var out bytes.Buffer
+	mailer := mail.NewWriter(&out, "no-reply@example.com")
+
+	// Test case: A message is written with its headers, then the body
+	err := mailer.Send(context.Background(), mail.Message{To: "a@example.com", Subject: "Hello", Body: "line one\nline two"})
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "From: no-reply@example.com\nTo: a@example.com\nSubject: Hello\n")
+	assert.Contains(t, out.String(), "\n\nline one\nline two\n")
+
+	// Test case: A cancelled context sends nothing
+	out.Reset()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, mailer.Send(ctx, mail.Message{To: "a@example.com"}), context.Canceled)
+	assert.Empty(t, out.String())
+	assert.NoError(t, mailer.Close())
+}
+
+func TestOpenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.txt")
+
+	// Test case: Messages are appended to the file, across reopens
+	for _, to := range []string{"a@example.com", "b@example.com"} {
+		mailer, err := mail.OpenFile(path, "no-reply@example.com")
+		assert.NoError(t, err)
+		assert.NoError(t, mailer.Send(context.Background(), mail.Message{To: to, Subject: "Hi", Body: "body"}))
+		assert.NoError(t, mailer.Close())
+	}
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), "Subject: Hi\n"))
+	assert.Contains(t, string(data), "To: b@example.com")
+
+	// Test case: A file in a missing directory cannot be opened
+	_, err = mail.OpenFile(filepath.Join(t.TempDir(), "missing", "mail.txt"), "")
+	assert.Error(t, err)
+}
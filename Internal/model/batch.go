@@ -0,0 +1,44 @@
+package model
+
+import "fmt"
+
+// BatchMode decides what a batch does once one of its items fails
+type BatchMode int
+
+const (
+	// BatchAllOrNothing writes every item in a single transaction or, as
+	// soon as one of them fails, none at all
+	BatchAllOrNothing BatchMode = iota
+	// BatchBestEffort writes the items that can be written and reports the
+	// others
+	BatchBestEffort
+)
+
+// UserUpdate is one item of a batch update. Fields works like the field list
+// of a single update, none means all of UserUpdatableFields
+type UserUpdate struct {
+	User   *User
+	Fields []string
+}
+
+// BatchResult is the outcome of one batch item, results come in request
+// order. User is the created or updated user and stays nil for deletes and
+// failed items, Err is nil unless the item failed
+type BatchResult struct {
+	User *User
+	Err  error
+}
+
+// BatchItemError tells which item of a bulk write made it fail
+type BatchItemError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchItemError) Error() string {
+	return fmt.Sprintf("item %d: %v", e.Index, e.Err)
+}
+
+func (e *BatchItemError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,21 @@
+package model
+
+import "time"
+
+// what an EmailToken may be redeemed for
+const (
+	TokenPurposeVerifyEmail = "verify_email"
+)
+
+// EmailToken is a single-use secret mailed to a user. only its hash is
+// stored and redeeming it deletes the row
+type EmailToken struct {
+	Hash    string `gorm:"primaryKey"`
+	UserID  uint   `gorm:"index;not null"`
+	Purpose string `gorm:"not null"`
+	// address the token was sent to, it proves nothing about any other
+	Email     string `gorm:"not null"`
+	CreatedAt time.Time
+	// the token is refused from then on and the sweeper deletes the row
+	ExpiresAt time.Time `gorm:"index"`
+}
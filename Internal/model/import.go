@@ -0,0 +1,41 @@
+package model
+
+// ConflictPolicy decides what an import does with a row whose email already
+// belongs to a user
+type ConflictPolicy int
+
+const (
+	// ConflictSkip leaves the existing user alone
+	ConflictSkip ConflictPolicy = iota
+	// ConflictOverwrite gives the existing user the name from the row
+	ConflictOverwrite
+	// ConflictFail stops the import at the row, everything before it stays
+	// imported
+	ConflictFail
+)
+
+// ImportRow is one user read from an import file. Line is where it was
+// found, so reports can point back into the file
+type ImportRow struct {
+	Line  int64
+	Name  string
+	Email string
+}
+
+// ImportRejection is a row an import refused and why
+type ImportRejection struct {
+	Line  int64
+	Email string
+	Err   error
+}
+
+// ImportReport sums up an import by the lines of its rows. Aborted is set
+// when ConflictFail stopped it, the conflicting row is the last rejection, or
+// when writing a chunk failed, its unwritten rows are the last rejections
+type ImportReport struct {
+	Created  []int64
+	Updated  []int64
+	Skipped  []int64
+	Rejected []ImportRejection
+	Aborted  bool
+}
@@ -0,0 +1,17 @@
+package model
+
+import "time"
+
+// MagicLinkToken is a single-use sign-in secret mailed to a user. it lives
+// in a table of its own so it can never be redeemed as an EmailToken or the
+// other way round. only its hash is stored and redeeming it deletes the row
+type MagicLinkToken struct {
+	Hash   string `gorm:"primaryKey"`
+	UserID uint   `gorm:"index;not null"`
+	// address the link was sent to, signing in with it proves nothing about
+	// any other
+	Email     string `gorm:"not null"`
+	CreatedAt time.Time
+	// the link is refused from then on and the sweeper deletes the row
+	ExpiresAt time.Time `gorm:"index"`
+}
@@ -1,9 +1,46 @@
 package model
 
-import "gorm.io/gorm"
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// account states. pending accounts have not proven they own their email
+// address yet and cannot log in
+const (
+	UserStatusPending = "pending"
+	UserStatusActive  = "active"
+)
+
+// fields a partial update may name, spelled like their proto fields
+const (
+	UserFieldName  = "name"
+	UserFieldEmail = "email"
+)
+
+// UserUpdatableFields is what an update without a field list writes
+var UserUpdatableFields = []string{UserFieldName, UserFieldEmail}
+
+// ErrVersionConflict is returned by a conditional update of a user whose
+// version no longer matches, i.e. someone else changed it first
+var ErrVersionConflict = errors.New("user was changed concurrently")
 
 type User struct {
 	gorm.Model
 	Name  string
 	Email string
+	// UserStatusPending or UserStatusActive, rows from before email
+	// verification existed are active
+	Status string `gorm:"not null;default:active"`
+	// bumped by every change clients can see, updates naming a version only
+	// apply while it is still the current one
+	Version uint64 `gorm:"not null;default:1"`
+	// argon2id or bcrypt hash, empty until a password is set
+	PasswordHash string
+	// consecutive failed logins and, once they reach the limit, the end of
+	// the lockout they caused
+	FailedLogins int
+	LockedUntil  *time.Time
 }
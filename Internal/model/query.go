@@ -0,0 +1,92 @@
+package model
+
+import "time"
+
+// UserField names a users column that can be filtered or ordered on
+type UserField string
+
+const (
+	FieldID        UserField = "id"
+	FieldName      UserField = "name"
+	FieldEmail     UserField = "email"
+	FieldCreatedAt UserField = "created_at"
+)
+
+// FilterOp is a comparison a UserCondition applies to its field
+type FilterOp string
+
+const (
+	// string operators for name and email
+	OpEquals   FilterOp = "="
+	OpPrefix   FilterOp = "prefix"
+	OpSuffix   FilterOp = "suffix"
+	OpContains FilterOp = "contains"
+
+	// range operators for created_at
+	OpLess         FilterOp = "<"
+	OpLessEqual    FilterOp = "<="
+	OpGreater      FilterOp = ">"
+	OpGreaterEqual FilterOp = ">="
+)
+
+// UserCondition restricts a listing to users whose Field matches Value under
+// Op. Time holds the parsed value when Field is FieldCreatedAt
+type UserCondition struct {
+	Field UserField
+	Op    FilterOp
+	Value string
+	Time  time.Time
+}
+
+// UserOrder is the sort key of a listing. ties are always broken by ID in
+// the same direction so the order is total
+type UserOrder struct {
+	Field UserField
+	Desc  bool
+}
+
+// UserCursor is the sort key of the last user of the previous page. Value is
+// a string for name and email, a time.Time for created_at and unused for id
+type UserCursor struct {
+	ID    uint
+	Value any
+}
+
+// UserQuery describes a keyset window over the users table. all Conditions
+// must hold, rows come in OrderBy order starting right after After (or from
+// the beginning when it is nil) and at most Limit of them are returned.
+// soft deleted users are left out unless IncludeDeleted is set
+type UserQuery struct {
+	Conditions     []UserCondition
+	OrderBy        UserOrder
+	After          *UserCursor
+	Limit          int
+	IncludeDeleted bool
+}
+
+// ListUsersParams is what a caller asks of a listing. PageToken is the
+// NextPageToken of a previous page and must be sent with the same Filter and
+// OrderBy and IncludeDeleted that produced it
+type ListUsersParams struct {
+	PageSize       int
+	PageToken      string
+	Filter         string
+	OrderBy        string
+	IncludeDeleted bool
+}
+
+// UsersPage is a single page of users along with the opaque token that
+// fetches the page after it. NextPageToken is empty on the last page
+type UsersPage struct {
+	Users         []*User
+	NextPageToken string
+}
+
+// UserSearchResult is a full-text search hit. Score grows with relevance and
+// the snippets hold name and email with the matched terms highlighted
+type UserSearchResult struct {
+	User         *User
+	Score        float64
+	NameSnippet  string
+	EmailSnippet string
+}
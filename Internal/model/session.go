@@ -0,0 +1,20 @@
+package model
+
+import "time"
+
+// Session is one signed in device or app of a user. the refresh token handed
+// out for it is only stored as a hash and is replaced on every refresh
+type Session struct {
+	// random UUID, also the public handle of the session
+	ID        string `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index;not null"`
+	TokenHash string `gorm:"not null"`
+	// hash of the refresh token TokenHash replaced, empty until the first
+	// refresh. presenting that token again means it leaked
+	PreviousTokenHash string
+	CreatedAt         time.Time
+	// last time the refresh token was exchanged
+	LastUsedAt time.Time
+	// the refresh token is refused from then on and the sweeper deletes the row
+	ExpiresAt time.Time `gorm:"index"`
+}
@@ -0,0 +1,46 @@
+package password_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yishak-cs/CleanGrpc/Internal/password"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashVerify(t *testing.T) {
+	// Test case: New hashes are salted argon2id in the PHC format
+	hash, err := password.Hash("correct horse")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=3,p=4$"))
+	again, _ := password.Hash("correct horse")
+	assert.NotEqual(t, hash, again)
+
+	ok, err := password.Verify(hash, "correct horse")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// Test case: A wrong password does not match
+	ok, err = password.Verify(hash, "correct horse ")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// Test case: bcrypt hashes made elsewhere are verified too
+	legacy, _ := bcrypt.GenerateFromPassword([]byte("battery staple"), bcrypt.MinCost)
+	ok, err = password.Verify(string(legacy), "battery staple")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = password.Verify(string(legacy), "battery")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// Test case: Malformed hashes are errors, never matches
+	for _, bad := range []string{"", "plaintext", "$argon2id$v=19$m=65536", "$argon2id$v=18$m=1,t=1,p=1$c2FsdA$a2V5", "$argon2id$v=19$m=1,t=1,p=1$c2FsdA$!!"} {
+		ok, err := password.Verify(bad, "anything")
+		assert.Error(t, err, bad)
+		assert.False(t, ok, bad)
+	}
+	_, err = password.Verify("plaintext", "plaintext")
+	assert.ErrorIs(t, err, password.ErrUnknownHash)
+}
@@ -0,0 +1,85 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// CertReloader serves a certificate and key pair from disk and picks up
+// replacements, e.g. from cert-manager or certbot, without a restart
+type CertReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	version fileVersion
+}
+
+// fileVersion tells whether either file changed since it was loaded
+type fileVersion struct {
+	certMod, keyMod   time.Time
+	certSize, keySize int64
+}
+
+// NewCertReloader loads the pair once, failing if it is unusable
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	version, err := r.stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(version); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate is a tls.Config.GetCertificate callback. the files are
+// checked on every handshake and reloaded when they changed. a pair that
+// cannot be loaded, e.g. while it is being rewritten, is logged and the
+// previous certificate keeps being served
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	version, err := r.stat()
+	if err == nil && version != r.version {
+		err = r.load(version)
+		if err == nil {
+			slog.Info("reloaded TLS certificate", "cert_file", r.certFile)
+		}
+	}
+	if err != nil {
+		slog.Error("unable to reload TLS certificate, serving the previous one", "cert_file", r.certFile, "err", err)
+	}
+	return r.cert, nil
+}
+
+func (r *CertReloader) stat() (fileVersion, error) {
+	cert, err := os.Stat(r.certFile)
+	if err != nil {
+		return fileVersion{}, fmt.Errorf("tls certificate: %w", err)
+	}
+	key, err := os.Stat(r.keyFile)
+	if err != nil {
+		return fileVersion{}, fmt.Errorf("tls key: %w", err)
+	}
+	return fileVersion{
+		certMod: cert.ModTime(), keyMod: key.ModTime(),
+		certSize: cert.Size(), keySize: key.Size(),
+	}, nil
+}
+
+func (r *CertReloader) load(version fileVersion) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("tls key pair: %w", err)
+	}
+	r.cert = &cert
+	r.version = version
+	return nil
+}
@@ -0,0 +1,254 @@
+package tlsconfig_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yishak-cs/CleanGrpc/Internal/config"
+	"github.com/yishak-cs/CleanGrpc/Internal/tlsconfig"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// testCA is a self-signed certificate authority writing PEM files to dir
+type testCA struct {
+	dir    string
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	serial int64
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	ca := &testCA{dir: t.TempDir(), cert: cert, key: key, serial: 1}
+	writePEM(t, filepath.Join(ca.dir, "ca.pem"), "CERTIFICATE", der)
+	return ca
+}
+
+func (ca *testCA) bundle() string {
+	return filepath.Join(ca.dir, "ca.pem")
+}
+
+// issue writes a certificate and key for cn into certFile and keyFile and
+// returns the certificate's serial number
+func (ca *testCA) issue(t *testing.T, cn string, usage x509.ExtKeyUsage, certFile, keyFile string) int64 {
+	t.Helper()
+	ca.serial++
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("Failed to issue certificate: %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return ca.serial
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+// serveHealth runs a gRPC health server with tlsCfg and returns its address
+func serveHealth(t *testing.T, tlsCfg *tls.Config) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsCfg)))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return listener.Addr().String()
+}
+
+func checkHealth(t *testing.T, addr string, tlsCfg *tls.Config) error {
+	t.Helper()
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(credentials.NewTLS(tlsCfg)))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	return err
+}
+
+// servedSerial completes a handshake with addr and returns the serial of the
+// certificate the server presented
+func servedSerial(t *testing.T, addr string, roots *x509.CertPool) int64 {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: roots, ServerName: "localhost"})
+	if err != nil {
+		t.Fatalf("Failed to handshake: %v", err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestServer_Reload(t *testing.T) {
+	ca := newTestCA(t, "server ca")
+	certFile, keyFile := filepath.Join(ca.dir, "server.pem"), filepath.Join(ca.dir, "server-key.pem")
+	first := ca.issue(t, "localhost", x509.ExtKeyUsageServerAuth, certFile, keyFile)
+
+	tlsCfg, err := tlsconfig.Server(config.TLS{CertFile: certFile, KeyFile: keyFile, ClientAuth: config.ClientAuthRequire})
+	assert.NoError(t, err)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", tlsCfg)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+	roots, _ := tlsconfig.LoadCertPool(ca.bundle())
+
+	// Test case: The certificate on disk is served
+	assert.Equal(t, first, servedSerial(t, listener.Addr().String(), roots))
+
+	// Test case: A replaced certificate is served on the next handshake
+	second := ca.issue(t, "localhost", x509.ExtKeyUsageServerAuth, certFile, keyFile)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	assert.Equal(t, second, servedSerial(t, listener.Addr().String(), roots))
+
+	// Test case: A broken replacement keeps the previous certificate in service
+	os.WriteFile(keyFile, []byte("half written"), 0o600)
+	assert.Equal(t, second, servedSerial(t, listener.Addr().String(), roots))
+
+	// Test case: An unusable pair is rejected at startup
+	_, err = tlsconfig.Server(config.TLS{CertFile: certFile, KeyFile: keyFile})
+	assert.Error(t, err)
+}
+
+func TestServer_MutualTLS(t *testing.T) {
+	ca := newTestCA(t, "cleangrpc ca")
+	stranger := newTestCA(t, "stranger ca")
+	file := func(name string) string { return filepath.Join(ca.dir, name) }
+
+	ca.issue(t, "localhost", x509.ExtKeyUsageServerAuth, file("server.pem"), file("server-key.pem"))
+	ca.issue(t, "client", x509.ExtKeyUsageClientAuth, file("client.pem"), file("client-key.pem"))
+	stranger.issue(t, "client", x509.ExtKeyUsageClientAuth, file("rogue.pem"), file("rogue-key.pem"))
+
+	client := func(t *testing.T, cert, key string) *tls.Config {
+		cfg, err := tlsconfig.Client(ca.bundle(), cert, key)
+		if err != nil {
+			t.Fatalf("Failed to build client config: %v", err)
+		}
+		return cfg
+	}
+
+	cases := []struct {
+		name       string
+		clientAuth string
+		cert, key  string
+		ok         bool
+	}{
+		{"required and trusted", config.ClientAuthRequire, file("client.pem"), file("client-key.pem"), true},
+		{"required but missing", config.ClientAuthRequire, "", "", false},
+		{"required but untrusted", config.ClientAuthRequire, file("rogue.pem"), file("rogue-key.pem"), false},
+		{"optional and missing", config.ClientAuthOptional, "", "", true},
+		{"optional and trusted", config.ClientAuthOptional, file("client.pem"), file("client-key.pem"), true},
+		// the client withholds a certificate the server's CAs did not issue
+		{"optional but untrusted", config.ClientAuthOptional, file("rogue.pem"), file("rogue-key.pem"), true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			serverCfg, err := tlsconfig.Server(config.TLS{
+				CertFile:     file("server.pem"),
+				KeyFile:      file("server-key.pem"),
+				ClientCAFile: ca.bundle(),
+				ClientAuth:   tc.clientAuth,
+			})
+			assert.NoError(t, err)
+			addr := serveHealth(t, serverCfg)
+
+			err = checkHealth(t, addr, client(t, tc.cert, tc.key))
+			if tc.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+
+	// Test case: A server certificate from an unknown CA is refused by the client
+	serverCfg, _ := tlsconfig.Server(config.TLS{CertFile: file("server.pem"), KeyFile: file("server-key.pem")})
+	addr := serveHealth(t, serverCfg)
+	strangerClient, _ := tlsconfig.Client(stranger.bundle(), "", "")
+	assert.Error(t, checkHealth(t, addr, strangerClient))
+}
+
+func TestClient(t *testing.T) {
+	ca := newTestCA(t, "cleangrpc ca")
+
+	// Test case: A certificate without its key is rejected
+	_, err := tlsconfig.Client(ca.bundle(), filepath.Join(ca.dir, "client.pem"), "")
+	assert.ErrorContains(t, err, "together")
+
+	// Test case: A bundle without certificates is rejected
+	empty := filepath.Join(ca.dir, "empty.pem")
+	os.WriteFile(empty, []byte("nothing here"), 0o600)
+	_, err = tlsconfig.Client(empty, "", "")
+	assert.ErrorContains(t, err, "no PEM certificates")
+
+	// Test case: No CA means the system roots
+	cfg, err := tlsconfig.Client("", "", "")
+	assert.NoError(t, err)
+	assert.Nil(t, cfg.RootCAs)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	pb "github.com/yishak-cs/CleanGrpc/proto"
+)
+
+// exportColumns is the order of the csv columns and of the json keys, the
+// same for every export
+var exportColumns = []string{"id", "name", "email", "status", "version", "created_at", "updated_at"}
+
+// exportedUser is one exported user, fields in exportColumns order
+type exportedUser struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	Status    string `json:"status"`
+	Version   uint64 `json:"version"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+func newExportedUser(user *pb.UserResponse) exportedUser {
+	return exportedUser{
+		ID:        user.Id,
+		Name:      user.Name,
+		Email:     user.Email,
+		Status:    user.Status,
+		Version:   user.Version,
+		CreatedAt: exportTime(user.CreatedAt.AsTime()),
+		UpdatedAt: exportTime(user.UpdatedAt.AsTime()),
+	}
+}
+
+func (u exportedUser) record() []string {
+	return []string{u.ID, u.Name, u.Email, u.Status, strconv.FormatUint(u.Version, 10), u.CreatedAt, u.UpdatedAt}
+}
+
+func exportTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// exportWriter writes exported users in one of the export formats
+type exportWriter interface {
+	write(exportedUser) error
+	close() error
+}
+
+// exportUsers returns its errors instead of exiting so that the temporary
+// file is always removed
+func exportUsers(ctx context.Context, client pb.UserServiceClient, format, out, filter, orderBy string) error {
+	// a failed export must not leave half a file behind, so it is written
+	// next to out and only renamed over it once complete
+	var dst io.Writer = os.Stdout
+	var tmp *os.File
+	if out != "" && out != "-" {
+		var err error
+		tmp, err = os.CreateTemp(filepath.Dir(out), "."+filepath.Base(out)+".*")
+		if err != nil {
+			return fmt.Errorf("create %s: %w", out, err)
+		}
+		defer func() {
+			// harmless once the file was renamed over out
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}()
+		dst = tmp
+	}
+	buffered := bufio.NewWriter(dst)
+
+	var w exportWriter
+	switch format {
+	case "csv":
+		w = newCSVExport(buffered)
+	case "jsonl":
+		w = &jsonlExport{enc: json.NewEncoder(buffered)}
+	case "json":
+		w = &jsonExport{w: buffered}
+	default:
+		return fmt.Errorf("unknown format %q, use csv, jsonl or json", format)
+	}
+
+	stream, err := client.ExportUsers(ctx, &pb.ExportUsersRequest{Filter: filter, OrderBy: orderBy})
+	if err != nil {
+		return err
+	}
+	exported := 0
+	for {
+		user, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := w.write(newExportedUser(user)); err != nil {
+			return fmt.Errorf("write: %w", err)
+		}
+		exported++
+	}
+	if err := w.close(); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	if err := buffered.Flush(); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+
+	if tmp == nil {
+		return nil
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), out); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	fmt.Printf("Exported %d users to %s\n", exported, out)
+	return nil
+}
+
+type csvExport struct {
+	w      *csv.Writer
+	header bool
+}
+
+func newCSVExport(w io.Writer) *csvExport {
+	return &csvExport{w: csv.NewWriter(w)}
+}
+
+func (e *csvExport) write(user exportedUser) error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	return e.w.Write(user.record())
+}
+
+// writeHeader makes sure even an empty export names its columns
+func (e *csvExport) writeHeader() error {
+	if e.header {
+		return nil
+	}
+	e.header = true
+	return e.w.Write(exportColumns)
+}
+
+func (e *csvExport) close() error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type jsonlExport struct {
+	enc *json.Encoder
+}
+
+func (e *jsonlExport) write(user exportedUser) error {
+	return e.enc.Encode(user)
+}
+
+func (e *jsonlExport) close() error {
+	return nil
+}
+
+// jsonExport writes a single array, one user per line, without holding the
+// export in memory
+type jsonExport struct {
+	w       io.Writer
+	started bool
+}
+
+func (e *jsonExport) write(user exportedUser) error {
+	raw, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	sep := ",\n  "
+	if !e.started {
+		sep = "[\n  "
+		e.started = true
+	}
+	_, err = fmt.Fprintf(e.w, "%s%s", sep, raw)
+	return err
+}
+
+func (e *jsonExport) close() error {
+	end := "\n]\n"
+	if !e.started {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(e.w, end)
+	return err
+}
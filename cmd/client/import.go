@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	pb "github.com/yishak-cs/CleanGrpc/proto"
+)
+
+// rows sent per ImportUsersRequest
+const importBatch = 100
+
+var conflictPolicies = map[string]pb.ConflictPolicy{
+	"skip":      pb.ConflictPolicy_SKIP,
+	"overwrite": pb.ConflictPolicy_OVERWRITE,
+	"fail":      pb.ConflictPolicy_FAIL,
+}
+
+// localRejection is a line the client could not even parse, it never reaches
+// the server but belongs in the report all the same
+type localRejection struct {
+	line   int64
+	reason string
+}
+
+func importUsers(ctx context.Context, client pb.UserServiceClient, path, format, onConflict string) {
+	policy, ok := conflictPolicies[onConflict]
+	if !ok {
+		log.Fatalf("Unknown conflict policy %q, use skip, overwrite or fail", onConflict)
+	}
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".csv":
+			format = "csv"
+		case ".jsonl", ".ndjson":
+			format = "jsonl"
+		default:
+			log.Fatalf("Cannot tell the format of %s, pass --format csv or --format jsonl", path)
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("Failed to open %s: %v", path, err)
+	}
+	defer file.Close()
+
+	stream, err := client.ImportUsers(ctx)
+	if err != nil {
+		log.Fatalf("Failed to start import: %v", err)
+	}
+
+	req := &pb.ImportUsersRequest{OnConflict: policy}
+	flush := func() error {
+		err := stream.Send(req)
+		req = &pb.ImportUsersRequest{}
+		return err
+	}
+	emit := func(row *pb.ImportRow) error {
+		req.Rows = append(req.Rows, row)
+		if len(req.Rows) < importBatch {
+			return nil
+		}
+		return flush()
+	}
+
+	var local []localRejection
+	switch format {
+	case "csv":
+		local, err = readCSV(file, emit)
+	case "jsonl":
+		local, err = readJSONL(file, emit)
+	default:
+		log.Fatalf("Unknown format %q, use csv or jsonl", format)
+	}
+	// io.EOF means the server stopped reading, its answer says why
+	if err == nil {
+		err = flush()
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		log.Fatalf("Failed to read %s: %v", path, err)
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		log.Fatalf("Failed to import users: %v", err)
+	}
+	printImportReport(resp, local)
+}
+
+// readCSV reads a file whose header names a name and an email column, in
+// any order and next to any others
+func readCSV(r io.Reader, emit func(*pb.ImportRow) error) ([]localRejection, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("no header: %w", err)
+	}
+	nameCol, emailCol := -1, -1
+	for i, column := range header {
+		switch strings.ToLower(strings.TrimSpace(column)) {
+		case "name":
+			nameCol = i
+		case "email":
+			emailCol = i
+		}
+	}
+	if nameCol < 0 || emailCol < 0 {
+		return nil, errors.New("the header needs a name and an email column")
+	}
+
+	var local []localRejection
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return local, nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			local = append(local, localRejection{line: int64(parseErr.StartLine), reason: parseErr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			return local, err
+		}
+		line, _ := reader.FieldPos(0)
+		row := &pb.ImportRow{Line: int64(line)}
+		if nameCol < len(record) {
+			row.Name = record[nameCol]
+		}
+		if emailCol < len(record) {
+			row.Email = record[emailCol]
+		}
+		if err := emit(row); err != nil {
+			return local, err
+		}
+	}
+}
+
+// readJSONL reads one {"name": ..., "email": ...} object per line, blank
+// lines are ignored
+func readJSONL(r io.Reader, emit func(*pb.ImportRow) error) ([]localRejection, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var local []localRejection
+	var line int64
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var user struct {
+			Name  string `json:"name"`
+			Email string `json:"email"`
+		}
+		if err := json.Unmarshal([]byte(text), &user); err != nil {
+			local = append(local, localRejection{line: line, reason: "invalid JSON: " + err.Error()})
+			continue
+		}
+		if err := emit(&pb.ImportRow{Line: line, Name: user.Name, Email: user.Email}); err != nil {
+			return local, err
+		}
+	}
+	return local, scanner.Err()
+}
+
+func printImportReport(resp *pb.ImportUsersResponse, local []localRejection) {
+	rejected := local
+	for _, rejection := range resp.Rejected {
+		rejected = append(rejected, localRejection{
+			line:   rejection.Line,
+			reason: fmt.Sprintf("%s: %s (%s)", rejection.Email, rejection.Error.Message, rejection.Error.Reason),
+		})
+	}
+	sort.SliceStable(rejected, func(i, j int) bool { return rejected[i].line < rejected[j].line })
+
+	fmt.Printf("Created: %d, Updated: %d, Skipped: %d, Rejected: %d\n",
+		len(resp.Created), len(resp.Updated), len(resp.Skipped), len(rejected))
+	if len(resp.Created) > 0 {
+		fmt.Printf("Created lines: %s\n", lineRanges(resp.Created))
+	}
+	if len(resp.Updated) > 0 {
+		fmt.Printf("Updated lines: %s\n", lineRanges(resp.Updated))
+	}
+	if len(resp.Skipped) > 0 {
+		fmt.Printf("Skipped lines: %s\n", lineRanges(resp.Skipped))
+	}
+	for _, rejection := range rejected {
+		fmt.Printf("Rejected line %d: %s\n", rejection.line, rejection.reason)
+	}
+	if resp.Aborted {
+		fmt.Println("Import stopped at the first conflict, later lines were not imported")
+		os.Exit(1)
+	}
+}
+
+// lineRanges prints lines compactly, e.g. 2-5, 7
+func lineRanges(lines []int64) string {
+	var parts []string
+	for i := 0; i < len(lines); {
+		j := i
+		for j+1 < len(lines) && lines[j+1] == lines[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, fmt.Sprint(lines[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", lines[i], lines[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ", ")
+}
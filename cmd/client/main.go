@@ -2,20 +2,60 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strconv"
 	"time"
 
+	"github.com/yishak-cs/CleanGrpc/Internal/tlsconfig"
 	pb "github.com/yishak-cs/CleanGrpc/proto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 )
 
 func main() {
+	// connection flags come before the command
+	global := flag.NewFlagSet("client", flag.ExitOnError)
+	addr := global.String("addr", "localhost:50000", "host:port of the server")
+	caCert := global.String("cacert", "", "PEM CA bundle the server certificate is verified against, enables TLS")
+	cert := global.String("cert", "", "PEM client certificate for mutual TLS")
+	key := global.String("key", "", "PEM private key of --cert")
+	global.Usage = printUsage
+	global.Parse(os.Args[1:])
+	args := global.Args()
+
+	if (*cert == "") != (*key == "") {
+		fmt.Fprintln(os.Stderr, "--cert and --key must be given together")
+		printUsage()
+		os.Exit(2)
+	}
+	secure := *caCert != "" || *cert != ""
+	creds := insecure.NewCredentials()
+	if secure {
+		tlsCfg, err := tlsconfig.Client(*caCert, *cert, *key)
+		if err != nil {
+			log.Fatalf("invalid TLS settings: %v", err)
+		}
+		creds = credentials.NewTLS(tlsCfg)
+	}
+
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	// authenticate every call when a token is given
+	if token := os.Getenv("CLEANGRPC_TOKEN"); token != "" {
+		if !secure {
+			fmt.Fprintln(os.Stderr, "WARNING: sending CLEANGRPC_TOKEN over a plaintext connection, pass --cacert to use TLS")
+		}
+		opts = append(opts, grpc.WithPerRPCCredentials(bearerToken{token: token, secure: secure}))
+	}
+
 	// establish a connection to the gRPC server
-	connection, err := grpc.Dial("localhost:50000", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	connection, err := grpc.Dial(*addr, opts...)
 	if err != nil {
 		log.Fatal("failed to connect to server: ", err)
 	}
@@ -28,49 +68,287 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	if len(os.Args) < 2 {
+	if len(args) < 1 {
 		printUsage()
 		return
 	}
 
-	command := os.Args[1]
+	command := args[0]
 
 	switch command {
 	case "create":
-		if len(os.Args) < 4 {
+		if len(args) < 3 {
 			fmt.Println("Usage: client create <name> <email>")
 			return
 		}
-		createUser(ctx, client, os.Args[2], os.Args[3])
+		createUser(ctx, client, args[1], args[2])
 
 	case "get":
-		if len(os.Args) < 3 {
+		if len(args) < 2 {
 			fmt.Println("Usage: client get <user_id>")
 			return
 		}
-		getUser(ctx, client, os.Args[2])
+		getUser(ctx, client, args[1])
 
 	case "list":
-		listUsers(ctx, client)
+		flags := flag.NewFlagSet("list", flag.ExitOnError)
+		pageSize := flags.Int("page-size", 0, "users per page (server default when 0)")
+		pageToken := flags.String("page-token", "", "next page token from a previous call")
+		filter := flags.String("filter", "", `e.g. 'email suffix "@partner.com" AND created_at >= "2024-01-01T00:00:00Z"'`)
+		orderBy := flags.String("order-by", "", "id, name, email or created_at, optionally followed by asc or desc")
+		includeDeleted := flags.Bool("include-deleted", false, "also list deleted users that were not purged yet")
+		flags.Parse(args[1:])
+
+		listUsers(ctx, client, &pb.UsersListRequest{
+			PageSize:       int32(*pageSize),
+			PageToken:      *pageToken,
+			Filter:         *filter,
+			OrderBy:        *orderBy,
+			IncludeDeleted: *includeDeleted,
+		})
+
+	case "stream":
+		// a full table stream can outlive the default request timeout
+		streamUsers(context.Background(), client)
+
+	case "search":
+		if len(args) < 2 {
+			fmt.Println("Usage: client search <query> [limit]")
+			return
+		}
+		var limit int64
+		if len(args) > 2 {
+			limit, err = strconv.ParseInt(args[2], 10, 32)
+			if err != nil {
+				fmt.Println("Invalid limit:", err)
+				return
+			}
+		}
+		searchUsers(ctx, client, args[1], int32(limit))
+
+	case "health":
+		service := ""
+		if len(args) > 1 {
+			service = args[1]
+		}
+		checkHealth(ctx, healthpb.NewHealthClient(connection), service)
 
 	case "update":
-		if len(os.Args) < 5 {
-			fmt.Println("Usage: client update <user_id> <name> <email>")
+		if len(args) < 4 {
+			fmt.Println("Usage: client update <user_id> <name> <email> [version]")
 			return
 		}
-		id, err := strconv.ParseUint(os.Args[2], 10, 32)
+		id, err := strconv.ParseUint(args[1], 10, 32)
 		if err != nil {
 			fmt.Println("Invalid user ID:", err)
 			return
 		}
-		updateUser(ctx, client, uint32(id), os.Args[3], os.Args[4])
+		var version uint64
+		if len(args) > 4 {
+			version, err = strconv.ParseUint(args[4], 10, 64)
+			if err != nil {
+				fmt.Println("Invalid version:", err)
+				return
+			}
+		}
+		updateUser(ctx, client, &pb.UpdateUserRequest{Id: int64(id), Name: args[2], Email: args[3], Version: version})
+
+	case "patch":
+		if len(args) < 2 {
+			fmt.Println("Usage: client patch <user_id> [--name n] [--email e] [--version v]")
+			return
+		}
+		id, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			fmt.Println("Invalid user ID:", err)
+			return
+		}
+		flags := flag.NewFlagSet("patch", flag.ExitOnError)
+		name := flags.String("name", "", "new name")
+		email := flags.String("email", "", "new email")
+		version := flags.Uint64("version", 0, "only update while the user is at this version")
+		flags.Parse(args[2:])
+
+		// only the flags given end up in the mask
+		mask := &fieldmaskpb.FieldMask{}
+		flags.Visit(func(f *flag.Flag) {
+			if f.Name == "name" || f.Name == "email" {
+				mask.Paths = append(mask.Paths, f.Name)
+			}
+		})
+		if len(mask.Paths) == 0 {
+			fmt.Println("Nothing to update, give --name and/or --email")
+			return
+		}
+		updateUser(ctx, client, &pb.UpdateUserRequest{Id: int64(id), Name: *name, Email: *email, Version: *version, UpdateMask: mask})
 
 	case "delete":
-		if len(os.Args) < 3 {
+		if len(args) < 2 {
 			fmt.Println("Usage: client delete <user_id>")
 			return
 		}
-		deleteUser(ctx, client, os.Args[2])
+		deleteUser(ctx, client, args[1])
+
+	case "undelete":
+		if len(args) < 2 {
+			fmt.Println("Usage: client undelete <user_id>")
+			return
+		}
+		undeleteUser(ctx, client, args[1])
+
+	case "purge":
+		if len(args) < 2 {
+			fmt.Println("Usage: client purge <user_id>")
+			return
+		}
+		purgeUser(ctx, client, args[1])
+
+	case "set-password":
+		if len(args) < 3 {
+			fmt.Println("Usage: client set-password <user_id> <new_password> [current_password]")
+			return
+		}
+		current := ""
+		if len(args) > 3 {
+			current = args[3]
+		}
+		setPassword(ctx, client, args[1], current, args[2])
+
+	case "login":
+		if len(args) < 3 {
+			fmt.Println("Usage: client login <email> <password>")
+			return
+		}
+		login(ctx, client, args[1], args[2])
+
+	case "issue-session":
+		if len(args) < 2 {
+			fmt.Println("Usage: client issue-session <user_id>")
+			return
+		}
+		issueSession(ctx, client, args[1])
+
+	case "refresh":
+		if len(args) < 2 {
+			fmt.Println("Usage: client refresh <refresh_token>")
+			return
+		}
+		refreshSession(ctx, client, args[1])
+
+	case "sessions":
+		if len(args) < 2 {
+			fmt.Println("Usage: client sessions <user_id>")
+			return
+		}
+		listSessions(ctx, client, args[1])
+
+	case "revoke-session":
+		if len(args) < 3 {
+			fmt.Println("Usage: client revoke-session <user_id> <session_id>")
+			return
+		}
+		revokeSession(ctx, client, args[1], args[2])
+
+	case "verify-email":
+		if len(args) < 2 {
+			fmt.Println("Usage: client verify-email <token>")
+			return
+		}
+		verifyEmail(ctx, client, args[1])
+
+	case "resend-verification":
+		if len(args) < 2 {
+			fmt.Println("Usage: client resend-verification <user_id>")
+			return
+		}
+		resendVerification(ctx, client, args[1])
+
+	case "magic-link":
+		if len(args) < 2 {
+			fmt.Println("Usage: client magic-link <email>")
+			return
+		}
+		requestMagicLink(ctx, client, args[1])
+
+	case "redeem":
+		if len(args) < 2 {
+			fmt.Println("Usage: client redeem <token>")
+			return
+		}
+		redeemMagicLink(ctx, client, args[1])
+
+	case "export":
+		flags := flag.NewFlagSet("export", flag.ExitOnError)
+		format := flags.String("format", "csv", "csv, jsonl or json")
+		out := flags.String("out", "-", "file to write, - for stdout")
+		filter := flags.String("filter", "", "the same filter list accepts")
+		orderBy := flags.String("order-by", "", "the same order list accepts")
+		flags.Parse(args[1:])
+		// a large export can outlive the default request timeout
+		if err := exportUsers(context.Background(), client, *format, *out, *filter, *orderBy); err != nil {
+			log.Fatalf("Failed to export users: %v", err)
+		}
+
+	case "import":
+		flags := flag.NewFlagSet("import", flag.ExitOnError)
+		format := flags.String("format", "", "csv or jsonl, guessed from the file extension when empty")
+		onConflict := flags.String("on-conflict", "skip", "what to do with rows whose email is taken: skip, overwrite or fail")
+		flags.Parse(args[1:])
+		if flags.NArg() != 1 {
+			fmt.Println("Usage: client import [--format csv|jsonl] [--on-conflict skip|overwrite|fail] <file>")
+			return
+		}
+		// a large file can outlive the default request timeout
+		importUsers(context.Background(), client, flags.Arg(0), *format, *onConflict)
+
+	case "batch-create", "batch-update", "batch-delete":
+		flags := flag.NewFlagSet(command, flag.ExitOnError)
+		bestEffort := flags.Bool("best-effort", false, "write the items that can be written instead of all or nothing")
+		flags.Parse(args[1:])
+		mode := pb.BatchMode_ALL_OR_NOTHING
+		if *bestEffort {
+			mode = pb.BatchMode_BEST_EFFORT
+		}
+		items := flags.Args()
+
+		var resp *pb.BatchUsersResponse
+		switch command {
+		case "batch-create":
+			if len(items) == 0 || len(items)%2 != 0 {
+				fmt.Println("Usage: client batch-create [--best-effort] <name> <email> [<name> <email> ...]")
+				return
+			}
+			req := &pb.BatchCreateUsersRequest{Mode: mode}
+			for i := 0; i < len(items); i += 2 {
+				req.Users = append(req.Users, &pb.CreateUserRequest{Name: items[i], Email: items[i+1]})
+			}
+			resp, err = client.BatchCreateUsers(ctx, req)
+		case "batch-update":
+			if len(items) == 0 || len(items)%3 != 0 {
+				fmt.Println("Usage: client batch-update [--best-effort] <user_id> <name> <email> [<user_id> <name> <email> ...]")
+				return
+			}
+			req := &pb.BatchUpdateUsersRequest{Mode: mode}
+			for i := 0; i < len(items); i += 3 {
+				id, err := strconv.ParseUint(items[i], 10, 32)
+				if err != nil {
+					fmt.Println("Invalid user ID:", err)
+					return
+				}
+				req.Users = append(req.Users, &pb.UpdateUserRequest{Id: int64(id), Name: items[i+1], Email: items[i+2]})
+			}
+			resp, err = client.BatchUpdateUsers(ctx, req)
+		case "batch-delete":
+			if len(items) == 0 {
+				fmt.Println("Usage: client batch-delete [--best-effort] <user_id> [<user_id> ...]")
+				return
+			}
+			resp, err = client.BatchDeleteUsers(ctx, &pb.BatchDeleteUsersRequest{Ids: items, Mode: mode})
+		}
+		if err != nil {
+			log.Fatalf("Failed to run batch: %v", err)
+		}
+		printBatch(resp)
 
 	default:
 		printUsage()
@@ -78,12 +356,35 @@ func main() {
 }
 
 func printUsage() {
-	fmt.Println("Usage:")
+	fmt.Println("Usage: client [--addr host:port] [--cacert ca.pem] [--cert client.pem --key client-key.pem] <command>")
+	fmt.Println()
+	fmt.Println("Commands:")
 	fmt.Println("  client create <name> <email>")
 	fmt.Println("  client get <user_id>")
-	fmt.Println("  client list")
-	fmt.Println("  client update <user_id> <name> <email>")
+	fmt.Println("  client list [--page-size n] [--page-token t] [--filter expr] [--order-by field [asc|desc]] [--include-deleted]")
+	fmt.Println("  client stream")
+	fmt.Println("  client search <query> [limit]")
+	fmt.Println("  client health [service]")
+	fmt.Println("  client update <user_id> <name> <email> [version]")
+	fmt.Println("  client patch <user_id> [--name n] [--email e] [--version v]")
 	fmt.Println("  client delete <user_id>")
+	fmt.Println("  client undelete <user_id>")
+	fmt.Println("  client purge <user_id>")
+	fmt.Println("  client set-password <user_id> <new_password> [current_password]")
+	fmt.Println("  client login <email> <password>")
+	fmt.Println("  client issue-session <user_id>")
+	fmt.Println("  client refresh <refresh_token>")
+	fmt.Println("  client sessions <user_id>")
+	fmt.Println("  client revoke-session <user_id> <session_id>")
+	fmt.Println("  client verify-email <token>")
+	fmt.Println("  client resend-verification <user_id>")
+	fmt.Println("  client magic-link <email>")
+	fmt.Println("  client redeem <token>")
+	fmt.Println("  client export [--format csv|jsonl|json] [--out file] [--filter expr] [--order-by field [asc|desc]]")
+	fmt.Println("  client import [--format csv|jsonl] [--on-conflict skip|overwrite|fail] <file>")
+	fmt.Println("  client batch-create [--best-effort] <name> <email> [<name> <email> ...]")
+	fmt.Println("  client batch-update [--best-effort] <user_id> <name> <email> [...]")
+	fmt.Println("  client batch-delete [--best-effort] <user_id> [<user_id> ...]")
 }
 
 func createUser(ctx context.Context, client pb.UserServiceClient, name, email string) {
@@ -113,30 +414,104 @@ func getUser(ctx context.Context, client pb.UserServiceClient, id string) {
 	fmt.Printf("User ID: %s\n", user.Id)
 	fmt.Printf("Name: %s\n", user.Name)
 	fmt.Printf("Email: %s\n", user.Email)
+	fmt.Printf("Status: %s\n", user.Status)
+	fmt.Printf("Version: %d\n", user.Version)
 }
 
-func listUsers(ctx context.Context, client pb.UserServiceClient) {
-	resp, err := client.GetUsersList(ctx, &pb.Empty{})
+func listUsers(ctx context.Context, client pb.UserServiceClient, req *pb.UsersListRequest) {
+	resp, err := client.GetUsersList(ctx, req)
 	if err != nil {
 		log.Fatalf("Failed to list users: %v", err)
 	}
 
-	fmt.Printf("Total users: %d\n", len(resp.Users))
+	fmt.Printf("Users in page: %d\n", len(resp.Users))
 	for i, user := range resp.Users {
 		fmt.Printf("\nUser #%d:\n", i+1)
 		fmt.Printf("  ID: %s\n", user.Id)
 		fmt.Printf("  Name: %s\n", user.Name)
 		fmt.Printf("  Email: %s\n", user.Email)
+		if user.DeletedAt != nil {
+			fmt.Printf("  Deleted: %s\n", user.DeletedAt.AsTime().Local().Format(time.RFC3339))
+		}
+	}
+
+	if resp.NextPageToken != "" {
+		fmt.Printf("\nNext page token: %s\n", resp.NextPageToken)
 	}
 }
 
-func updateUser(ctx context.Context, client pb.UserServiceClient, id uint32, name, email string) {
-	req := &pb.UpdateUserRequest{
-		Id:    int64(id),
-		Name:  name,
-		Email: email,
+func streamUsers(ctx context.Context, client pb.UserServiceClient) {
+	stream, err := client.ListUsers(ctx, &pb.Empty{})
+	if err != nil {
+		log.Fatalf("Failed to stream users: %v", err)
+	}
+
+	// print users as they arrive instead of collecting them
+	count := 0
+	for {
+		user, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("Failed to stream users: %v", err)
+		}
+		count++
+		fmt.Printf("%s\t%s\t%s\n", user.Id, user.Name, user.Email)
 	}
 
+	fmt.Printf("Total users: %d\n", count)
+}
+
+func searchUsers(ctx context.Context, client pb.UserServiceClient, query string, limit int32) {
+	req := &pb.SearchUsersRequest{
+		Query: query,
+		Limit: limit,
+	}
+
+	resp, err := client.SearchUsers(ctx, req)
+	if err != nil {
+		log.Fatalf("Failed to search users: %v", err)
+	}
+
+	fmt.Printf("Matches: %d\n", len(resp.Results))
+	for i, result := range resp.Results {
+		fmt.Printf("\n#%d (score %.3f):\n", i+1, result.Score)
+		fmt.Printf("  ID: %s\n", result.User.Id)
+		fmt.Printf("  Name: %s\n", result.NameSnippet)
+		fmt.Printf("  Email: %s\n", result.EmailSnippet)
+	}
+}
+
+// bearerToken sends "authorization: Bearer <token>" with every call
+type bearerToken struct {
+	token  string
+	secure bool
+}
+
+func (t bearerToken) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + t.token}, nil
+}
+
+// once TLS is configured the token never goes out in plaintext, without it
+// the server may run without TLS during development and main warns instead
+func (t bearerToken) RequireTransportSecurity() bool {
+	return t.secure
+}
+
+func checkHealth(ctx context.Context, client healthpb.HealthClient, service string) {
+	resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		log.Fatalf("Failed to check health: %v", err)
+	}
+
+	fmt.Printf("Status: %s\n", resp.Status)
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		os.Exit(1)
+	}
+}
+
+func updateUser(ctx context.Context, client pb.UserServiceClient, req *pb.UpdateUserRequest) {
 	resp, err := client.UpdateUser(ctx, req)
 	if err != nil {
 		log.Fatalf("Failed to update user: %v", err)
@@ -157,3 +532,146 @@ func deleteUser(ctx context.Context, client pb.UserServiceClient, id string) {
 
 	fmt.Printf("Response: %s\n", resp.Status)
 }
+
+func undeleteUser(ctx context.Context, client pb.UserServiceClient, id string) {
+	user, err := client.UndeleteUser(ctx, &pb.SingleUserRequest{Id: id})
+	if err != nil {
+		log.Fatalf("Failed to undelete user: %v", err)
+	}
+
+	fmt.Printf("Restored user %s (%s), version %d\n", user.Id, user.Email, user.Version)
+}
+
+func purgeUser(ctx context.Context, client pb.UserServiceClient, id string) {
+	resp, err := client.PurgeUser(ctx, &pb.SingleUserRequest{Id: id})
+	if err != nil {
+		log.Fatalf("Failed to purge user: %v", err)
+	}
+
+	fmt.Printf("Response: %s\n", resp.Status)
+}
+
+func setPassword(ctx context.Context, client pb.UserServiceClient, id, current, next string) {
+	req := &pb.SetPasswordRequest{
+		Id:              id,
+		CurrentPassword: current,
+		NewPassword:     next,
+	}
+
+	resp, err := client.SetPassword(ctx, req)
+	if err != nil {
+		log.Fatalf("Failed to set password: %v", err)
+	}
+
+	fmt.Printf("Response: %s\n", resp.Status)
+}
+
+func login(ctx context.Context, client pb.UserServiceClient, email, password string) {
+	resp, err := client.Login(ctx, &pb.LoginRequest{Email: email, Password: password})
+	if err != nil {
+		log.Fatalf("Failed to log in: %v", err)
+	}
+
+	printTokens(resp)
+}
+
+func issueSession(ctx context.Context, client pb.UserServiceClient, userID string) {
+	resp, err := client.IssueSession(ctx, &pb.IssueSessionRequest{UserId: userID})
+	if err != nil {
+		log.Fatalf("Failed to issue session: %v", err)
+	}
+
+	printTokens(resp)
+}
+
+func refreshSession(ctx context.Context, client pb.UserServiceClient, refreshToken string) {
+	resp, err := client.RefreshSession(ctx, &pb.RefreshSessionRequest{RefreshToken: refreshToken})
+	if err != nil {
+		log.Fatalf("Failed to refresh session: %v", err)
+	}
+
+	printTokens(resp)
+}
+
+// printTokens prints the access token on its own line first so it can be
+// captured with e.g. CLEANGRPC_TOKEN=$(client login a@b.c pw | head -1)
+func printTokens(tokens *pb.SessionTokens) {
+	fmt.Println(tokens.AccessToken)
+	fmt.Printf("Refresh token: %s\n", tokens.RefreshToken)
+	fmt.Printf("Session: %s\n", tokens.SessionId)
+	fmt.Printf("Expires in: %ds\n", tokens.ExpiresIn)
+}
+
+func listSessions(ctx context.Context, client pb.UserServiceClient, userID string) {
+	resp, err := client.ListSessions(ctx, &pb.ListSessionsRequest{UserId: userID})
+	if err != nil {
+		log.Fatalf("Failed to list sessions: %v", err)
+	}
+
+	fmt.Printf("Sessions: %d\n", len(resp.Sessions))
+	for _, session := range resp.Sessions {
+		fmt.Printf("%s\tcreated %s\tlast used %s\texpires %s\n", session.Id,
+			session.CreatedAt.AsTime().Format(time.RFC3339),
+			session.LastUsedAt.AsTime().Format(time.RFC3339),
+			session.ExpiresAt.AsTime().Format(time.RFC3339))
+	}
+}
+
+func revokeSession(ctx context.Context, client pb.UserServiceClient, userID, sessionID string) {
+	resp, err := client.RevokeSession(ctx, &pb.RevokeSessionRequest{UserId: userID, SessionId: sessionID})
+	if err != nil {
+		log.Fatalf("Failed to revoke session: %v", err)
+	}
+
+	fmt.Printf("Response: %s\n", resp.Status)
+}
+
+func verifyEmail(ctx context.Context, client pb.UserServiceClient, token string) {
+	resp, err := client.VerifyEmail(ctx, &pb.VerifyEmailRequest{Token: token})
+	if err != nil {
+		log.Fatalf("Failed to verify email: %v", err)
+	}
+
+	fmt.Printf("Response: %s\n", resp.Status)
+}
+
+func resendVerification(ctx context.Context, client pb.UserServiceClient, id string) {
+	resp, err := client.ResendVerification(ctx, &pb.SingleUserRequest{Id: id})
+	if err != nil {
+		log.Fatalf("Failed to resend verification email: %v", err)
+	}
+
+	fmt.Printf("Response: %s\n", resp.Status)
+}
+
+func requestMagicLink(ctx context.Context, client pb.UserServiceClient, email string) {
+	resp, err := client.RequestMagicLink(ctx, &pb.RequestMagicLinkRequest{Email: email})
+	if err != nil {
+		log.Fatalf("Failed to request sign-in link: %v", err)
+	}
+
+	fmt.Printf("Response: %s\n", resp.Status)
+}
+
+func redeemMagicLink(ctx context.Context, client pb.UserServiceClient, token string) {
+	tokens, err := client.RedeemMagicLink(ctx, &pb.RedeemMagicLinkRequest{Token: token})
+	if err != nil {
+		log.Fatalf("Failed to sign in: %v", err)
+	}
+
+	printTokens(tokens)
+}
+
+func printBatch(resp *pb.BatchUsersResponse) {
+	for _, result := range resp.Results {
+		switch {
+		case result.Error != nil:
+			fmt.Printf("#%d failed: %s (%s)\n", result.Index, result.Error.Message, result.Error.Reason)
+		case result.User != nil:
+			fmt.Printf("#%d ok: ID %s, %s <%s>, version %d\n", result.Index, result.User.Id, result.User.Name, result.User.Email, result.User.Version)
+		default:
+			fmt.Printf("#%d ok\n", result.Index)
+		}
+	}
+	fmt.Printf("Succeeded: %d, Failed: %d\n", resp.Succeeded, resp.Failed)
+}
@@ -1,44 +1,368 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"flag"
 	"log"
+	"log/slog"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/yishak-cs/CleanGrpc/Internal/auth"
+	"github.com/yishak-cs/CleanGrpc/Internal/config"
 	"github.com/yishak-cs/CleanGrpc/Internal/db"
+	dbhealth "github.com/yishak-cs/CleanGrpc/Internal/health"
+	"github.com/yishak-cs/CleanGrpc/Internal/mail"
+	"github.com/yishak-cs/CleanGrpc/Internal/tlsconfig"
+	"github.com/yishak-cs/CleanGrpc/Internal/tracing"
 	interfaces "github.com/yishak-cs/CleanGrpc/pkg/v1"
 	repository "github.com/yishak-cs/CleanGrpc/pkg/v1/Repository"
 	usecase "github.com/yishak-cs/CleanGrpc/pkg/v1/UseCase"
 	handler "github.com/yishak-cs/CleanGrpc/pkg/v1/handler/grpc"
+	"github.com/yishak-cs/CleanGrpc/pkg/v1/middleware"
+	pb "github.com/yishak-cs/CleanGrpc/proto"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 	"gorm.io/gorm"
 )
 
 func main() {
+	// resolve defaults, config file, environment and flags
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+
+	level, _ := cfg.SlogLevel()
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+
+	// SIGINT and SIGTERM start a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// connect to a database
-	db := db.DBconn()
+	db := db.DBconn(cfg.DatabaseDSN)
 
 	//grpc server listen tcp connection on address string
-	listener, err := net.Listen("tcp", "localhost:50000")
+	listener, err := net.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		log.Fatalf("unable to listen on %s: %v", cfg.ListenAddr, err)
+	}
+
+	// export spans of the server, use case and database
+	shutdownTracing, err := setupTracing(cfg, db)
+	if err != nil {
+		log.Fatalf("unable to set up tracing: %v", err)
+	}
+
+	// prometheus metrics on the optional admin listener
+	var metrics *middleware.Metrics
+	var admin *http.Server
+	if cfg.MetricsAddr != "" {
+		reg, err := newMetricsRegistry(db)
+		if err != nil {
+			log.Fatalf("unable to set up metrics: %v", err)
+		}
+		metrics = middleware.NewMetrics(reg)
+		admin = serveMetrics(cfg.MetricsAddr, reg)
+	}
+
+	// bearer token authentication, when keys are configured
+	authenticator, err := newAuthenticator(cfg.Auth)
+	if err != nil {
+		log.Fatalf("unable to set up authentication: %v", err)
+	}
+
+	chain := middleware.Chain{Logger: slog.Default(), Metrics: metrics, Auth: authenticator}
+	// role rules only mean something once callers are authenticated
+	if authenticator != nil {
+		chain.Policy = middleware.NewPolicy(cfg.Authz.Rules)
+	}
+	server := grpc.NewServer(serverOptions(cfg, chain)...)
+
+	// Login signs tokens with the HS256 secret, when there is one
+	issuer, err := newIssuer(cfg.Auth)
 	if err != nil {
-		fmt.Println("unable to get Listener")
+		log.Fatalf("unable to set up login: %v", err)
 	}
-	server := grpc.NewServer()
+
+	// verification emails and sign-in links, unless the mail driver is none
+	mailer, closeMailer, err := newMailer(cfg.Mail)
+	if err != nil {
+		log.Fatalf("unable to set up mail: %v", err)
+	}
+	defer closeMailer()
+
+	//create a type that implements RepoInterface
+	repo := repository.NewRepo(db)
 
 	// get a type that implements UseCaseInterface
-	uc := initUserServer(db)
+	opts := []usecase.Option{
+		usecase.WithIssuer(issuer),
+		usecase.WithLockout(usecase.Lockout{Threshold: cfg.Auth.LockoutThreshold, Duration: cfg.Auth.LockoutDuration}),
+		usecase.WithSessionTTL(cfg.Auth.RefreshTokenTTL),
+		usecase.WithEmailVerification(usecase.EmailVerification{URL: cfg.Mail.VerifyURL, TTL: cfg.Mail.VerifyTokenTTL}),
+		usecase.WithMagicLink(usecase.MagicLink{
+			URL:    cfg.Mail.MagicLinkURL,
+			TTL:    cfg.Mail.MagicLinkTTL,
+			Limit:  cfg.Mail.MagicLinkRateLimit,
+			Window: cfg.Mail.MagicLinkRateWindow,
+		}),
+	}
+	if mailer != nil {
+		opts = append(opts, usecase.WithMailer(mailer))
+	}
+	uc := usecase.NewUseCase(repo, opts...)
+
+	// expired sessions and email tokens are deleted in the background
+	go usecase.NewSessionSweeper(repo, cfg.Auth.SessionSweepInterval).Run(ctx)
+	// and so are users deleted longer than the retention period ago
+	if cfg.Purge.Enabled() {
+		go usecase.NewUserPurger(repo, cfg.Purge.Retention, cfg.Purge.Interval).Run(ctx)
+	}
 
 	//register the UserService handler on the server
 	handler.NewUserServer(server, uc)
 
+	// report the server and UserService as serving while the database answers
+	// pings and until shutdown begins
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Fatalf("unable to get database handle: %v", err)
+	}
+	checker := dbhealth.NewChecker(healthServer, sqlDB, cfg.HealthInterval, pb.UserService_ServiceDesc.ServiceName)
+	go checker.Run(ctx)
+
+	// let grpcurl and friends discover the services
+	if cfg.Reflection {
+		reflection.Register(server)
+	}
+
 	// start serving to the address
-	log.Fatal(server.Serve(listener))
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("serving", "addr", listener.Addr().String(), "tls", cfg.TLS.Enabled(), "mtls", cfg.TLS.ClientCAFile != "", "reflection", cfg.Reflection)
+		serveErr <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-serveErr:
+		log.Fatalf("server stopped unexpectedly: %v", err)
+	case <-ctx.Done():
+		stop()
+	}
+
+	slog.Info("shutting down", "drain_timeout", cfg.ShutdownTimeout)
+	shutdown(server, healthServer, uc, db, cfg.ShutdownTimeout)
+	// metrics stay scrapeable while RPCs drain
+	if admin != nil {
+		if err := admin.Close(); err != nil {
+			slog.Error("unable to close metrics listener", "err", err)
+		}
+	}
+	// flush the spans of the last RPCs
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Error("unable to flush traces", "err", err)
+	}
+	slog.Info("shutdown complete")
 }
 
-func initUserServer(db *gorm.DB) interfaces.UseCaseInterface {
-	//create a type that implements RepoInterface
-	repo := repository.NewRepo(db)
-	//return the UseCaseInterface instance to the called
-	return usecase.NewUseCase(repo)
+// shutdown tells health checkers to stop routing here, lets in-flight RPCs
+// finish for up to timeout before cutting off the rest, gives the mails they
+// left sending as long again, and only then closes the database so nothing
+// is left writing to it
+func shutdown(server *grpc.Server, healthServer *health.Server, uc interfaces.UseCaseInterface, db *gorm.DB, timeout time.Duration) {
+	healthServer.Shutdown()
+
+	drained := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(timeout):
+		slog.Warn("drain timeout exceeded, cancelling remaining RPCs")
+		// Stop cancels the contexts of the remaining RPCs which aborts their
+		// queries, GracefulStop then returns once the handlers are gone
+		server.Stop()
+		<-drained
+	}
+
+	// mails the RPCs left sending still need the database and the mailer
+	sent := make(chan struct{})
+	go func() {
+		uc.Wait()
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(timeout):
+		slog.Warn("mail timeout exceeded, closing the database under pending mails")
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		slog.Error("unable to get database handle", "err", err)
+		return
+	}
+	if err := sqlDB.Close(); err != nil {
+		slog.Error("unable to close database", "err", err)
+	}
+}
+
+// setupTracing installs the configured exporter and, when spans are exported
+// at all, traces every statement gormDB runs
+func setupTracing(cfg *config.Config, gormDB *gorm.DB) (func(context.Context) error, error) {
+	shutdown, err := tracing.Setup(context.Background(), cfg.Tracing, os.Stdout)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Tracing.Exporter != config.TracingNone {
+		if err := db.RegisterTracing(gormDB, otel.GetTracerProvider()); err != nil {
+			return nil, err
+		}
+	}
+	return shutdown, nil
+}
+
+// newMetricsRegistry collects runtime, process and database metrics. RPC
+// metrics are added by the caller once the interceptor is created
+func newMetricsRegistry(gormDB *gorm.DB) (*prometheus.Registry, error) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	if err := db.RegisterMetrics(gormDB, reg); err != nil {
+		return nil, err
+	}
+	return reg, nil
+}
+
+// serveMetrics exposes reg on /metrics at addr. a failing admin listener is
+// logged but does not take the gRPC server down with it
+func serveMetrics(addr string, reg *prometheus.Registry) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg}))
+	admin := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		slog.Info("serving metrics", "addr", addr)
+		if err := admin.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("metrics listener stopped", "err", err)
+		}
+	}()
+	return admin
+}
+
+// newAuthenticator loads the configured token keys. without any, every
+// caller is let through and a warning says so
+func newAuthenticator(cfg config.Auth) (*middleware.Authenticator, error) {
+	if !cfg.Enabled() {
+		slog.Warn("authentication disabled, every caller can invoke every RPC")
+		return nil, nil
+	}
+
+	verifierCfg := auth.VerifierConfig{Issuer: cfg.Issuer, Audience: cfg.Audience}
+	if cfg.HMACSecretFile != "" {
+		secret, err := auth.LoadHMACSecret(cfg.HMACSecretFile)
+		if err != nil {
+			return nil, err
+		}
+		verifierCfg.HMACSecret = secret
+	}
+	if cfg.JWKSFile != "" {
+		keys, err := auth.LoadJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		verifierCfg.RSAKeys = keys
+	}
+	verifier, err := auth.NewVerifier(verifierCfg)
+	if err != nil {
+		return nil, err
+	}
+	return middleware.NewAuthenticator(verifier, cfg.PublicMethods, slog.Default()), nil
+}
+
+// newIssuer signs the tokens handed out by Login with the HS256 secret the
+// authenticator verifies them with. RS256 keys are public only, so without
+// the secret Login is disabled
+func newIssuer(cfg config.Auth) (*auth.Issuer, error) {
+	if cfg.HMACSecretFile == "" {
+		slog.Warn("no auth.hmac_secret_file, Login is disabled")
+		return nil, nil
+	}
+	secret, err := auth.LoadHMACSecret(cfg.HMACSecretFile)
+	if err != nil {
+		return nil, err
+	}
+	return auth.NewIssuer(auth.IssuerConfig{
+		HMACSecret: secret,
+		Issuer:     cfg.Issuer,
+		Audience:   cfg.Audience,
+		AccessTTL:  cfg.AccessTokenTTL,
+	})
+}
+
+// newMailer returns the Mailer of the configured driver and a function
+// closing it. the none driver returns a nil Mailer, which leaves new users
+// active without verifying their address and disables sign-in links
+func newMailer(cfg config.Mail) (mail.Mailer, func(), error) {
+	switch cfg.Driver {
+	case config.MailStdout:
+		return mail.NewWriter(os.Stdout, cfg.From), func() {}, nil
+	case config.MailFile:
+		mailer, err := mail.OpenFile(cfg.File, cfg.From)
+		if err != nil {
+			return nil, nil, err
+		}
+		return mailer, func() {
+			if err := mailer.Close(); err != nil {
+				slog.Error("unable to close mail file", "err", err)
+			}
+		}, nil
+	default:
+		slog.Warn("mail.driver is none, email addresses are not verified and sign-in links are disabled")
+		return nil, func() {}, nil
+	}
+}
+
+func serverOptions(cfg *config.Config, chain middleware.Chain) []grpc.ServerOption {
+	opts := []grpc.ServerOption{grpc.ConnectionTimeout(cfg.ConnectionTimeout)}
+	// a server span per RPC, continuing the caller's trace if it sent one
+	opts = append(opts, grpc.StatsHandler(otelgrpc.NewServerHandler()))
+	// request IDs, metrics, access logs, panic recovery, authentication and
+	// authorization on every RPC
+	opts = append(opts, chain.ServerOptions()...)
+	if cfg.TLS.Enabled() {
+		// the certificate is reloaded from disk when it is replaced
+		tlsCfg, err := tlsconfig.Server(cfg.TLS)
+		if err != nil {
+			log.Fatalf("unable to load TLS configuration: %v", err)
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsCfg)))
+	}
+	return opts
 }
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+	"gorm.io/gorm"
+)
+
+// rows per INSERT statement of CreateUsers, well below the bound parameter
+// limit of sqlite
+const createBatchSize = 100
+
+// CreateUsers inserts users in a single transaction, createBatchSize rows per
+// statement, and sets their IDs. either all of them are created or none
+func (repo *Repo) CreateUsers(ctx context.Context, users []*model.User) error {
+	if len(users) == 0 {
+		return nil
+	}
+	err := repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return tx.CreateInBatches(users, createBatchSize).Error
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create users: %w", err)
+	}
+	return nil
+}
+
+// UpdateUsers applies every update the way UpdateUser does, all of them in a
+// single transaction. the first update that fails rolls back the others and
+// comes back as a *model.BatchItemError naming it
+func (repo *Repo) UpdateUsers(ctx context.Context, updates []model.UserUpdate) error {
+	return repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		txRepo := &Repo{tx}
+		for i, update := range updates {
+			if err := txRepo.UpdateUser(ctx, update.User, update.Fields); err != nil {
+				return &model.BatchItemError{Index: i, Err: err}
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteUsers soft deletes users ids in a single statement
+func (repo *Repo) DeleteUsers(ctx context.Context, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := repo.db.WithContext(ctx).Delete(&model.User{}, ids).Error; err != nil {
+		return fmt.Errorf("failed to delete users: %w", err)
+	}
+	return nil
+}
+
+// GetUsersByIDs returns those of users ids that exist, in no particular order
+func (repo *Repo) GetUsersByIDs(ctx context.Context, ids []uint) ([]*model.User, error) {
+	var users []*model.User
+	if len(ids) == 0 {
+		return users, nil
+	}
+	if err := repo.db.WithContext(ctx).Where("id IN ?", ids).Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", err)
+	}
+	return users, nil
+}
+
+// GetUsersByEmails returns every user holding one of emails, so a whole batch
+// is checked for taken addresses in one query
+func (repo *Repo) GetUsersByEmails(ctx context.Context, emails []string) ([]*model.User, error) {
+	var users []*model.User
+	if len(emails) == 0 {
+		return users, nil
+	}
+	if err := repo.db.WithContext(ctx).Where("email IN ?", emails).Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("failed to get users by email: %w", err)
+	}
+	return users, nil
+}
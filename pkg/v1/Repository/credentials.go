@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+	"gorm.io/gorm"
+)
+
+// SetPasswordHash replaces the password of user id. a new password also
+// clears failed logins and any lockout they caused
+func (repo *Repo) SetPasswordHash(ctx context.Context, id uint, hash string) error {
+	err := repo.db.WithContext(ctx).Model(&model.User{}).Where("id = ?", id).Updates(map[string]any{
+		"password_hash": hash,
+		"failed_logins": 0,
+		"locked_until":  nil,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to set password: %w", err)
+	}
+	return nil
+}
+
+// RecordLoginFailure counts one more failed login of user id and returns the
+// new count. the increment happens in the database so concurrent failures
+// are never lost
+func (repo *Repo) RecordLoginFailure(ctx context.Context, id uint) (int, error) {
+	var failures int
+	err := repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&model.User{}).Where("id = ?", id).
+			UpdateColumn("failed_logins", gorm.Expr("failed_logins + 1")).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&model.User{}).Where("id = ?", id).Select("failed_logins").Scan(&failures).Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to record login failure: %w", err)
+	}
+	return failures, nil
+}
+
+// SetLoginLock stores the failed login count of user id and the end of its
+// lockout, nil for none
+func (repo *Repo) SetLoginLock(ctx context.Context, id uint, failures int, lockedUntil *time.Time) error {
+	err := repo.db.WithContext(ctx).Model(&model.User{}).Where("id = ?", id).UpdateColumns(map[string]any{
+		"failed_logins": failures,
+		"locked_until":  lockedUntil,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to update login lock: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+	"gorm.io/gorm"
+)
+
+// GetUserIncludingDeleted is GetUser that also finds soft deleted users,
+// their DeletedAt is valid
+func (repo *Repo) GetUserIncludingDeleted(ctx context.Context, id string) (*model.User, error) {
+	var user model.User
+	if err := repo.db.WithContext(ctx).Unscoped().First(&user, id).Error; err != nil {
+		return &user, fmt.Errorf("failed to get user: %w", err)
+	}
+	return &user, nil
+}
+
+// RestoreUser undoes the soft delete of user id, which is a new version of
+// it. it fails with gorm.ErrRecordNotFound unless the user is soft deleted
+func (repo *Repo) RestoreUser(ctx context.Context, id uint) error {
+	result := repo.db.WithContext(ctx).Unscoped().Model(&model.User{}).
+		Where("id = ? AND deleted_at IS NOT NULL", id).
+		Updates(map[string]any{"deleted_at": nil, "version": gorm.Expr("version + 1")})
+	if result.Error != nil {
+		return fmt.Errorf("failed to restore user: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("failed to restore user: %w", gorm.ErrRecordNotFound)
+	}
+	return nil
+}
+
+// PurgeUser permanently removes the soft deleted user id along with its
+// sessions, email tokens and magic links. it fails with
+// gorm.ErrRecordNotFound unless the user is soft deleted, a live user is
+// never purged
+func (repo *Repo) PurgeUser(ctx context.Context, id uint) error {
+	return repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id).Delete(&model.User{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to purge user: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("failed to purge user: %w", gorm.ErrRecordNotFound)
+		}
+		return deleteUserRecords(tx, id)
+	})
+}
+
+// PurgeDeletedUsers permanently removes every user soft deleted before
+// before, with their sessions, email tokens and magic links, and returns how
+// many users
+func (repo *Repo) PurgeDeletedUsers(ctx context.Context, before time.Time) (int64, error) {
+	var purged int64
+	err := repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		expired := tx.Unscoped().Model(&model.User{}).Select("id").
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", sqliteTime(before))
+		if err := deleteUserRecords(tx, expired); err != nil {
+			return err
+		}
+		result := tx.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", sqliteTime(before)).Delete(&model.User{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to purge deleted users: %w", result.Error)
+		}
+		purged = result.RowsAffected
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
+
+// deleteUserRecords deletes the sessions, email tokens and magic links of
+// users, an id or a query selecting ids
+func deleteUserRecords(tx *gorm.DB, users any) error {
+	if err := tx.Where("user_id IN (?)", users).Delete(&model.Session{}).Error; err != nil {
+		return fmt.Errorf("failed to delete sessions of purged users: %w", err)
+	}
+	if err := tx.Where("user_id IN (?)", users).Delete(&model.EmailToken{}).Error; err != nil {
+		return fmt.Errorf("failed to delete email tokens of purged users: %w", err)
+	}
+	if err := tx.Where("user_id IN (?)", users).Delete(&model.MagicLinkToken{}).Error; err != nil {
+		return fmt.Errorf("failed to delete magic links of purged users: %w", err)
+	}
+	return nil
+}
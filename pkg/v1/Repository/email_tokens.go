@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+	"gorm.io/gorm"
+)
+
+// ReplaceEmailToken stores token, dropping any earlier token of the same
+// user and purpose so only the latest mail works
+func (repo *Repo) ReplaceEmailToken(ctx context.Context, token *model.EmailToken) error {
+	err := repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND purpose = ?", token.UserID, token.Purpose).Delete(&model.EmailToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+	if err != nil {
+		return fmt.Errorf("unable to store email token: %w", err)
+	}
+	return nil
+}
+
+// ConsumeEmailToken deletes the token with hash and purpose and returns it.
+// of two concurrent calls for the same token only one gets it, the other
+// fails with gorm.ErrRecordNotFound like an unknown token does
+func (repo *Repo) ConsumeEmailToken(ctx context.Context, hash, purpose string) (*model.EmailToken, error) {
+	var token model.EmailToken
+	err := repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("hash = ? AND purpose = ?", hash, purpose).First(&token).Error; err != nil {
+			return err
+		}
+		result := tx.Where("hash = ?", hash).Delete(&model.EmailToken{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume email token: %w", err)
+	}
+	return &token, nil
+}
+
+// DeleteExpiredEmailTokens removes every email token that expired before now
+// and returns how many there were
+func (repo *Repo) DeleteExpiredEmailTokens(ctx context.Context, now time.Time) (int64, error) {
+	result := repo.db.WithContext(ctx).Where("expires_at <= ?", now).Delete(&model.EmailToken{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to delete expired email tokens: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
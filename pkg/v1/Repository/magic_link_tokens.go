@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+	"gorm.io/gorm"
+)
+
+// ReplaceMagicLinkToken stores token, dropping any earlier magic link of the
+// same user so only the latest mail works
+func (repo *Repo) ReplaceMagicLinkToken(ctx context.Context, token *model.MagicLinkToken) error {
+	err := repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", token.UserID).Delete(&model.MagicLinkToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+	if err != nil {
+		return fmt.Errorf("unable to store magic link token: %w", err)
+	}
+	return nil
+}
+
+// ConsumeMagicLinkToken deletes the magic link with hash and returns it. of
+// two concurrent calls for the same link only one gets it, the other fails
+// with gorm.ErrRecordNotFound like an unknown link does
+func (repo *Repo) ConsumeMagicLinkToken(ctx context.Context, hash string) (*model.MagicLinkToken, error) {
+	var token model.MagicLinkToken
+	err := repo.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("hash = ?", hash).First(&token).Error; err != nil {
+			return err
+		}
+		result := tx.Where("hash = ?", hash).Delete(&model.MagicLinkToken{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume magic link token: %w", err)
+	}
+	return &token, nil
+}
+
+// DeleteExpiredMagicLinkTokens removes every magic link that expired before
+// now and returns how many there were
+func (repo *Repo) DeleteExpiredMagicLinkTokens(ctx context.Context, now time.Time) (int64, error) {
+	result := repo.db.WithContext(ctx).Where("expires_at <= ?", now).Delete(&model.MagicLinkToken{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to delete expired magic link tokens: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
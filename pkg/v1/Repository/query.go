@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+	"gorm.io/gorm"
+)
+
+// the only column names that are ever written into SQL for a listing
+var userColumns = map[model.UserField]string{
+	model.FieldID:        "id",
+	model.FieldName:      "name",
+	model.FieldEmail:     "email",
+	model.FieldCreatedAt: "created_at",
+}
+
+// the only comparison operators that are ever written into SQL for a listing
+var rangeOperators = map[model.FilterOp]string{
+	model.OpLess:         "<",
+	model.OpLessEqual:    "<=",
+	model.OpGreater:      ">",
+	model.OpGreaterEqual: ">=",
+}
+
+// escapes LIKE wildcards so a value only ever matches itself
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// applyUserQuery adds the conditions, ordering and keyset position of query
+// to tx
+func applyUserQuery(tx *gorm.DB, query model.UserQuery) (*gorm.DB, error) {
+	if query.IncludeDeleted {
+		tx = tx.Unscoped()
+	}
+	for _, condition := range query.Conditions {
+		column, ok := userColumns[condition.Field]
+		if !ok {
+			return nil, fmt.Errorf("cannot filter on unknown field %q", condition.Field)
+		}
+
+		switch condition.Op {
+		case model.OpEquals:
+			tx = tx.Where(column+" = ?", condition.Value)
+		case model.OpPrefix:
+			tx = tx.Where(column+` LIKE ? ESCAPE '\'`, likeEscaper.Replace(condition.Value)+"%")
+		case model.OpSuffix:
+			tx = tx.Where(column+` LIKE ? ESCAPE '\'`, "%"+likeEscaper.Replace(condition.Value))
+		case model.OpContains:
+			tx = tx.Where(column+` LIKE ? ESCAPE '\'`, "%"+likeEscaper.Replace(condition.Value)+"%")
+		default:
+			operator, ok := rangeOperators[condition.Op]
+			if !ok {
+				return nil, fmt.Errorf("unknown filter operator %q", condition.Op)
+			}
+			tx = tx.Where(column+" "+operator+" ?", sqliteTime(condition.Time))
+		}
+	}
+
+	column, ok := userColumns[query.OrderBy.Field]
+	if !ok {
+		column = "id"
+	}
+	direction, seek := "ASC", ">"
+	if query.OrderBy.Desc {
+		direction, seek = "DESC", "<"
+	}
+
+	if after := query.After; after != nil {
+		if column == "id" {
+			tx = tx.Where("id "+seek+" ?", after.ID)
+		} else {
+			value := after.Value
+			if t, ok := value.(time.Time); ok {
+				value = sqliteTime(t)
+			}
+			tx = tx.Where("("+column+" "+seek+" ? OR ("+column+" = ? AND id "+seek+" ?))", value, value, after.ID)
+		}
+	}
+
+	if column != "id" {
+		tx = tx.Order(column + " " + direction)
+	}
+	return tx.Order("id " + direction), nil
+}
+
+// sqlite keeps timestamps as text in the zone GORM wrote them in, which is
+// local time, so comparisons only line up when the argument is local too
+func sqliteTime(t time.Time) time.Time {
+	return t.Local()
+}
@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/yishak-cs/CleanGrpc/Internal/model"
@@ -8,6 +9,9 @@ import (
 	"gorm.io/gorm"
 )
 
+// number of rows StreamUsers holds in memory at a time
+const streamBatchSize = 500
+
 // is responsible for interactiong with the database well not the database exactly
 // but gorm. its where actual data access is performed from datasource in ourcase
 // db
@@ -20,55 +24,123 @@ func NewRepo(db *gorm.DB) interfaces.RepoInterface {
 	return &Repo{db}
 }
 
-func (repo *Repo) CreateUser(user *model.User) (*model.User, error) {
-	err := repo.db.Create(user).Error
+func (repo *Repo) CreateUser(ctx context.Context, user *model.User) (*model.User, error) {
+	err := repo.db.WithContext(ctx).Create(user).Error
 	if err != nil {
 		return &model.User{}, fmt.Errorf("unable to create user: %w", err)
 	}
 	return user, nil
 }
 
-func (repo *Repo) GetUser(id string) (*model.User, error) {
+func (repo *Repo) GetUser(ctx context.Context, id string) (*model.User, error) {
 	var user model.User
-	if resp := repo.db.First(&user, id).Error; resp != nil {
+	if resp := repo.db.WithContext(ctx).First(&user, id).Error; resp != nil {
 		return &user, fmt.Errorf("failed to get user: %w", resp)
 	}
 	return &user, nil
 }
 
-func (repo *Repo) GetUsersList() []*model.User {
+// GetUsersList seeks past query.After on the sort key instead of using an
+// offset so every page costs the same no matter how deep it is. column names
+// and operators only ever come from the whitelists in query.go, user supplied
+// values are always bound as parameters
+func (repo *Repo) GetUsersList(ctx context.Context, query model.UserQuery) ([]*model.User, error) {
+	tx, err := applyUserQuery(repo.db.WithContext(ctx).Model(&model.User{}), query)
+	if err != nil {
+		return nil, err
+	}
+
 	var users []*model.User
-	resp := repo.db.Find(&users)
-	fmt.Printf("%d rows affected", resp.RowsAffected)
-	return users
+	if err := tx.Limit(query.Limit).Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	return users, nil
 }
 
-func (repo *Repo) UpdateUser(data *model.User) error {
-	user, err := repo.GetUser(fmt.Sprintf("%d", data.ID))
+// StreamUsers walks the whole users table in primary key order, loading it
+// streamBatchSize rows at a time and handing each user to fn. returning an
+// error from fn stops the walk and is passed back to the caller
+func (repo *Repo) StreamUsers(ctx context.Context, fn func(*model.User) error) error {
+	var batch []*model.User
+	err := repo.db.WithContext(ctx).FindInBatches(&batch, streamBatchSize, func(tx *gorm.DB, _ int) error {
+		for _, user := range batch {
+			if err := fn(user); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to stream users: %w", err)
+	}
+	return nil
+}
+
+// UpdateUser writes the listed fields (all of model.UserUpdatableFields when
+// there are none) and, when set, the status of user data.ID in a single
+// statement and bumps its version. a non-zero
+// data.Version makes the update conditional: it fails with
+// model.ErrVersionConflict unless that is still the stored version
+func (repo *Repo) UpdateUser(ctx context.Context, data *model.User, fields []string) error {
+	if len(fields) == 0 {
+		fields = model.UserUpdatableFields
+	}
+	updates := map[string]any{"version": gorm.Expr("version + 1")}
+	for _, field := range fields {
+		switch field {
+		case model.UserFieldName:
+			updates["name"] = data.Name
+		case model.UserFieldEmail:
+			updates["email"] = data.Email
+		default:
+			return fmt.Errorf("failed to update user: unknown field %q", field)
+		}
+	}
+	// an empty status leaves the stored one alone
+	if data.Status != "" {
+		updates["status"] = data.Status
 	}
-	user.Name = data.Name
-	user.Email = data.Email
 
-	if err := repo.db.Save(user).Error; err != nil {
-		return fmt.Errorf("failed to update user: %w", err)
+	tx := repo.db.WithContext(ctx).Model(&model.User{}).Where("id = ?", data.ID)
+	if data.Version != 0 {
+		tx = tx.Where("version = ?", data.Version)
+	}
+	result := tx.Updates(updates)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update user: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		// tell a missing user from a stale version
+		if _, err := repo.GetUser(ctx, fmt.Sprintf("%d", data.ID)); err != nil {
+			return err
+		}
+		return fmt.Errorf("failed to update user: %w", model.ErrVersionConflict)
 	}
 
 	return nil
 }
 
-func (repo *Repo) DeleteUser(id string) error {
-	if err := repo.db.Delete(&model.User{}, id).Error; err != nil {
+// SetUserStatus moves user id to status, which is a new version of it
+func (repo *Repo) SetUserStatus(ctx context.Context, id uint, status string) error {
+	err := repo.db.WithContext(ctx).Model(&model.User{}).Where("id = ?", id).
+		Updates(map[string]any{"status": status, "version": gorm.Expr("version + 1")}).Error
+	if err != nil {
+		return fmt.Errorf("failed to set user status: %w", err)
+	}
+	return nil
+}
+
+func (repo *Repo) DeleteUser(ctx context.Context, id string) error {
+	if err := repo.db.WithContext(ctx).Delete(&model.User{}, id).Error; err != nil {
 		return fmt.Errorf("failed to delete the user: %w", err)
 	}
 
 	return nil
 }
 
-func (repo *Repo) GetUserByEmail(email string) (*model.User, error) {
+func (repo *Repo) GetUserByEmail(ctx context.Context, email string) (*model.User, error) {
 	var user model.User
-	if err := repo.db.Where("email=?", email).First(&user).Error; err != nil {
+	if err := repo.db.WithContext(ctx).Where("email=?", email).First(&user).Error; err != nil {
 		return &user, fmt.Errorf("failed to get user by email: %w", err)
 	}
 	return &user, nil
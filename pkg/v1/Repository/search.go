@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/yishak-cs/CleanGrpc/Internal/db"
+	"github.com/yishak-cs/CleanGrpc/Internal/errs"
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+)
+
+const (
+	// markers placed around matched terms in snippets
+	highlightOpen  = "<mark>"
+	highlightClose = "</mark>"
+)
+
+// bm25 weighs a name match twice as much as an email match. it is smaller for
+// better matches so it is negated into a score that grows with relevance
+const searchQuery = `
+SELECT users.*,
+	-bm25(users_fts, 2.0, 1.0) AS score,
+	snippet(users_fts, 0, ?, ?, '…', 16) AS name_snippet,
+	snippet(users_fts, 1, ?, ?, '…', 16) AS email_snippet
+FROM users_fts
+JOIN users ON users.id = users_fts.rowid
+WHERE users_fts MATCH ? AND users.deleted_at IS NULL
+ORDER BY score DESC, users.id
+LIMIT ?`
+
+type searchRow struct {
+	model.User
+	Score        float64
+	NameSnippet  string
+	EmailSnippet string
+}
+
+// SearchUsers runs match, an FTS5 query expression, against the search index
+// and returns the best limit hits, most relevant first
+func (repo *Repo) SearchUsers(ctx context.Context, match string, limit int) ([]*model.UserSearchResult, error) {
+	if !repo.db.WithContext(ctx).Migrator().HasTable(db.SearchTable) {
+		return nil, errs.NewFailedPrecondition("SEARCH_UNAVAILABLE", "full-text search is not available on this server")
+	}
+
+	var rows []searchRow
+	err := repo.db.WithContext(ctx).Raw(searchQuery,
+		highlightOpen, highlightClose,
+		highlightOpen, highlightClose,
+		match, limit,
+	).Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to search users: %w", err)
+	}
+
+	results := make([]*model.UserSearchResult, 0, len(rows))
+	for i := range rows {
+		results = append(results, &model.UserSearchResult{
+			User:         &rows[i].User,
+			Score:        rows[i].Score,
+			NameSnippet:  rows[i].NameSnippet,
+			EmailSnippet: rows[i].EmailSnippet,
+		})
+	}
+	return results, nil
+}
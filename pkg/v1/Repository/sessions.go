@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+)
+
+func (repo *Repo) CreateSession(ctx context.Context, session *model.Session) error {
+	if err := repo.db.WithContext(ctx).Create(session).Error; err != nil {
+		return fmt.Errorf("unable to create session: %w", err)
+	}
+	return nil
+}
+
+func (repo *Repo) GetSession(ctx context.Context, id string) (*model.Session, error) {
+	var session model.Session
+	if err := repo.db.WithContext(ctx).Where("id = ?", id).First(&session).Error; err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	return &session, nil
+}
+
+// ListSessions returns the sessions of user userID that have not expired by
+// now, most recently used first
+func (repo *Repo) ListSessions(ctx context.Context, userID uint, now time.Time) ([]*model.Session, error) {
+	var sessions []*model.Session
+	err := repo.db.WithContext(ctx).
+		Where("user_id = ? AND expires_at > ?", userID, now).
+		Order("last_used_at DESC").Order("id").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// RotateSession swaps the refresh token hash of session id from oldHash to
+// newHash, remembering oldHash as the previous one, and moves its expiry. it
+// reports false without changing anything when the stored hash is no longer
+// oldHash, i.e. someone else rotated first
+func (repo *Repo) RotateSession(ctx context.Context, id, oldHash, newHash string, usedAt, expiresAt time.Time) (bool, error) {
+	result := repo.db.WithContext(ctx).Model(&model.Session{}).
+		Where("id = ? AND token_hash = ?", id, oldHash).
+		Updates(map[string]any{"token_hash": newHash, "previous_token_hash": oldHash, "last_used_at": usedAt, "expires_at": expiresAt})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to rotate session: %w", result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (repo *Repo) DeleteSession(ctx context.Context, id string) error {
+	if err := repo.db.WithContext(ctx).Where("id = ?", id).Delete(&model.Session{}).Error; err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpiredSessions removes every session that expired before now and
+// returns how many there were
+func (repo *Repo) DeleteExpiredSessions(ctx context.Context, now time.Time) (int64, error) {
+	result := repo.db.WithContext(ctx).Where("expires_at <= ?", now).Delete(&model.Session{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to delete expired sessions: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
@@ -1,10 +1,16 @@
 package repository_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	database "github.com/yishak-cs/CleanGrpc/Internal/db"
+	"github.com/yishak-cs/CleanGrpc/Internal/errs"
 	"github.com/yishak-cs/CleanGrpc/Internal/model"
 	Repo "github.com/yishak-cs/CleanGrpc/pkg/v1/Repository"
 	"gorm.io/driver/sqlite"
@@ -19,10 +25,10 @@ func setupTestDB(t *testing.T) *gorm.DB {
 	}
 
 	// if a table exists from a previous run drop it
-	db.Migrator().DropTable(&model.User{})
+	db.Migrator().DropTable(database.SearchTable, &model.User{}, &model.Session{}, &model.EmailToken{}, &model.MagicLinkToken{})
 
 	// migrate the schema
-	err = db.AutoMigrate(&model.User{})
+	err = database.Migrate(db)
 	if err != nil {
 		t.Fatalf("Failed to migrate test database: %v", err)
 	}
@@ -36,6 +42,7 @@ func setupTestDB(t *testing.T) *gorm.DB {
 func TestRepository_CreateUser(t *testing.T) {
 	db := setupTestDB(t)
 	repo := Repo.NewRepo(db)
+	ctx := context.Background()
 
 	// create a new user
 	user := &model.User{
@@ -43,7 +50,7 @@ func TestRepository_CreateUser(t *testing.T) {
 		Email: "test@example.com",
 	}
 
-	createdUser, err := repo.CreateUser(user)
+	createdUser, err := repo.CreateUser(ctx, user)
 	assert.NoError(t, err)
 	assert.NotZero(t, createdUser.ID)
 	assert.Equal(t, user.Name, createdUser.Name)
@@ -53,50 +60,82 @@ func TestRepository_CreateUser(t *testing.T) {
 func TestRepository_GetUser(t *testing.T) {
 	db := setupTestDB(t)
 	repo := Repo.NewRepo(db)
+	ctx := context.Background()
 
 	// Create user
 	user := &model.User{
 		Name:  "Test User",
 		Email: "test@example.com",
 	}
-	createdUser, err := repo.CreateUser(user)
+	createdUser, err := repo.CreateUser(ctx, user)
 	assert.NoError(t, err)
 
 	//get user by Id
 	id := createdUser.ID
-	fetchedUser, err := repo.GetUser(fmt.Sprintf("%d", id))
+	fetchedUser, err := repo.GetUser(ctx, fmt.Sprintf("%d", id))
 	assert.NoError(t, err)
 	assert.Equal(t, createdUser.ID, fetchedUser.ID)
 	assert.Equal(t, createdUser.Name, fetchedUser.Name)
 	assert.Equal(t, createdUser.Email, fetchedUser.Email)
 
 	// Test case: Get non-existent user
-	_, err = repo.GetUser("999999")
+	_, err = repo.GetUser(ctx, "999999")
 	assert.Error(t, err)
 	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
 }
 
+func TestRepository_CancelledContext(t *testing.T) {
+	db := setupTestDB(t)
+	repo := Repo.NewRepo(db)
+
+	user, err := repo.CreateUser(context.Background(), &model.User{Name: "Test User", Email: "test@example.com"})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Test case: Every method gives up on a cancelled context
+	_, err = repo.CreateUser(ctx, &model.User{Name: "Other User", Email: "other@example.com"})
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = repo.GetUser(ctx, fmt.Sprint(user.ID))
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = repo.GetUserByEmail(ctx, user.Email)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = repo.GetUsersList(ctx, model.UserQuery{Limit: 10})
+	assert.ErrorIs(t, err, context.Canceled)
+	err = repo.StreamUsers(ctx, func(*model.User) error { return nil })
+	assert.ErrorIs(t, err, context.Canceled)
+	err = repo.DeleteUser(ctx, fmt.Sprint(user.ID))
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// Test case: Nothing was written
+	users, err := repo.GetUsersList(context.Background(), model.UserQuery{Limit: 10})
+	assert.NoError(t, err)
+	assert.Len(t, users, 1)
+}
+
 func TestRepository_GetUserByEmail(t *testing.T) {
 	db := setupTestDB(t)
 	repo := Repo.NewRepo(db)
+	ctx := context.Background()
 
 	// Create a test user first
 	user := &model.User{
 		Name:  "Test User",
 		Email: "test@example.com",
 	}
-	createdUser, err := repo.CreateUser(user)
+	createdUser, err := repo.CreateUser(ctx, user)
 	assert.NoError(t, err)
 
 	// Test case: Get user by email
-	fetchedUser, err := repo.GetUserByEmail(createdUser.Email)
+	fetchedUser, err := repo.GetUserByEmail(ctx, createdUser.Email)
 	assert.NoError(t, err)
 	assert.Equal(t, createdUser.ID, fetchedUser.ID)
 	assert.Equal(t, createdUser.Name, fetchedUser.Name)
 	assert.Equal(t, createdUser.Email, fetchedUser.Email)
 
 	// Test case: Get non-existent email
-	_, err = repo.GetUserByEmail("nonexistent@example.com")
+	_, err = repo.GetUserByEmail(ctx, "nonexistent@example.com")
 	assert.Error(t, err)
 	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
 }
@@ -104,6 +143,7 @@ func TestRepository_GetUserByEmail(t *testing.T) {
 func TestRepository_GetUsersList(t *testing.T) {
 	db := setupTestDB(t)
 	repo := Repo.NewRepo(db)
+	ctx := context.Background()
 
 	// Create multiple test users
 	users := []*model.User{
@@ -113,25 +153,271 @@ func TestRepository_GetUsersList(t *testing.T) {
 	}
 
 	for _, user := range users {
-		_, err := repo.CreateUser(user)
+		_, err := repo.CreateUser(ctx, user)
 		assert.NoError(t, err)
 	}
 
 	// Test case: Get all users
-	usersList := repo.GetUsersList()
+	usersList, err := repo.GetUsersList(ctx, model.UserQuery{Limit: 10})
+	assert.NoError(t, err)
 	assert.Len(t, usersList, len(users))
+
+	// Test case: Limit caps the number of rows
+	firstPage, err := repo.GetUsersList(ctx, model.UserQuery{Limit: 2})
+	assert.NoError(t, err)
+	assert.Len(t, firstPage, 2)
+	assert.Equal(t, users[0].ID, firstPage[0].ID)
+	assert.Equal(t, users[1].ID, firstPage[1].ID)
+
+	// Test case: AfterID seeks past the previous page
+	secondPage, err := repo.GetUsersList(ctx, model.UserQuery{After: &model.UserCursor{ID: firstPage[1].ID}, Limit: 2})
+	assert.NoError(t, err)
+	assert.Len(t, secondPage, 1)
+	assert.Equal(t, users[2].ID, secondPage[0].ID)
+}
+
+func TestRepository_GetUsersList_Filter(t *testing.T) {
+	db := setupTestDB(t)
+	repo := Repo.NewRepo(db)
+	ctx := context.Background()
+
+	users := []*model.User{
+		{Name: "Alice", Email: "alice@partner.com"},
+		{Name: "Bob", Email: "bob@example.com"},
+		{Name: "Alicia", Email: "alicia@example.com"},
+		{Name: "100%_real", Email: "real@partner.com"},
+	}
+	for _, user := range users {
+		_, err := repo.CreateUser(ctx, user)
+		assert.NoError(t, err)
+	}
+
+	// move the users apart in time so ranges are meaningful
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, user := range users {
+		created := base.AddDate(0, i, 0)
+		assert.NoError(t, db.Model(user).Update("created_at", created.Local()).Error)
+	}
+
+	names := func(conditions ...model.UserCondition) []string {
+		t.Helper()
+		list, err := repo.GetUsersList(ctx, model.UserQuery{Conditions: conditions, Limit: 10})
+		assert.NoError(t, err)
+		var result []string
+		for _, user := range list {
+			result = append(result, user.Name)
+		}
+		return result
+	}
+
+	// Test case: String operators
+	assert.Equal(t, []string{"Alice", "100%_real"}, names(model.UserCondition{Field: model.FieldEmail, Op: model.OpSuffix, Value: "@partner.com"}))
+	assert.Equal(t, []string{"Alice", "Alicia"}, names(model.UserCondition{Field: model.FieldName, Op: model.OpPrefix, Value: "Ali"}))
+	assert.Equal(t, []string{"Alice", "Alicia"}, names(model.UserCondition{Field: model.FieldName, Op: model.OpContains, Value: "lic"}))
+	assert.Equal(t, []string{"Bob"}, names(model.UserCondition{Field: model.FieldName, Op: model.OpEquals, Value: "Bob"}))
+
+	// Test case: LIKE wildcards in values match literally
+	assert.Equal(t, []string{"100%_real"}, names(model.UserCondition{Field: model.FieldName, Op: model.OpContains, Value: "%_"}))
+	assert.Empty(t, names(model.UserCondition{Field: model.FieldName, Op: model.OpPrefix, Value: "_"}))
+
+	// Test case: Conditions combine with AND, including created_at ranges
+	assert.Equal(t, []string{"Bob", "Alicia"}, names(
+		model.UserCondition{Field: model.FieldCreatedAt, Op: model.OpGreaterEqual, Time: base.AddDate(0, 1, 0)},
+		model.UserCondition{Field: model.FieldCreatedAt, Op: model.OpLess, Time: base.AddDate(0, 3, 0)},
+	))
+
+	// Test case: Injection attempts in values are plain data
+	for _, value := range []string{
+		`' OR '1'='1`,
+		`x' OR 1=1 --`,
+		`"; DROP TABLE users; --`,
+		`Bob' UNION SELECT * FROM users --`,
+	} {
+		for _, op := range []model.FilterOp{model.OpEquals, model.OpPrefix, model.OpSuffix, model.OpContains} {
+			assert.Empty(t, names(model.UserCondition{Field: model.FieldName, Op: op, Value: value}), value)
+		}
+	}
+	assert.True(t, db.Migrator().HasTable(&model.User{}))
+	assert.Len(t, names(), len(users))
+
+	// Test case: Fields and operators outside the whitelist are refused
+	_, err := repo.GetUsersList(ctx, model.UserQuery{
+		Conditions: []model.UserCondition{{Field: "name = name OR 1", Op: model.OpEquals, Value: "x"}},
+		Limit:      10,
+	})
+	assert.Error(t, err)
+	_, err = repo.GetUsersList(ctx, model.UserQuery{
+		Conditions: []model.UserCondition{{Field: model.FieldCreatedAt, Op: "OR 1=1 OR", Time: base}},
+		Limit:      10,
+	})
+	assert.Error(t, err)
+}
+
+func TestRepository_GetUsersList_OrderedKeyset(t *testing.T) {
+	db := setupTestDB(t)
+	repo := Repo.NewRepo(db)
+	ctx := context.Background()
+
+	// duplicate names make sure ties are broken by id
+	for _, name := range []string{"Carol", "Alice", "Bob", "Alice", "Dave"} {
+		_, err := repo.CreateUser(ctx, &model.User{Name: name, Email: strings.ToLower(name) + "@example.com"})
+		assert.NoError(t, err)
+	}
+
+	for _, order := range []model.UserOrder{
+		{Field: model.FieldName},
+		{Field: model.FieldName, Desc: true},
+		{Field: model.FieldCreatedAt, Desc: true},
+		{Field: model.FieldID, Desc: true},
+	} {
+		all, err := repo.GetUsersList(ctx, model.UserQuery{OrderBy: order, Limit: 10})
+		assert.NoError(t, err)
+		assert.Len(t, all, 5)
+
+		// walk the same listing two rows at a time using the keyset cursor
+		var walked []*model.User
+		var after *model.UserCursor
+		for {
+			page, err := repo.GetUsersList(ctx, model.UserQuery{OrderBy: order, After: after, Limit: 2})
+			assert.NoError(t, err)
+			if len(page) == 0 {
+				break
+			}
+			walked = append(walked, page...)
+			last := page[len(page)-1]
+			after = &model.UserCursor{ID: last.ID}
+			switch order.Field {
+			case model.FieldName:
+				after.Value = last.Name
+			case model.FieldCreatedAt:
+				after.Value = last.CreatedAt
+			}
+		}
+		assert.Equal(t, all, walked, order)
+	}
+
+	// Test case: Ascending by name with ties broken by id
+	all, err := repo.GetUsersList(ctx, model.UserQuery{OrderBy: model.UserOrder{Field: model.FieldName}, Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, "Alice", all[0].Name)
+	assert.Equal(t, "Alice", all[1].Name)
+	assert.Less(t, all[0].ID, all[1].ID)
+	assert.Equal(t, "Dave", all[4].Name)
+}
+
+func TestRepository_SearchUsers(t *testing.T) {
+	db := setupTestDB(t)
+	repo := Repo.NewRepo(db)
+	ctx := context.Background()
+
+	if !db.Migrator().HasTable(database.SearchTable) {
+		// Test case: Builds without FTS5 report search as unavailable
+		_, err := repo.SearchUsers(ctx, `"ali"*`, 10)
+		assert.Equal(t, errs.FailedPrecondition, errs.CodeOf(err))
+		t.Skip("sqlite built without FTS5, run with -tags sqlite_fts5")
+	}
+
+	users := []*model.User{
+		{Name: "Alice Smith", Email: "alice@partner.com"},
+		{Name: "Bob Alison", Email: "bob@example.com"},
+		{Name: "Carol", Email: "carol@alimail.com"},
+		{Name: "Dave", Email: "dave@example.com"},
+	}
+	for _, user := range users {
+		_, err := repo.CreateUser(ctx, user)
+		assert.NoError(t, err)
+	}
+
+	// Test case: Prefix matches on name outrank matches on email
+	results, err := repo.SearchUsers(ctx, `"ali"*`, 10)
+	assert.NoError(t, err)
+	assert.Len(t, results, 3)
+	assert.Equal(t, "Carol", results[2].User.Name)
+	for i := 1; i < len(results); i++ {
+		assert.GreaterOrEqual(t, results[i-1].Score, results[i].Score)
+	}
+	assert.Equal(t, "<mark>Alice</mark> Smith", results[0].NameSnippet)
+	assert.Equal(t, "carol@<mark>alimail</mark>.com", results[2].EmailSnippet)
+
+	// Test case: Limit caps the number of hits
+	results, err = repo.SearchUsers(ctx, `"ali"*`, 1)
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+
+	// Test case: Updates are reflected in the index
+	assert.NoError(t, repo.UpdateUser(ctx, &model.User{Model: gorm.Model{ID: users[3].ID}, Name: "Alistair", Email: "dave@example.com"}, nil))
+	results, err = repo.SearchUsers(ctx, `"alistair"`, 10)
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	results, err = repo.SearchUsers(ctx, `"dave"`, 10)
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, "Alistair", results[0].User.Name)
+
+	// Test case: Soft deleted users are hidden and hard deleted ones leave the index
+	assert.NoError(t, repo.DeleteUser(ctx, fmt.Sprint(users[0].ID)))
+	assert.NoError(t, db.Unscoped().Delete(&model.User{}, users[1].ID).Error)
+	results, err = repo.SearchUsers(ctx, `"smith" OR "alison"`, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+
+	// Test case: Rows written before the index existed are picked up on migration
+	assert.NoError(t, db.Migrator().DropTable(database.SearchTable))
+	assert.NoError(t, database.Migrate(db))
+	results, err = repo.SearchUsers(ctx, `"carol"`, 10)
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+}
+
+func TestRepository_StreamUsers(t *testing.T) {
+	db := setupTestDB(t)
+	repo := Repo.NewRepo(db)
+	ctx := context.Background()
+
+	// Create more users than fit in a single batch
+	for i := 0; i < 1200; i++ {
+		_, err := repo.CreateUser(ctx, &model.User{
+			Name:  fmt.Sprintf("User %d", i),
+			Email: fmt.Sprintf("user%d@example.com", i),
+		})
+		assert.NoError(t, err)
+	}
+
+	// Test case: Every user is visited once, in ID order
+	var ids []uint
+	err := repo.StreamUsers(ctx, func(user *model.User) error {
+		ids = append(ids, user.ID)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Len(t, ids, 1200)
+	assert.IsIncreasing(t, ids)
+
+	// Test case: An error from the callback stops the walk
+	stop := errors.New("stop")
+	visited := 0
+	err = repo.StreamUsers(ctx, func(user *model.User) error {
+		visited++
+		if visited == 10 {
+			return stop
+		}
+		return nil
+	})
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 10, visited)
 }
 
 func TestRepository_UpdateUser(t *testing.T) {
 	db := setupTestDB(t)
 	repo := Repo.NewRepo(db)
+	ctx := context.Background()
 
 	// Create a test user first
 	user := &model.User{
 		Name:  "Test User",
 		Email: "test@example.com",
 	}
-	createdUser, err := repo.CreateUser(user)
+	createdUser, err := repo.CreateUser(ctx, user)
 	assert.NoError(t, err)
 
 	// Test case: Update user
@@ -141,34 +427,375 @@ func TestRepository_UpdateUser(t *testing.T) {
 		Email: "updated@example.com",
 	}
 
-	err = repo.UpdateUser(updatedUser)
+	err = repo.UpdateUser(ctx, updatedUser, nil)
 	assert.NoError(t, err)
 
 	// Verify the update
-	fetchedUser, err := repo.GetUser(fmt.Sprint(createdUser.ID))
+	fetchedUser, err := repo.GetUser(ctx, fmt.Sprint(createdUser.ID))
 	assert.NoError(t, err)
 	assert.Equal(t, updatedUser.Name, fetchedUser.Name)
 	assert.Equal(t, updatedUser.Email, fetchedUser.Email)
+	assert.Equal(t, uint64(2), fetchedUser.Version)
+
+	// Test case: An update naming the current version applies and bumps it
+	err = repo.UpdateUser(ctx, &model.User{Model: gorm.Model{ID: createdUser.ID}, Name: "Second", Email: "updated@example.com", Version: 2}, nil)
+	assert.NoError(t, err)
+
+	// Test case: An update naming an older version changes nothing
+	err = repo.UpdateUser(ctx, &model.User{Model: gorm.Model{ID: createdUser.ID}, Name: "Stale", Email: "stale@example.com", Version: 2}, nil)
+	assert.ErrorIs(t, err, model.ErrVersionConflict)
+	fetchedUser, _ = repo.GetUser(ctx, fmt.Sprint(createdUser.ID))
+	assert.Equal(t, "Second", fetchedUser.Name)
+	assert.Equal(t, uint64(3), fetchedUser.Version)
+
+	// Test case: A missing user is not found rather than a conflict
+	err = repo.UpdateUser(ctx, &model.User{Model: gorm.Model{ID: 999}, Name: "Ghost", Email: "ghost@example.com", Version: 1}, nil)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	// Test case: Only the listed fields are written
+	err = repo.UpdateUser(ctx, &model.User{Model: gorm.Model{ID: createdUser.ID}, Name: "Third"}, []string{model.UserFieldName})
+	assert.NoError(t, err)
+	fetchedUser, _ = repo.GetUser(ctx, fmt.Sprint(createdUser.ID))
+	assert.Equal(t, "Third", fetchedUser.Name)
+	assert.Equal(t, "updated@example.com", fetchedUser.Email)
+	assert.Equal(t, uint64(4), fetchedUser.Version)
+
+	// Test case: An unknown field is refused
+	err = repo.UpdateUser(ctx, &model.User{Model: gorm.Model{ID: createdUser.ID}}, []string{"password_hash"})
+	assert.Error(t, err)
 }
 
 func TestRepository_DeleteUser(t *testing.T) {
 	db := setupTestDB(t)
 	repo := Repo.NewRepo(db)
+	ctx := context.Background()
 
 	// Create a test user first
 	user := &model.User{
 		Name:  "Test User",
 		Email: "test@example.com",
 	}
-	createdUser, err := repo.CreateUser(user)
+	createdUser, err := repo.CreateUser(ctx, user)
 	assert.NoError(t, err)
 
 	// Test case: Delete user
-	err = repo.DeleteUser(fmt.Sprint(createdUser.ID))
+	err = repo.DeleteUser(ctx, fmt.Sprint(createdUser.ID))
 	assert.NoError(t, err)
 
 	// Verify the deletion
-	_, err = repo.GetUser(fmt.Sprint(createdUser.ID))
+	_, err = repo.GetUser(ctx, fmt.Sprint(createdUser.ID))
 	assert.Error(t, err)
 	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
 }
+
+func TestRepository_DeletedUsers(t *testing.T) {
+	db := setupTestDB(t)
+	repo := Repo.NewRepo(db)
+	ctx := context.Background()
+	now := time.Now()
+
+	var users []*model.User
+	for i := 0; i < 3; i++ {
+		users = append(users, &model.User{Name: fmt.Sprintf("User %d", i), Email: fmt.Sprintf("user%d@example.com", i)})
+	}
+	assert.NoError(t, repo.CreateUsers(ctx, users))
+	assert.NoError(t, repo.DeleteUsers(ctx, []uint{users[1].ID, users[2].ID}))
+	// users[2] was deleted long ago
+	assert.NoError(t, db.Unscoped().Model(&model.User{}).Where("id = ?", users[2].ID).Update("deleted_at", now.Add(-48*time.Hour)).Error)
+	for _, user := range users {
+		assert.NoError(t, repo.CreateSession(ctx, &model.Session{ID: fmt.Sprint(user.ID), UserID: user.ID, TokenHash: "hash", ExpiresAt: now.Add(time.Hour)}))
+	}
+
+	// Test case: Deleted users are only listed when asked for
+	listed, err := repo.GetUsersList(ctx, model.UserQuery{Limit: 10})
+	assert.NoError(t, err)
+	assert.Len(t, listed, 1)
+	listed, err = repo.GetUsersList(ctx, model.UserQuery{Limit: 10, IncludeDeleted: true})
+	assert.NoError(t, err)
+	if assert.Len(t, listed, 3) {
+		assert.True(t, listed[1].DeletedAt.Valid)
+	}
+	fetched, err := repo.GetUserIncludingDeleted(ctx, fmt.Sprint(users[1].ID))
+	assert.NoError(t, err)
+	assert.True(t, fetched.DeletedAt.Valid)
+
+	// Test case: Only a deleted user can be restored, it gets a new version
+	assert.ErrorIs(t, repo.RestoreUser(ctx, users[0].ID), gorm.ErrRecordNotFound)
+	assert.NoError(t, repo.RestoreUser(ctx, users[1].ID))
+	fetched, err = repo.GetUser(ctx, fmt.Sprint(users[1].ID))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), fetched.Version)
+
+	// Test case: Only a deleted user can be purged, its sessions and magic links go with it
+	assert.NoError(t, repo.ReplaceMagicLinkToken(ctx, &model.MagicLinkToken{Hash: "link", UserID: users[1].ID, Email: "b@example.com", ExpiresAt: now.Add(time.Hour)}))
+	assert.ErrorIs(t, repo.PurgeUser(ctx, users[1].ID), gorm.ErrRecordNotFound)
+	assert.NoError(t, repo.DeleteUser(ctx, fmt.Sprint(users[1].ID)))
+	assert.NoError(t, repo.PurgeUser(ctx, users[1].ID))
+	_, err = repo.GetUserIncludingDeleted(ctx, fmt.Sprint(users[1].ID))
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	_, err = repo.GetSession(ctx, fmt.Sprint(users[1].ID))
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	_, err = repo.ConsumeMagicLinkToken(ctx, "link")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	// Test case: Purging by age leaves recently deleted and live users alone
+	assert.NoError(t, repo.DeleteUser(ctx, fmt.Sprint(users[0].ID)))
+	purged, err := repo.PurgeDeletedUsers(ctx, now.Add(-24*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	_, err = repo.GetUserIncludingDeleted(ctx, fmt.Sprint(users[2].ID))
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	_, err = repo.GetSession(ctx, fmt.Sprint(users[2].ID))
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	_, err = repo.GetUserIncludingDeleted(ctx, fmt.Sprint(users[0].ID))
+	assert.NoError(t, err)
+	_, err = repo.GetSession(ctx, fmt.Sprint(users[0].ID))
+	assert.NoError(t, err)
+}
+
+func TestRepository_Batch(t *testing.T) {
+	db := setupTestDB(t)
+	repo := Repo.NewRepo(db)
+	ctx := context.Background()
+
+	// Test case: Create many users at once, more than one insert statement's worth
+	var users []*model.User
+	for i := 0; i < 250; i++ {
+		users = append(users, &model.User{Name: fmt.Sprintf("User %d", i), Email: fmt.Sprintf("user%d@example.com", i)})
+	}
+	assert.NoError(t, repo.CreateUsers(ctx, users))
+	assert.NotZero(t, users[0].ID)
+	assert.NotZero(t, users[249].ID)
+
+	// Test case: Look users up by id and by email, unknown ones are left out
+	found, err := repo.GetUsersByIDs(ctx, []uint{users[0].ID, users[1].ID, 9999})
+	assert.NoError(t, err)
+	assert.Len(t, found, 2)
+	found, err = repo.GetUsersByEmails(ctx, []string{"user2@example.com", "nobody@example.com"})
+	assert.NoError(t, err)
+	if assert.Len(t, found, 1) {
+		assert.Equal(t, users[2].ID, found[0].ID)
+	}
+
+	// Test case: Updates apply together
+	err = repo.UpdateUsers(ctx, []model.UserUpdate{
+		{User: &model.User{Model: gorm.Model{ID: users[0].ID}, Name: "First"}, Fields: []string{model.UserFieldName}},
+		{User: &model.User{Model: gorm.Model{ID: users[1].ID}, Name: "Second", Email: "second@example.com", Version: 1}},
+	})
+	assert.NoError(t, err)
+	fetched, _ := repo.GetUser(ctx, fmt.Sprint(users[1].ID))
+	assert.Equal(t, "second@example.com", fetched.Email)
+	assert.Equal(t, uint64(2), fetched.Version)
+
+	// Test case: One stale update rolls back the others and is named
+	err = repo.UpdateUsers(ctx, []model.UserUpdate{
+		{User: &model.User{Model: gorm.Model{ID: users[0].ID}, Name: "Rolled back"}, Fields: []string{model.UserFieldName}},
+		{User: &model.User{Model: gorm.Model{ID: users[1].ID}, Name: "Stale", Version: 1}, Fields: []string{model.UserFieldName}},
+	})
+	var itemErr *model.BatchItemError
+	if assert.ErrorAs(t, err, &itemErr) {
+		assert.Equal(t, 1, itemErr.Index)
+	}
+	assert.ErrorIs(t, err, model.ErrVersionConflict)
+	fetched, _ = repo.GetUser(ctx, fmt.Sprint(users[0].ID))
+	assert.Equal(t, "First", fetched.Name)
+
+	// Test case: Delete many users in one go
+	assert.NoError(t, repo.DeleteUsers(ctx, []uint{users[0].ID, users[1].ID}))
+	found, err = repo.GetUsersByIDs(ctx, []uint{users[0].ID, users[1].ID, users[2].ID})
+	assert.NoError(t, err)
+	assert.Len(t, found, 1)
+}
+
+func TestRepository_Credentials(t *testing.T) {
+	db := setupTestDB(t)
+	repo := Repo.NewRepo(db)
+	ctx := context.Background()
+
+	user, err := repo.CreateUser(ctx, &model.User{Name: "Test User", Email: "test@example.com"})
+	assert.NoError(t, err)
+	id := fmt.Sprintf("%d", user.ID)
+
+	// Test case: Failures are counted one by one
+	for want := 1; want <= 3; want++ {
+		failures, err := repo.RecordLoginFailure(ctx, user.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, want, failures)
+	}
+
+	// Test case: A lock stores its end and resets the count
+	until := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	assert.NoError(t, repo.SetLoginLock(ctx, user.ID, 0, &until))
+	fetched, _ := repo.GetUser(ctx, id)
+	assert.Equal(t, 0, fetched.FailedLogins)
+	if assert.NotNil(t, fetched.LockedUntil) {
+		assert.True(t, until.Equal(*fetched.LockedUntil))
+	}
+
+	// Test case: A new password clears the lock
+	_, _ = repo.RecordLoginFailure(ctx, user.ID)
+	assert.NoError(t, repo.SetPasswordHash(ctx, user.ID, "$argon2id$hash"))
+	fetched, _ = repo.GetUser(ctx, id)
+	assert.Equal(t, "$argon2id$hash", fetched.PasswordHash)
+	assert.Equal(t, 0, fetched.FailedLogins)
+	assert.Nil(t, fetched.LockedUntil)
+
+	// Test case: Profile updates keep the password
+	assert.NoError(t, repo.UpdateUser(ctx, &model.User{Model: gorm.Model{ID: user.ID}, Name: "Renamed", Email: "test@example.com"}, nil))
+	fetched, _ = repo.GetUser(ctx, id)
+	assert.Equal(t, "$argon2id$hash", fetched.PasswordHash)
+}
+
+func TestRepository_Sessions(t *testing.T) {
+	db := setupTestDB(t)
+	repo := Repo.NewRepo(db)
+	ctx := context.Background()
+	now := time.Now()
+
+	newSession := func(id string, userID uint, lastUsed, expires time.Time) *model.Session {
+		session := &model.Session{ID: id, UserID: userID, TokenHash: "hash-" + id, CreatedAt: now, LastUsedAt: lastUsed, ExpiresAt: expires}
+		assert.NoError(t, repo.CreateSession(ctx, session))
+		return session
+	}
+	newSession("a", 1, now.Add(-2*time.Hour), now.Add(time.Hour))
+	newSession("b", 1, now.Add(-time.Hour), now.Add(time.Hour))
+	newSession("expired", 1, now.Add(-3*time.Hour), now.Add(-time.Minute))
+	newSession("other", 2, now, now.Add(time.Hour))
+
+	// Test case: Live sessions of a user come most recently used first
+	sessions, err := repo.ListSessions(ctx, 1, now)
+	assert.NoError(t, err)
+	var ids []string
+	for _, session := range sessions {
+		ids = append(ids, session.ID)
+	}
+	assert.Equal(t, []string{"b", "a"}, ids)
+
+	// Test case: Rotation only succeeds from the current hash
+	rotated, err := repo.RotateSession(ctx, "a", "hash-a", "hash-a2", now, now.Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.True(t, rotated)
+	rotated, err = repo.RotateSession(ctx, "a", "hash-a", "hash-a3", now, now.Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.False(t, rotated)
+	session, err := repo.GetSession(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, "hash-a2", session.TokenHash)
+	assert.Equal(t, "hash-a", session.PreviousTokenHash)
+	assert.WithinDuration(t, now.Add(2*time.Hour), session.ExpiresAt, time.Second)
+
+	// Test case: Sweeping deletes only expired sessions
+	deleted, err := repo.DeleteExpiredSessions(ctx, now)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+	_, err = repo.GetSession(ctx, "expired")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	// Test case: A deleted session is gone
+	assert.NoError(t, repo.DeleteSession(ctx, "b"))
+	_, err = repo.GetSession(ctx, "b")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
+
+func TestRepository_EmailTokens(t *testing.T) {
+	db := setupTestDB(t)
+	repo := Repo.NewRepo(db)
+	ctx := context.Background()
+	now := time.Now()
+
+	newToken := func(hash string, userID uint, expires time.Time) *model.EmailToken {
+		return &model.EmailToken{Hash: hash, UserID: userID, Purpose: model.TokenPurposeVerifyEmail, Email: "a@example.com", ExpiresAt: expires}
+	}
+
+	// Test case: A new token replaces the earlier one of the same user
+	assert.NoError(t, repo.ReplaceEmailToken(ctx, newToken("old", 1, now.Add(time.Hour))))
+	assert.NoError(t, repo.ReplaceEmailToken(ctx, newToken("new", 1, now.Add(time.Hour))))
+	assert.NoError(t, repo.ReplaceEmailToken(ctx, newToken("other", 2, now.Add(time.Hour))))
+	_, err := repo.ConsumeEmailToken(ctx, "old", model.TokenPurposeVerifyEmail)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	// Test case: A token is only redeemed for its purpose, and only once
+	_, err = repo.ConsumeEmailToken(ctx, "new", "something_else")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	token, err := repo.ConsumeEmailToken(ctx, "new", model.TokenPurposeVerifyEmail)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1), token.UserID)
+	assert.Equal(t, "a@example.com", token.Email)
+	_, err = repo.ConsumeEmailToken(ctx, "new", model.TokenPurposeVerifyEmail)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	// Test case: Expired tokens are swept, live ones stay
+	assert.NoError(t, repo.ReplaceEmailToken(ctx, newToken("expired", 3, now.Add(-time.Minute))))
+	deleted, err := repo.DeleteExpiredEmailTokens(ctx, now)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+	_, err = repo.ConsumeEmailToken(ctx, "other", model.TokenPurposeVerifyEmail)
+	assert.NoError(t, err)
+}
+
+func TestRepository_MagicLinkTokens(t *testing.T) {
+	db := setupTestDB(t)
+	repo := Repo.NewRepo(db)
+	ctx := context.Background()
+	now := time.Now()
+
+	newLink := func(hash string, userID uint, expires time.Time) *model.MagicLinkToken {
+		return &model.MagicLinkToken{Hash: hash, UserID: userID, Email: "a@example.com", ExpiresAt: expires}
+	}
+
+	// Test case: A new link replaces the earlier one of the same user, not its verification token
+	assert.NoError(t, repo.ReplaceEmailToken(ctx, &model.EmailToken{Hash: "verify", UserID: 1, Purpose: model.TokenPurposeVerifyEmail, Email: "a@example.com", ExpiresAt: now.Add(time.Hour)}))
+	assert.NoError(t, repo.ReplaceMagicLinkToken(ctx, newLink("old", 1, now.Add(time.Hour))))
+	assert.NoError(t, repo.ReplaceMagicLinkToken(ctx, newLink("new", 1, now.Add(time.Hour))))
+	_, err := repo.ConsumeMagicLinkToken(ctx, "old")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	// Test case: A verification token is not a magic link and a magic link is no verification token
+	_, err = repo.ConsumeMagicLinkToken(ctx, "verify")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	_, err = repo.ConsumeEmailToken(ctx, "new", model.TokenPurposeVerifyEmail)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	// Test case: A link is redeemed only once
+	link, err := repo.ConsumeMagicLinkToken(ctx, "new")
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1), link.UserID)
+	assert.Equal(t, "a@example.com", link.Email)
+	_, err = repo.ConsumeMagicLinkToken(ctx, "new")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	// Test case: Expired links are swept, live ones and email tokens stay
+	assert.NoError(t, repo.ReplaceMagicLinkToken(ctx, newLink("expired", 2, now.Add(-time.Minute))))
+	assert.NoError(t, repo.ReplaceMagicLinkToken(ctx, newLink("live", 3, now.Add(time.Hour))))
+	deleted, err := repo.DeleteExpiredMagicLinkTokens(ctx, now)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+	_, err = repo.ConsumeMagicLinkToken(ctx, "live")
+	assert.NoError(t, err)
+	_, err = repo.ConsumeEmailToken(ctx, "verify", model.TokenPurposeVerifyEmail)
+	assert.NoError(t, err)
+}
+
+func TestRepository_UserStatus(t *testing.T) {
+	db := setupTestDB(t)
+	repo := Repo.NewRepo(db)
+	ctx := context.Background()
+
+	// Test case: Users are active unless created otherwise
+	active, err := repo.CreateUser(ctx, &model.User{Name: "A", Email: "a@example.com"})
+	assert.NoError(t, err)
+	pending, err := repo.CreateUser(ctx, &model.User{Name: "P", Email: "p@example.com", Status: model.UserStatusPending})
+	assert.NoError(t, err)
+	got, _ := repo.GetUser(ctx, fmt.Sprintf("%d", active.ID))
+	assert.Equal(t, model.UserStatusActive, got.Status)
+
+	// Test case: Updates keep the status unless they carry one
+	assert.NoError(t, repo.UpdateUser(ctx, &model.User{Model: gorm.Model{ID: pending.ID}, Name: "P2", Email: "p@example.com"}, nil))
+	got, _ = repo.GetUser(ctx, fmt.Sprintf("%d", pending.ID))
+	assert.Equal(t, model.UserStatusPending, got.Status)
+
+	assert.NoError(t, repo.SetUserStatus(ctx, pending.ID, model.UserStatusActive))
+	got, _ = repo.GetUser(ctx, fmt.Sprintf("%d", pending.ID))
+	assert.Equal(t, model.UserStatusActive, got.Status)
+	assert.Equal(t, uint64(3), got.Version)
+}
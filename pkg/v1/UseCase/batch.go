@@ -0,0 +1,332 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+	"go.opentelemetry.io/otel/attribute"
+	"gorm.io/gorm"
+)
+
+// MaxBatchSize caps the items of a single batch call
+const MaxBatchSize = 1000
+
+// BatchCreateUsers creates users the way CreateUser does, checking the whole
+// batch for taken emails in one query. an email used twice within the batch
+// is taken for every item after the first
+func (uc *UseCase) BatchCreateUsers(ctx context.Context, users []*model.User, mode model.BatchMode) (_ []model.BatchResult, err error) {
+	ctx, span := startSpan(ctx, "BatchCreateUsers")
+	defer func() { endSpan(span, err) }()
+
+	if len(users) > MaxBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+	span.SetAttributes(attribute.Int("batch.size", len(users)))
+
+	results := make([]model.BatchResult, len(users))
+	var emails []string
+	for i, user := range users {
+		results[i].Err = validateUser(user)
+		if results[i].Err == nil {
+			emails = append(emails, user.Email)
+		}
+	}
+	taken, err := uc.takenEmails(ctx, emails)
+	if err != nil {
+		return nil, err
+	}
+	for i, user := range users {
+		if results[i].Err != nil {
+			continue
+		}
+		if taken[user.Email] {
+			results[i].Err = ErrEmailTaken
+			continue
+		}
+		taken[user.Email] = true
+	}
+	if !settleBatch(results, mode) {
+		return results, nil
+	}
+
+	var create []*model.User
+	for i, user := range users {
+		if results[i].Err != nil {
+			continue
+		}
+		// the address has to be proven before the account can be used
+		if uc.mailer != nil {
+			user.Status = model.UserStatusPending
+		}
+		create = append(create, user)
+	}
+	if err := uc.repo.CreateUsers(ctx, create); err != nil {
+		return nil, err
+	}
+	for i, user := range users {
+		if results[i].Err == nil {
+			results[i].User = user
+			uc.sendVerificationOrLog(ctx, user)
+		}
+	}
+	return results, nil
+}
+
+// BatchUpdateUsers applies updates the way UpdateUser does. existence,
+// versions and taken emails are checked for the whole batch up front, an all
+// or nothing batch then writes in a single transaction while a best effort
+// one writes every remaining update on its own
+func (uc *UseCase) BatchUpdateUsers(ctx context.Context, updates []model.UserUpdate, mode model.BatchMode) (_ []model.BatchResult, err error) {
+	ctx, span := startSpan(ctx, "BatchUpdateUsers")
+	defer func() { endSpan(span, err) }()
+
+	if len(updates) > MaxBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+	span.SetAttributes(attribute.Int("batch.size", len(updates)))
+
+	results := make([]model.BatchResult, len(updates))
+	var ids []uint
+	for i := range updates {
+		update := &updates[i]
+		results[i].Err = checkUpdate(ctx, update, ids)
+		if results[i].Err == nil {
+			ids = append(ids, update.User.ID)
+		}
+	}
+	current, err := uc.usersByID(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	// only emails that actually change need to be free
+	var emails []string
+	checkEmail := make([]bool, len(updates))
+	for i, update := range updates {
+		if results[i].Err != nil {
+			continue
+		}
+		user, ok := current[update.User.ID]
+		switch {
+		case !ok:
+			results[i].Err = userLookupError(gorm.ErrRecordNotFound)
+		case update.User.Version != 0 && update.User.Version != user.Version:
+			results[i].Err = ErrVersionConflict
+		case slices.Contains(update.Fields, model.UserFieldEmail) && update.User.Email != user.Email:
+			checkEmail[i] = true
+			emails = append(emails, update.User.Email)
+		}
+	}
+	taken, err := uc.takenEmails(ctx, emails)
+	if err != nil {
+		return nil, err
+	}
+	for i, update := range updates {
+		if !checkEmail[i] {
+			continue
+		}
+		if taken[update.User.Email] {
+			results[i].Err = ErrEmailTaken
+			checkEmail[i] = false
+			continue
+		}
+		taken[update.User.Email] = true
+		// a new address has to be proven again
+		if uc.mailer != nil {
+			update.User.Status = model.UserStatusPending
+		}
+	}
+	if !settleBatch(results, mode) {
+		return results, nil
+	}
+
+	if mode == model.BatchAllOrNothing {
+		var itemErr *model.BatchItemError
+		err := uc.repo.UpdateUsers(ctx, updates)
+		if errors.As(err, &itemErr) && isUpdateConflict(itemErr.Err) {
+			// lost a race after the checks above, nothing was written
+			for i := range results {
+				results[i].Err = ErrBatchAborted
+			}
+			results[itemErr.Index].Err = updateError(itemErr.Err)
+			return results, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		for i, update := range updates {
+			if results[i].Err != nil {
+				continue
+			}
+			err := uc.repo.UpdateUser(ctx, update.User, update.Fields)
+			if err != nil && !isUpdateConflict(err) {
+				return nil, err
+			}
+			results[i].Err = updateError(err)
+		}
+	}
+
+	// report the users as stored, including their new versions
+	ids = ids[:0]
+	for i, update := range updates {
+		if results[i].Err == nil {
+			ids = append(ids, update.User.ID)
+		}
+	}
+	updated, err := uc.usersByID(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i, update := range updates {
+		if results[i].Err != nil {
+			continue
+		}
+		results[i].User = updated[update.User.ID]
+		if checkEmail[i] {
+			uc.sendVerificationOrLog(ctx, update.User)
+		}
+	}
+	return results, nil
+}
+
+// BatchDeleteUsers deletes the users ids in a single statement once all of
+// them, or with BatchBestEffort those that passed, are known to exist
+func (uc *UseCase) BatchDeleteUsers(ctx context.Context, ids []string, mode model.BatchMode) (_ []model.BatchResult, err error) {
+	ctx, span := startSpan(ctx, "BatchDeleteUsers")
+	defer func() { endSpan(span, err) }()
+
+	if len(ids) > MaxBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+	span.SetAttributes(attribute.Int("batch.size", len(ids)))
+
+	results := make([]model.BatchResult, len(ids))
+	keys := make([]uint, len(ids))
+	var lookup []uint
+	for i, id := range ids {
+		if results[i].Err = validateUserID(id); results[i].Err != nil {
+			continue
+		}
+		if results[i].Err = authorizeOwner(ctx, id); results[i].Err != nil {
+			continue
+		}
+		n, _ := strconv.ParseUint(id, 10, 64)
+		keys[i] = uint(n)
+		if slices.Contains(lookup, keys[i]) {
+			results[i].Err = ErrDuplicateBatchItem
+			continue
+		}
+		lookup = append(lookup, keys[i])
+	}
+	existing, err := uc.usersByID(ctx, lookup)
+	if err != nil {
+		return nil, err
+	}
+	var remove []uint
+	for i := range ids {
+		if results[i].Err != nil {
+			continue
+		}
+		if _, ok := existing[keys[i]]; !ok {
+			results[i].Err = userLookupError(gorm.ErrRecordNotFound)
+			continue
+		}
+		remove = append(remove, keys[i])
+	}
+	if !settleBatch(results, mode) {
+		return results, nil
+	}
+
+	if err := uc.repo.DeleteUsers(ctx, remove); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// checkUpdate runs the checks of UpdateUser that need no database on one
+// item of a batch and resolves its field list. seen holds the ids of the
+// items before it that passed
+func checkUpdate(ctx context.Context, update *model.UserUpdate, seen []uint) error {
+	id := strconv.FormatUint(uint64(update.User.ID), 10)
+	if err := validateUserID(id); err != nil {
+		return err
+	}
+	if err := authorizeOwner(ctx, id); err != nil {
+		return err
+	}
+	fields, err := updateFields(update.Fields)
+	if err != nil {
+		return err
+	}
+	update.Fields = fields
+	if err := validateUserFields(update.User, fields); err != nil {
+		return err
+	}
+	if slices.Contains(seen, update.User.ID) {
+		return ErrDuplicateBatchItem
+	}
+	return nil
+}
+
+// usersByID loads the users ids in one query, keyed by id
+func (uc *UseCase) usersByID(ctx context.Context, ids []uint) (map[uint]*model.User, error) {
+	users, err := uc.repo.GetUsersByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]*model.User, len(users))
+	for _, user := range users {
+		byID[user.ID] = user
+	}
+	return byID, nil
+}
+
+// takenEmails looks up which of emails already belong to a user in one query
+func (uc *UseCase) takenEmails(ctx context.Context, emails []string) (map[string]bool, error) {
+	users, err := uc.repo.GetUsersByEmails(ctx, emails)
+	if err != nil {
+		return nil, err
+	}
+	taken := make(map[string]bool, len(emails))
+	for _, user := range users {
+		taken[user.Email] = true
+	}
+	return taken, nil
+}
+
+// settleBatch decides whether a batch goes ahead once every item was checked.
+// an all or nothing batch with a failed item writes nothing, so the items
+// that passed are reported as aborted instead
+func settleBatch(results []model.BatchResult, mode model.BatchMode) bool {
+	if mode == model.BatchBestEffort {
+		return true
+	}
+	failed := slices.ContainsFunc(results, func(r model.BatchResult) bool { return r.Err != nil })
+	if !failed {
+		return true
+	}
+	for i := range results {
+		if results[i].Err == nil {
+			results[i].Err = ErrBatchAborted
+		}
+	}
+	return false
+}
+
+// isUpdateConflict tells the per item failures of a repository update, a
+// stale version or a user deleted in the meantime, from broken storage
+func isUpdateConflict(err error) bool {
+	return errors.Is(err, model.ErrVersionConflict) || errors.Is(err, gorm.ErrRecordNotFound)
+}
+
+// updateError maps a per item repository update failure to its domain error
+func updateError(err error) error {
+	if errors.Is(err, model.ErrVersionConflict) {
+		return ErrVersionConflict
+	}
+	return userLookupError(err)
+}
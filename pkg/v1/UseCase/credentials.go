@@ -0,0 +1,157 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/yishak-cs/CleanGrpc/Internal/auth"
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+	"github.com/yishak-cs/CleanGrpc/Internal/password"
+	"gorm.io/gorm"
+)
+
+// bounds on new passwords, the upper one keeps hashing cheap enough that it
+// cannot be used to tie up the server
+const (
+	minPasswordLen = 8
+	maxPasswordLen = 128
+)
+
+// Lockout locks an account for Duration once Threshold logins in a row
+// failed. a zero Threshold never locks
+type Lockout struct {
+	Threshold int
+	Duration  time.Duration
+}
+
+// DefaultLockout is used unless WithLockout says otherwise
+var DefaultLockout = Lockout{Threshold: 5, Duration: 15 * time.Minute}
+
+// hash checked against when there is no real one so unknown emails take as
+// long to reject as wrong passwords and cannot be told apart by timing
+var dummyHash = sync.OnceValue(func() string {
+	hash, _ := password.Hash("dummy password")
+	return hash
+})
+
+// SetPassword hashes newPassword and stores it for user id. callers limited
+// to their own record must prove they know the current password, if any
+func (uc *UseCase) SetPassword(ctx context.Context, id, currentPassword, newPassword string) (err error) {
+	ctx, span := startSpan(ctx, "SetPassword")
+	defer func() { endSpan(span, err) }()
+
+	if err := validateUserID(id); err != nil {
+		return err
+	}
+	if err := authorizeOwner(ctx, id); err != nil {
+		return err
+	}
+	if err := validatePassword(newPassword); err != nil {
+		return err
+	}
+
+	user, err := uc.repo.GetUser(ctx, id)
+	if err != nil {
+		return userLookupError(err)
+	}
+	if grant, _ := auth.GrantFromContext(ctx); grant == auth.GrantSelf && user.PasswordHash != "" {
+		ok, err := password.Verify(user.PasswordHash, currentPassword)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrWrongPassword
+		}
+	}
+
+	hash, err := password.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+	return uc.repo.SetPasswordHash(ctx, user.ID, hash)
+}
+
+// Login checks email and password and starts a session for the user. after
+// lockout.Threshold failures in a row the account is locked for
+// lockout.Duration, during which even the right password is refused
+func (uc *UseCase) Login(ctx context.Context, email, pass string) (_ *auth.TokenPair, err error) {
+	ctx, span := startSpan(ctx, "Login")
+	defer func() { endSpan(span, err) }()
+
+	if email == "" {
+		return nil, ErrEmailRequired
+	}
+	if pass == "" {
+		return nil, ErrPasswordRequired
+	}
+	if uc.issuer == nil {
+		return nil, ErrLoginDisabled
+	}
+
+	user, err := uc.repo.GetUserByEmail(ctx, email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		password.Verify(dummyHash(), pass)
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		return nil, ErrAccountLocked
+	}
+
+	hash := user.PasswordHash
+	if hash == "" {
+		hash = dummyHash()
+	}
+	ok, err := password.Verify(hash, pass)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || user.PasswordHash == "" {
+		if err := uc.recordLoginFailure(ctx, user.ID); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidCredentials
+	}
+
+	// a successful login forgets earlier failures
+	if user.FailedLogins > 0 || user.LockedUntil != nil {
+		if err := uc.repo.SetLoginLock(ctx, user.ID, 0, nil); err != nil {
+			return nil, err
+		}
+	}
+	// only now that the password checked out may the caller learn this
+	if user.Status == model.UserStatusPending {
+		return nil, ErrEmailNotVerified
+	}
+	return uc.startSession(ctx, user.ID)
+}
+
+// recordLoginFailure counts a failed login and starts the lockout once there
+// were too many in a row
+func (uc *UseCase) recordLoginFailure(ctx context.Context, id uint) error {
+	failures, err := uc.repo.RecordLoginFailure(ctx, id)
+	if err != nil {
+		return err
+	}
+	if uc.lockout.Threshold > 0 && failures >= uc.lockout.Threshold {
+		until := time.Now().Add(uc.lockout.Duration)
+		return uc.repo.SetLoginLock(ctx, id, 0, &until)
+	}
+	return nil
+}
+
+func validatePassword(pass string) error {
+	n := utf8.RuneCountInString(pass)
+	if n < minPasswordLen {
+		return ErrPasswordTooShort
+	}
+	if n > maxPasswordLen {
+		return ErrPasswordTooLong
+	}
+	return nil
+}
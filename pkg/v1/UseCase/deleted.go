@@ -0,0 +1,74 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+	"gorm.io/gorm"
+)
+
+// UndeleteUser restores the soft deleted user id and returns it. it fails
+// with ErrUserNotDeleted when the user is live and with ErrEmailTaken when a
+// live user has taken its email address since
+func (uc *UseCase) UndeleteUser(ctx context.Context, id string) (_ *model.User, err error) {
+	ctx, span := startSpan(ctx, "UndeleteUser")
+	defer func() { endSpan(span, err) }()
+
+	user, err := uc.getDeletedUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err = uc.checkEmailAvailable(ctx, user.Email); err != nil {
+		return nil, err
+	}
+	if err = uc.repo.RestoreUser(ctx, user.ID); err != nil {
+		// someone else restored or purged it in the meantime
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotDeleted
+		}
+		return nil, err
+	}
+
+	restored, err := uc.repo.GetUser(ctx, id)
+	if err != nil {
+		return nil, userLookupError(err)
+	}
+	return restored, nil
+}
+
+// PurgeUser permanently removes the soft deleted user id along with its
+// sessions, email tokens and magic links. live users have to be deleted
+// first, it fails with ErrUserNotDeleted otherwise
+func (uc *UseCase) PurgeUser(ctx context.Context, id string) (err error) {
+	ctx, span := startSpan(ctx, "PurgeUser")
+	defer func() { endSpan(span, err) }()
+
+	user, err := uc.getDeletedUser(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err = uc.repo.PurgeUser(ctx, user.ID); err != nil {
+		// restored in the meantime, a purge never takes a live user
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUserNotDeleted
+		}
+		return err
+	}
+	return nil
+}
+
+// getDeletedUser returns user id if it is soft deleted
+func (uc *UseCase) getDeletedUser(ctx context.Context, id string) (*model.User, error) {
+	if err := validateUserID(id); err != nil {
+		return nil, err
+	}
+	user, err := uc.repo.GetUserIncludingDeleted(ctx, id)
+	if err != nil {
+		return nil, userLookupError(err)
+	}
+	if !user.DeletedAt.Valid {
+		return nil, ErrUserNotDeleted
+	}
+	return user, nil
+}
@@ -0,0 +1,143 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/yishak-cs/CleanGrpc/Internal/auth"
+	"github.com/yishak-cs/CleanGrpc/Internal/errs"
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidPageToken          = errs.NewInvalidArgument("page_token", "INVALID_PAGE_TOKEN", "invalid page token")
+	ErrPageTokenMismatch         = errs.NewInvalidArgument("page_token", "PAGE_TOKEN_MISMATCH", "page token was issued for a different filter, order_by or include_deleted")
+	ErrInvalidPageSize           = errs.NewInvalidArgument("page_size", "INVALID_PAGE_SIZE", "page size must not be negative")
+	ErrInvalidUserID             = errs.NewInvalidArgument("id", "INVALID_USER_ID", "user id must be a positive integer")
+	ErrNameRequired              = errs.NewInvalidArgument("name", "NAME_REQUIRED", "please provide your name")
+	ErrEmailRequired             = errs.NewInvalidArgument("email", "EMAIL_REQUIRED", "please provide your email")
+	ErrSearchQueryRequired       = errs.NewInvalidArgument("query", "SEARCH_QUERY_REQUIRED", "please provide something to search for")
+	ErrSearchQueryTooLong        = errs.NewInvalidArgument("query", "SEARCH_QUERY_TOO_LONG", "search query has too many terms")
+	ErrInvalidSearchLimit        = errs.NewInvalidArgument("limit", "INVALID_SEARCH_LIMIT", "limit must not be negative")
+	ErrEmailTaken                = errs.NewAlreadyExists("EMAIL_TAKEN", "the email already exists. please choose another email")
+	ErrNotOwner                  = errs.NewPermissionDenied("NOT_OWNER", "you may only act on your own user record")
+	ErrPasswordRequired          = errs.NewInvalidArgument("password", "PASSWORD_REQUIRED", "please provide your password")
+	ErrPasswordTooShort          = errs.NewInvalidArgument("new_password", "PASSWORD_TOO_SHORT", fmt.Sprintf("password must be at least %d characters", minPasswordLen))
+	ErrPasswordTooLong           = errs.NewInvalidArgument("new_password", "PASSWORD_TOO_LONG", fmt.Sprintf("password must be at most %d characters", maxPasswordLen))
+	ErrWrongPassword             = errs.NewInvalidArgument("current_password", "WRONG_PASSWORD", "current password is incorrect")
+	ErrInvalidCredentials        = errs.NewUnauthenticated("INVALID_CREDENTIALS", "invalid email or password")
+	ErrAccountLocked             = errs.NewUnauthenticated("ACCOUNT_LOCKED", "too many failed logins, try again later")
+	ErrLoginDisabled             = errs.NewFailedPrecondition("LOGIN_DISABLED", "the server has no key to sign tokens with")
+	ErrInvalidRefreshToken       = errs.NewUnauthenticated("INVALID_REFRESH_TOKEN", "refresh token is invalid or expired")
+	ErrRefreshTokenReused        = errs.NewUnauthenticated("REFRESH_TOKEN_REUSED", "refresh token was already used, the session has been revoked")
+	ErrEmailNotVerified          = errs.NewFailedPrecondition("EMAIL_NOT_VERIFIED", "verify your email address before logging in")
+	ErrEmailAlreadyVerified      = errs.NewFailedPrecondition("EMAIL_ALREADY_VERIFIED", "the email address is already verified")
+	ErrVerificationDisabled      = errs.NewFailedPrecondition("EMAIL_VERIFICATION_DISABLED", "the server sends no verification emails")
+	ErrVerificationTokenRequired = errs.NewInvalidArgument("token", "VERIFICATION_TOKEN_REQUIRED", "please provide the verification token")
+	ErrInvalidVerificationToken  = errs.NewInvalidArgument("token", "INVALID_VERIFICATION_TOKEN", "verification token is invalid or expired")
+	ErrMagicLinkDisabled         = errs.NewFailedPrecondition("MAGIC_LINK_DISABLED", "the server sends no sign-in links")
+	ErrTooManyMagicLinks         = errs.NewResourceExhausted("MAGIC_LINK_RATE_LIMITED", "too many sign-in links were requested for this email, try again later")
+	ErrInvalidMagicLink          = errs.NewUnauthenticated("INVALID_MAGIC_LINK", "sign-in link is invalid or expired")
+	ErrInvalidUpdateMask         = errs.NewInvalidArgument("update_mask", "INVALID_UPDATE_MASK", "update mask may only name name and email")
+	ErrBatchTooLarge             = errs.NewInvalidArgument("", "BATCH_TOO_LARGE", fmt.Sprintf("a batch may hold at most %d items", MaxBatchSize))
+	ErrDuplicateBatchItem        = errs.NewInvalidArgument("id", "DUPLICATE_BATCH_ITEM", "the user appears more than once in the batch")
+	ErrBatchAborted              = errs.NewAborted("BATCH_ABORTED", "nothing was written because another item of the batch failed")
+	ErrDuplicateImportEmail      = errs.NewInvalidArgument("email", "DUPLICATE_EMAIL", "the email already appeared earlier in the import")
+	ErrVersionConflict           = errs.NewAborted("VERSION_CONFLICT", "the user was changed since it was read, fetch it again and retry")
+	ErrUserNotDeleted            = errs.NewFailedPrecondition("USER_NOT_DELETED", "the user is not deleted")
+	ErrIncludeDeletedDenied      = errs.NewPermissionDenied("INCLUDE_DELETED_DENIED", "only callers with full access may list deleted users")
+)
+
+// translate a repository lookup failure into a NotFound domain error when the
+// row is missing, any other failure is passed through untouched
+func userLookupError(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errs.NewNotFound("USER_NOT_FOUND", "user not found", err)
+	}
+	return err
+}
+
+// ids travel as strings through the API, reject anything that is not a
+// primary key before it gets near the database
+func validateUserID(id string) error {
+	if n, err := strconv.ParseUint(id, 10, 64); err != nil || n == 0 {
+		return ErrInvalidUserID
+	}
+	return nil
+}
+
+// authorizeOwner enforces the "self" grant: a caller the policy limited to
+// their own record may only touch the user whose id is their subject
+func authorizeOwner(ctx context.Context, id string) error {
+	if grant, _ := auth.GrantFromContext(ctx); grant != auth.GrantSelf {
+		return nil
+	}
+	principal, ok := auth.PrincipalFromContext(ctx)
+	if !ok {
+		return ErrNotOwner
+	}
+	subject, err := strconv.ParseUint(principal.Subject, 10, 64)
+	target, _ := strconv.ParseUint(id, 10, 64)
+	if err != nil || subject != target {
+		return ErrNotOwner
+	}
+	return nil
+}
+
+// authorizeIncludeDeleted reserves deleted users for callers the policy gave
+// full access. only without authentication, when no policy is installed,
+// does a caller have it without being granted it
+func authorizeIncludeDeleted(ctx context.Context) error {
+	grant, ok := auth.GrantFromContext(ctx)
+	if !ok {
+		if _, authenticated := auth.PrincipalFromContext(ctx); authenticated {
+			return ErrIncludeDeletedDenied
+		}
+		return nil
+	}
+	if grant != auth.GrantFull {
+		return ErrIncludeDeletedDenied
+	}
+	return nil
+}
+
+func validateUser(user *model.User) error {
+	return validateUserFields(user, model.UserUpdatableFields)
+}
+
+// validateUserFields checks only the fields an update writes
+func validateUserFields(user *model.User, fields []string) error {
+	if slices.Contains(fields, model.UserFieldName) && user.Name == "" {
+		return ErrNameRequired
+	}
+	if slices.Contains(fields, model.UserFieldEmail) && user.Email == "" {
+		return ErrEmailRequired
+	}
+	return nil
+}
+
+// updateFields resolves the paths of an update mask to the fields the update
+// writes. no paths or "*" mean all of them, repeated paths count once
+func updateFields(paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		return model.UserUpdatableFields, nil
+	}
+	fields := make([]string, 0, len(paths))
+	for _, path := range paths {
+		switch path {
+		case "*":
+			return model.UserUpdatableFields, nil
+		case model.UserFieldName, model.UserFieldEmail:
+			if !slices.Contains(fields, path) {
+				fields = append(fields, path)
+			}
+		default:
+			return nil, ErrInvalidUpdateMask
+		}
+	}
+	return fields, nil
+}
@@ -0,0 +1,49 @@
+package usecase
+
+import (
+	"context"
+
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ExportUsers hands every user matching filter to fn in orderBy order, both
+// spelled as for GetUsersList. it pages through the same keyset windows,
+// maxPageSize users at a time, so an export of any size holds one page in
+// memory and never skips or repeats a row because of an offset. an error
+// from fn stops the export and is passed back
+func (uc *UseCase) ExportUsers(ctx context.Context, filter, orderBy string, fn func(*model.User) error) (err error) {
+	ctx, span := startSpan(ctx, "ExportUsers")
+	exported := 0
+	defer func() {
+		span.SetAttributes(attribute.Int("users.exported", exported))
+		endSpan(span, err)
+	}()
+
+	conditions, err := parseFilter(filter)
+	if err != nil {
+		return err
+	}
+	order, err := parseOrderBy(orderBy)
+	if err != nil {
+		return err
+	}
+
+	query := model.UserQuery{Conditions: conditions, OrderBy: order, Limit: maxPageSize}
+	for {
+		users, err := uc.repo.GetUsersList(ctx, query)
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			if err := fn(user); err != nil {
+				return err
+			}
+			exported++
+		}
+		if len(users) < query.Limit {
+			return nil
+		}
+		query.After = keysetAfter(users[len(users)-1], order)
+	}
+}
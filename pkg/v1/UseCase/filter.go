@@ -0,0 +1,199 @@
+package usecase
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/yishak-cs/CleanGrpc/Internal/errs"
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+)
+
+const (
+	// most conditions a single filter may combine
+	maxFilterTerms = 10
+	// longest value a single condition may compare against
+	maxFilterValueLen = 256
+)
+
+// operators each filterable field accepts. anything not listed here is
+// rejected so the repository only ever sees known fields and operators
+var filterOps = map[model.UserField][]model.FilterOp{
+	model.FieldName:      {model.OpEquals, model.OpPrefix, model.OpSuffix, model.OpContains},
+	model.FieldEmail:     {model.OpEquals, model.OpPrefix, model.OpSuffix, model.OpContains},
+	model.FieldCreatedAt: {model.OpLess, model.OpLessEqual, model.OpGreater, model.OpGreaterEqual},
+}
+
+// fields a listing can be sorted by
+var orderFields = map[model.UserField]bool{
+	model.FieldID:        true,
+	model.FieldName:      true,
+	model.FieldEmail:     true,
+	model.FieldCreatedAt: true,
+}
+
+func invalidFilter(format string, args ...any) error {
+	return errs.NewInvalidArgument("filter", "INVALID_FILTER", "invalid filter: "+fmt.Sprintf(format, args...))
+}
+
+// parseFilter turns a filter expression into conditions. the grammar is
+//
+//	filter := term { "AND" term }
+//	term   := field op value
+//	field  := "name" | "email" | "created_at"
+//	op     := "=" | "prefix" | "suffix" | "contains"   (name, email)
+//	        | "<" | "<=" | ">" | ">="                  (created_at)
+//	value  := a double quoted string (\" and \\ escape) or a bare word
+//
+// created_at values are RFC 3339 timestamps. an empty filter matches everyone
+func parseFilter(filter string) ([]model.UserCondition, error) {
+	tokens, err := tokenizeFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var conditions []model.UserCondition
+	for i := 0; i < len(tokens); {
+		if len(conditions) > 0 {
+			if !strings.EqualFold(tokens[i].text, "AND") || tokens[i].quoted {
+				return nil, invalidFilter("expected AND, got %q", tokens[i].text)
+			}
+			i++
+		}
+		if len(tokens)-i < 3 {
+			return nil, invalidFilter("incomplete condition, expected <field> <op> <value>")
+		}
+		field, op, value := tokens[i], tokens[i+1], tokens[i+2]
+		i += 3
+
+		condition, err := parseCondition(field, op, value)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition)
+		if len(conditions) > maxFilterTerms {
+			return nil, invalidFilter("at most %d conditions are allowed", maxFilterTerms)
+		}
+	}
+	return conditions, nil
+}
+
+func parseCondition(field, op, value filterToken) (model.UserCondition, error) {
+	condition := model.UserCondition{
+		Field: model.UserField(field.text),
+		Op:    model.FilterOp(op.text),
+		Value: value.text,
+	}
+
+	allowed, ok := filterOps[condition.Field]
+	if !ok || field.quoted {
+		return condition, invalidFilter("unknown field %q", field.text)
+	}
+	if !containsOp(allowed, condition.Op) || op.quoted {
+		return condition, invalidFilter("operator %q is not supported on %s", op.text, field.text)
+	}
+	if len(condition.Value) > maxFilterValueLen {
+		return condition, invalidFilter("value for %s is longer than %d characters", field.text, maxFilterValueLen)
+	}
+
+	if condition.Field == model.FieldCreatedAt {
+		t, err := time.Parse(time.RFC3339Nano, condition.Value)
+		if err != nil {
+			return condition, invalidFilter("created_at value %q is not an RFC 3339 timestamp", condition.Value)
+		}
+		condition.Time = t
+	}
+	return condition, nil
+}
+
+func containsOp(ops []model.FilterOp, op model.FilterOp) bool {
+	for _, o := range ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+type filterToken struct {
+	text   string
+	quoted bool
+}
+
+// tokenizeFilter splits on whitespace and around comparison operators while
+// keeping quoted strings intact
+func tokenizeFilter(filter string) ([]filterToken, error) {
+	var tokens []filterToken
+	runes := []rune(filter)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '"':
+			var b strings.Builder
+			i++
+			closed := false
+			for i < len(runes) {
+				if runes[i] == '\\' && i+1 < len(runes) && (runes[i+1] == '"' || runes[i+1] == '\\') {
+					b.WriteRune(runes[i+1])
+					i += 2
+					continue
+				}
+				if runes[i] == '"' {
+					closed = true
+					i++
+					break
+				}
+				b.WriteRune(runes[i])
+				i++
+			}
+			if !closed {
+				return nil, invalidFilter("unterminated string")
+			}
+			tokens = append(tokens, filterToken{text: b.String(), quoted: true})
+
+		case r == '<' || r == '>' || r == '=':
+			op := string(r)
+			if r != '=' && i+1 < len(runes) && runes[i+1] == '=' {
+				op += "="
+			}
+			tokens = append(tokens, filterToken{text: op})
+			i += len(op)
+
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune(`"<>=`, runes[i]) {
+				i++
+			}
+			tokens = append(tokens, filterToken{text: string(runes[start:i])})
+		}
+	}
+	return tokens, nil
+}
+
+// parseOrderBy accepts "<field>", "<field> asc" or "<field> desc". the zero
+// value orders by id ascending
+func parseOrderBy(orderBy string) (model.UserOrder, error) {
+	order := model.UserOrder{Field: model.FieldID}
+	parts := strings.Fields(orderBy)
+	if len(parts) == 0 {
+		return order, nil
+	}
+	if len(parts) > 2 || !orderFields[model.UserField(strings.ToLower(parts[0]))] {
+		return order, errs.NewInvalidArgument("order_by", "INVALID_ORDER_BY", fmt.Sprintf("invalid order_by %q", orderBy))
+	}
+	order.Field = model.UserField(strings.ToLower(parts[0]))
+	if len(parts) == 2 {
+		switch strings.ToLower(parts[1]) {
+		case "asc":
+		case "desc":
+			order.Desc = true
+		default:
+			return order, errs.NewInvalidArgument("order_by", "INVALID_ORDER_BY", fmt.Sprintf("invalid order_by %q", orderBy))
+		}
+	}
+	return order, nil
+}
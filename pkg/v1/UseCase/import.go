@@ -0,0 +1,178 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+	"go.opentelemetry.io/otel/attribute"
+	"gorm.io/gorm"
+)
+
+// rows an import checks and writes together
+const importChunkSize = 500
+
+// pendingRow is a row waiting for its chunk to be written. rejected is how
+// many rejections the report held when the row was read, so a row that stops
+// the import can drop the ones that came after it
+type pendingRow struct {
+	row      *model.ImportRow
+	rejected int
+}
+
+// ImportUsers imports the rows next yields until it returns io.EOF. rows are
+// trimmed and validated like CreateUser input, an email seen earlier in the
+// import rejects every later row carrying it, and what happens to rows whose
+// email already belongs to a user is up to policy. rows are written in chunks
+// of importChunkSize, each checked for taken emails with a single query
+func (uc *UseCase) ImportUsers(ctx context.Context, next func() (*model.ImportRow, error), policy model.ConflictPolicy) (_ *model.ImportReport, err error) {
+	ctx, span := startSpan(ctx, "ImportUsers")
+	report := &model.ImportReport{}
+	defer func() {
+		span.SetAttributes(
+			attribute.Int("import.created", len(report.Created)),
+			attribute.Int("import.updated", len(report.Updated)),
+			attribute.Int("import.skipped", len(report.Skipped)),
+			attribute.Int("import.rejected", len(report.Rejected)),
+		)
+		endSpan(span, err)
+	}()
+
+	seen := make(map[string]bool)
+	var chunk []pendingRow
+	for {
+		row, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		row.Name = strings.TrimSpace(row.Name)
+		row.Email = strings.TrimSpace(row.Email)
+		if err := validateUser(&model.User{Name: row.Name, Email: row.Email}); err != nil {
+			report.Rejected = append(report.Rejected, model.ImportRejection{Line: row.Line, Email: row.Email, Err: err})
+			continue
+		}
+		if seen[row.Email] {
+			report.Rejected = append(report.Rejected, model.ImportRejection{Line: row.Line, Email: row.Email, Err: ErrDuplicateImportEmail})
+			continue
+		}
+		seen[row.Email] = true
+
+		chunk = append(chunk, pendingRow{row: row, rejected: len(report.Rejected)})
+		if len(chunk) < importChunkSize {
+			continue
+		}
+		uc.importChunk(ctx, chunk, policy, report)
+		if report.Aborted {
+			return report, nil
+		}
+		chunk = chunk[:0]
+	}
+
+	uc.importChunk(ctx, chunk, policy, report)
+	return report, nil
+}
+
+// importChunk writes the valid rows of one chunk and records what became of
+// them in report. earlier chunks are already written, so a failing write
+// rejects the rows it left unwritten and aborts the import instead of hiding
+// the report behind an error
+func (uc *UseCase) importChunk(ctx context.Context, chunk []pendingRow, policy model.ConflictPolicy, report *model.ImportReport) {
+	if len(chunk) == 0 {
+		return
+	}
+	emails := make([]string, len(chunk))
+	for i, pending := range chunk {
+		emails[i] = pending.row.Email
+	}
+	existing, err := uc.repo.GetUsersByEmails(ctx, emails)
+	if err != nil {
+		rows := make([]*model.ImportRow, len(chunk))
+		for i, pending := range chunk {
+			rows[i] = pending.row
+		}
+		abortImport(report, rows, err)
+		return
+	}
+	byEmail := make(map[string]*model.User, len(existing))
+	for _, user := range existing {
+		byEmail[user.Email] = user
+	}
+
+	var create, overwrite []*model.ImportRow
+rows:
+	for _, pending := range chunk {
+		if _, taken := byEmail[pending.row.Email]; !taken {
+			create = append(create, pending.row)
+			continue
+		}
+		switch policy {
+		case model.ConflictSkip:
+			report.Skipped = append(report.Skipped, pending.row.Line)
+		case model.ConflictOverwrite:
+			overwrite = append(overwrite, pending.row)
+		default:
+			// only the rows before this one are written
+			report.Rejected = append(report.Rejected[:pending.rejected], model.ImportRejection{Line: pending.row.Line, Email: pending.row.Email, Err: ErrEmailTaken})
+			report.Aborted = true
+			break rows
+		}
+	}
+
+	users := make([]*model.User, len(create))
+	for i, row := range create {
+		users[i] = &model.User{Name: row.Name, Email: row.Email}
+		// the address has to be proven before the account can be used
+		if uc.mailer != nil {
+			users[i].Status = model.UserStatusPending
+		}
+	}
+	if err := uc.repo.CreateUsers(ctx, users); err != nil {
+		abortImport(report, append(create, overwrite...), err)
+		return
+	}
+	for i, row := range create {
+		report.Created = append(report.Created, row.Line)
+		uc.sendVerificationOrLog(ctx, users[i])
+	}
+
+	if len(overwrite) == 0 {
+		return
+	}
+	updates := make([]model.UserUpdate, len(overwrite))
+	for i, row := range overwrite {
+		updates[i] = model.UserUpdate{
+			User:   &model.User{Model: gorm.Model{ID: byEmail[row.Email].ID}, Name: row.Name},
+			Fields: []string{model.UserFieldName},
+		}
+	}
+	if err := uc.repo.UpdateUsers(ctx, updates); err != nil {
+		var itemErr *model.BatchItemError
+		if errors.As(err, &itemErr) {
+			// every update rolled back with the one that failed
+			rejected := len(report.Rejected)
+			abortImport(report, overwrite, ErrBatchAborted)
+			report.Rejected[rejected+itemErr.Index].Err = updateError(itemErr.Err)
+			return
+		}
+		abortImport(report, overwrite, err)
+		return
+	}
+	for _, row := range overwrite {
+		report.Updated = append(report.Updated, row.Line)
+	}
+}
+
+// abortImport rejects rows, which a failed write left unwritten, with err
+// and stops the import
+func abortImport(report *model.ImportReport, rows []*model.ImportRow, err error) {
+	for _, row := range rows {
+		report.Rejected = append(report.Rejected, model.ImportRejection{Line: row.Line, Email: row.Email, Err: err})
+	}
+	report.Aborted = true
+}
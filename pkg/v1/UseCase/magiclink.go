@@ -0,0 +1,144 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yishak-cs/CleanGrpc/Internal/auth"
+	"github.com/yishak-cs/CleanGrpc/Internal/mail"
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+	"gorm.io/gorm"
+)
+
+// MagicLink shapes passwordless sign-in. URL is the link mailed with
+// "{token}" standing in for the token, empty mails the bare token. a link
+// works for TTL and at most Limit links are requested per email address in
+// every Window, 0 meaning no limit
+type MagicLink struct {
+	URL    string
+	TTL    time.Duration
+	Limit  int
+	Window time.Duration
+}
+
+// DefaultMagicLink is used unless WithMagicLink says otherwise
+var DefaultMagicLink = MagicLink{TTL: 15 * time.Minute, Limit: 3, Window: 15 * time.Minute}
+
+// RequestMagicLink mails a single-use sign-in link to email if a user owns
+// it. the outcome looks the same whether or not one does: the link is made
+// and mailed in the background and unknown addresses count towards the rate
+// limit like known ones
+func (uc *UseCase) RequestMagicLink(ctx context.Context, email string) (err error) {
+	ctx, span := startSpan(ctx, "RequestMagicLink")
+	defer func() { endSpan(span, err) }()
+
+	if email == "" {
+		return ErrEmailRequired
+	}
+	if uc.mailer == nil || uc.issuer == nil {
+		return ErrMagicLinkDisabled
+	}
+	if !uc.magicLimiter.allow(strings.ToLower(strings.TrimSpace(email))) {
+		return ErrTooManyMagicLinks
+	}
+
+	user, err := uc.repo.GetUserByEmail(ctx, email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// the caller is not kept waiting on the mail, which would give away
+	// that the address is known
+	uc.background.Add(1)
+	go func() {
+		defer uc.background.Done()
+		ctx := context.WithoutCancel(ctx)
+		if err := uc.sendMagicLink(ctx, user); err != nil {
+			slog.ErrorContext(ctx, "unable to send magic link", "user_id", user.ID, "err", err)
+		}
+	}()
+	return nil
+}
+
+// RedeemMagicLink exchanges a token mailed by RequestMagicLink for a new
+// session of its user. the token works once, and since it proves the user
+// owns the address a pending account is activated on the way
+func (uc *UseCase) RedeemMagicLink(ctx context.Context, token string) (_ *auth.TokenPair, err error) {
+	ctx, span := startSpan(ctx, "RedeemMagicLink")
+	defer func() { endSpan(span, err) }()
+
+	if uc.issuer == nil {
+		return nil, ErrLoginDisabled
+	}
+	if token == "" {
+		return nil, ErrInvalidMagicLink
+	}
+	record, err := uc.repo.ConsumeMagicLinkToken(ctx, auth.HashEmailToken(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidMagicLink
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(record.ExpiresAt) {
+		return nil, ErrInvalidMagicLink
+	}
+
+	user, err := uc.repo.GetUser(ctx, strconv.FormatUint(uint64(record.UserID), 10))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidMagicLink
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the address changed since the link went out
+	if user.Email != record.Email {
+		return nil, ErrInvalidMagicLink
+	}
+	if user.Status == model.UserStatusPending {
+		if err := uc.repo.SetUserStatus(ctx, user.ID, model.UserStatusActive); err != nil {
+			return nil, err
+		}
+	}
+	return uc.startSession(ctx, user.ID)
+}
+
+// sendMagicLink stores a new sign-in token for user and mails it, an earlier
+// link that was not used yet stops working
+func (uc *UseCase) sendMagicLink(ctx context.Context, user *model.User) error {
+	token, hash, err := auth.NewEmailToken()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	record := &model.MagicLinkToken{
+		Hash:      hash,
+		UserID:    user.ID,
+		Email:     user.Email,
+		CreatedAt: now,
+		ExpiresAt: now.Add(uc.magicLink.TTL),
+	}
+	if err := uc.repo.ReplaceMagicLinkToken(ctx, record); err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Hi %s,\n\n", user.Name)
+	if uc.magicLink.URL != "" {
+		fmt.Fprintf(&b, "open this link to sign in:\n\n%s\n\n", strings.ReplaceAll(uc.magicLink.URL, "{token}", url.QueryEscape(token)))
+	} else {
+		fmt.Fprintf(&b, "use this token to sign in:\n\n%s\n\n", token)
+	}
+	fmt.Fprintf(&b, "It works once and expires in %s. If you did not ask to sign in, ignore this email.", shortDuration(uc.magicLink.TTL))
+
+	return uc.mailer.Send(ctx, mail.Message{To: user.Email, Subject: "Your sign-in link", Body: b.String()})
+}
@@ -0,0 +1,106 @@
+package usecase
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+)
+
+const (
+	// page size used when the caller does not ask for one
+	defaultPageSize = 50
+	// upper bound on a single page, larger requests are clamped to it
+	maxPageSize = 1000
+)
+
+// cursor is the keyset position a page token points at. it is serialized to
+// json and base64 encoded so clients treat it as an opaque string. Query
+// fingerprints the filter and order the token was issued for so it cannot be
+// replayed against a different listing
+type cursor struct {
+	ID    uint   `json:"id"`
+	Value string `json:"v,omitempty"`
+	Query string `json:"q,omitempty"`
+}
+
+func encodeCursor(c cursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(token string) (cursor, error) {
+	var c cursor
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return c, ErrInvalidPageToken
+	}
+	if err := json.Unmarshal(raw, &c); err != nil || c.ID == 0 {
+		return c, ErrInvalidPageToken
+	}
+	return c, nil
+}
+
+// queryFingerprint identifies the listing a page token belongs to. listings
+// of live users hash as they did before deleted ones could be included, so
+// tokens handed out earlier keep working
+func queryFingerprint(filter string, order model.UserOrder, includeDeleted bool) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s\x00%s\x00%t", filter, order.Field, order.Desc)
+	if includeDeleted {
+		fmt.Fprint(h, "\x00deleted")
+	}
+	return fmt.Sprintf("%x", h.Sum64())
+}
+
+// cursorAfter builds the cursor pointing just past user in the given order
+func cursorAfter(user *model.User, order model.UserOrder, fingerprint string) cursor {
+	c := cursor{ID: user.ID, Query: fingerprint}
+	switch order.Field {
+	case model.FieldName:
+		c.Value = user.Name
+	case model.FieldEmail:
+		c.Value = user.Email
+	case model.FieldCreatedAt:
+		c.Value = user.CreatedAt.Format(time.RFC3339Nano)
+	}
+	return c
+}
+
+// keysetAfter is the repository cursor just past user in the given order,
+// for walks that never hand a token to a client
+func keysetAfter(user *model.User, order model.UserOrder) *model.UserCursor {
+	after := &model.UserCursor{ID: user.ID}
+	switch order.Field {
+	case model.FieldName:
+		after.Value = user.Name
+	case model.FieldEmail:
+		after.Value = user.Email
+	case model.FieldCreatedAt:
+		after.Value = user.CreatedAt
+	}
+	return after
+}
+
+// userCursor converts a decoded page token back into the repository's typed
+// cursor, rejecting tokens issued for another filter or order
+func userCursor(c cursor, order model.UserOrder, fingerprint string) (*model.UserCursor, error) {
+	if c.Query != fingerprint {
+		return nil, ErrPageTokenMismatch
+	}
+	after := &model.UserCursor{ID: c.ID}
+	switch order.Field {
+	case model.FieldName, model.FieldEmail:
+		after.Value = c.Value
+	case model.FieldCreatedAt:
+		t, err := time.Parse(time.RFC3339Nano, c.Value)
+		if err != nil {
+			return nil, ErrInvalidPageToken
+		}
+		after.Value = t
+	}
+	return after, nil
+}
@@ -0,0 +1,57 @@
+package usecase
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter admits up to limit events per key in every fixed window. it
+// lives in memory, so each server instance counts on its own
+type rateLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	windows map[string]*rateWindow
+	pruned  time.Time
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, windows: map[string]*rateWindow{}, pruned: time.Now()}
+}
+
+// allow counts one event for key and reports whether it is within the
+// limit. a limit of 0 allows everything
+func (l *rateLimiter) allow(key string) bool {
+	if l.limit <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	// forget finished windows once per window so keys seen once do not pile up
+	if now.Sub(l.pruned) >= l.window {
+		for k, w := range l.windows {
+			if now.Sub(w.start) >= l.window {
+				delete(l.windows, k)
+			}
+		}
+		l.pruned = now
+	}
+
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		w = &rateWindow{start: now}
+		l.windows[key] = w
+	}
+	if w.count >= l.limit {
+		return false
+	}
+	w.count++
+	return true
+}
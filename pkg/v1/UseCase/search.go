@@ -0,0 +1,62 @@
+package usecase
+
+import (
+	"context"
+	"strings"
+	"unicode"
+
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+)
+
+const (
+	// results returned when the caller does not ask for a number
+	defaultSearchLimit = 20
+	// upper bound on results, larger requests are clamped to it
+	maxSearchLimit = 100
+	// most terms a single search may contain
+	maxSearchTerms = 8
+)
+
+// SearchUsers ranks users by how well their name and email match query.
+// every whitespace separated term must match the start of a word, so "jo
+// exa" finds john@example.com
+func (uc *UseCase) SearchUsers(ctx context.Context, query string, limit int) (_ []*model.UserSearchResult, err error) {
+	ctx, span := startSpan(ctx, "SearchUsers")
+	defer func() { endSpan(span, err) }()
+	if limit < 0 {
+		return nil, ErrInvalidSearchLimit
+	}
+	if limit == 0 {
+		limit = defaultSearchLimit
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	match, err := searchExpression(query)
+	if err != nil {
+		return nil, err
+	}
+	return uc.repo.SearchUsers(ctx, match, limit)
+}
+
+// searchExpression turns free text into an FTS5 query. each term becomes a
+// quoted prefix phrase so punctuation and FTS5 operators in the input are
+// matched as text instead of being interpreted
+func searchExpression(query string) (string, error) {
+	var phrases []string
+	for _, term := range strings.Fields(query) {
+		// terms without a letter or digit tokenize to nothing
+		if strings.IndexFunc(term, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) < 0 {
+			continue
+		}
+		phrases = append(phrases, `"`+strings.ReplaceAll(term, `"`, `""`)+`"*`)
+	}
+	if len(phrases) == 0 {
+		return "", ErrSearchQueryRequired
+	}
+	if len(phrases) > maxSearchTerms {
+		return "", ErrSearchQueryTooLong
+	}
+	return strings.Join(phrases, " "), nil
+}
@@ -0,0 +1,188 @@
+package usecase
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yishak-cs/CleanGrpc/Internal/auth"
+	"github.com/yishak-cs/CleanGrpc/Internal/errs"
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+	"gorm.io/gorm"
+)
+
+// DefaultSessionTTL is how long an unused session lives unless WithSessionTTL
+// says otherwise. every refresh starts the period over
+const DefaultSessionTTL = 30 * 24 * time.Hour
+
+// IssueSession starts a new session for user userID, e.g. for an app that
+// signed the user in by other means. like Login it refuses users who have not
+// verified their email address yet
+func (uc *UseCase) IssueSession(ctx context.Context, userID string) (_ *auth.TokenPair, err error) {
+	ctx, span := startSpan(ctx, "IssueSession")
+	defer func() { endSpan(span, err) }()
+
+	if err := validateUserID(userID); err != nil {
+		return nil, err
+	}
+	if err := authorizeOwner(ctx, userID); err != nil {
+		return nil, err
+	}
+	if uc.issuer == nil {
+		return nil, ErrLoginDisabled
+	}
+	user, err := uc.repo.GetUser(ctx, userID)
+	if err != nil {
+		return nil, userLookupError(err)
+	}
+	if user.Status == model.UserStatusPending {
+		return nil, ErrEmailNotVerified
+	}
+	return uc.startSession(ctx, user.ID)
+}
+
+// RefreshSession exchanges a refresh token for a new access token and the
+// next refresh token of the same session. every refresh token works once:
+// presenting the one that was exchanged last means it leaked, so the session
+// is revoked for both whoever holds it and the rightful owner. any other
+// token is merely invalid, knowing the public session id is not enough to
+// end a session
+func (uc *UseCase) RefreshSession(ctx context.Context, refreshToken string) (_ *auth.TokenPair, err error) {
+	ctx, span := startSpan(ctx, "RefreshSession")
+	defer func() { endSpan(span, err) }()
+
+	if uc.issuer == nil {
+		return nil, ErrLoginDisabled
+	}
+	sessionID, ok := auth.ParseRefreshToken(refreshToken)
+	if !ok {
+		return nil, ErrInvalidRefreshToken
+	}
+	session, err := uc.repo.GetSession(ctx, sessionID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if !now.Before(session.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+	hash := auth.HashRefreshToken(refreshToken)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(session.TokenHash)) != 1 {
+		if session.PreviousTokenHash != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(session.PreviousTokenHash)) == 1 {
+			return nil, uc.revokeReused(ctx, session.ID)
+		}
+		return nil, ErrInvalidRefreshToken
+	}
+	// the user may have been deleted since the session started
+	if _, err := uc.repo.GetUser(ctx, strconv.FormatUint(uint64(session.UserID), 10)); err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if err := uc.repo.DeleteSession(ctx, session.ID); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidRefreshToken
+	}
+
+	next, nextHash, err := auth.NewRefreshToken(session.ID)
+	if err != nil {
+		return nil, err
+	}
+	rotated, err := uc.repo.RotateSession(ctx, session.ID, hash, nextHash, now, now.Add(uc.sessionTTL))
+	if err != nil {
+		return nil, err
+	}
+	if !rotated {
+		// the same token was exchanged concurrently
+		return nil, uc.revokeReused(ctx, session.ID)
+	}
+	return uc.tokens(session, next)
+}
+
+// ListSessions returns the live sessions of user userID
+func (uc *UseCase) ListSessions(ctx context.Context, userID string) (_ []*model.Session, err error) {
+	ctx, span := startSpan(ctx, "ListSessions")
+	defer func() { endSpan(span, err) }()
+
+	if err := validateUserID(userID); err != nil {
+		return nil, err
+	}
+	if err := authorizeOwner(ctx, userID); err != nil {
+		return nil, err
+	}
+	id, _ := strconv.ParseUint(userID, 10, 64)
+	return uc.repo.ListSessions(ctx, uint(id), time.Now())
+}
+
+// RevokeSession ends session sessionID of user userID, its refresh token
+// stops working right away. access tokens already handed out stay valid
+// until they expire
+func (uc *UseCase) RevokeSession(ctx context.Context, userID, sessionID string) (err error) {
+	ctx, span := startSpan(ctx, "RevokeSession")
+	defer func() { endSpan(span, err) }()
+
+	if err := validateUserID(userID); err != nil {
+		return err
+	}
+	if err := authorizeOwner(ctx, userID); err != nil {
+		return err
+	}
+	session, err := uc.repo.GetSession(ctx, sessionID)
+	if err != nil {
+		return sessionLookupError(err)
+	}
+	// another user's session does not exist as far as this caller is concerned
+	if strconv.FormatUint(uint64(session.UserID), 10) != userID {
+		return sessionLookupError(gorm.ErrRecordNotFound)
+	}
+	return uc.repo.DeleteSession(ctx, session.ID)
+}
+
+// startSession stores a new session for userID and hands out its first tokens
+func (uc *UseCase) startSession(ctx context.Context, userID uint) (*auth.TokenPair, error) {
+	session := &model.Session{ID: uuid.NewString(), UserID: userID}
+	token, hash, err := auth.NewRefreshToken(session.ID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	session.TokenHash = hash
+	session.CreatedAt = now
+	session.LastUsedAt = now
+	session.ExpiresAt = now.Add(uc.sessionTTL)
+	if err := uc.repo.CreateSession(ctx, session); err != nil {
+		return nil, err
+	}
+	return uc.tokens(session, token)
+}
+
+// tokens pairs refreshToken with a fresh access token for the session's user
+func (uc *UseCase) tokens(session *model.Session, refreshToken string) (*auth.TokenPair, error) {
+	access, expiresAt, err := uc.issuer.Issue(auth.Principal{Subject: strconv.FormatUint(uint64(session.UserID), 10)})
+	if err != nil {
+		return nil, err
+	}
+	return &auth.TokenPair{SessionID: session.ID, AccessToken: access, RefreshToken: refreshToken, ExpiresAt: expiresAt}, nil
+}
+
+// revokeReused ends a session whose refresh token was used twice
+func (uc *UseCase) revokeReused(ctx context.Context, sessionID string) error {
+	if err := uc.repo.DeleteSession(ctx, sessionID); err != nil {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
+
+func sessionLookupError(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return errs.NewNotFound("SESSION_NOT_FOUND", "session not found", err)
+	}
+	return err
+}
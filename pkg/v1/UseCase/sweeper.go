@@ -0,0 +1,91 @@
+package usecase
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	interfaces "github.com/yishak-cs/CleanGrpc/pkg/v1"
+)
+
+// SessionSweeper deletes expired sessions, email tokens and magic links in
+// the background so their tables only grow with live ones
+type SessionSweeper struct {
+	repo     interfaces.RepoInterface
+	interval time.Duration
+}
+
+// NewSessionSweeper returns a SessionSweeper that runs every interval
+func NewSessionSweeper(repo interfaces.RepoInterface, interval time.Duration) *SessionSweeper {
+	return &SessionSweeper{repo: repo, interval: interval}
+}
+
+// Run sweeps right away and then once per interval until ctx is done
+func (s *SessionSweeper) Run(ctx context.Context) {
+	runEvery(ctx, s.interval, func() { s.Sweep(ctx) })
+}
+
+// Sweep deletes the sessions, email tokens and magic links that have expired
+// by now and returns how many. failures are logged, the next sweep catches up on what
+// this one missed
+func (s *SessionSweeper) Sweep(ctx context.Context) int64 {
+	now := time.Now()
+	return sweep(ctx, "expired sessions", now, s.repo.DeleteExpiredSessions) +
+		sweep(ctx, "expired email tokens", now, s.repo.DeleteExpiredEmailTokens) +
+		sweep(ctx, "expired magic links", now, s.repo.DeleteExpiredMagicLinkTokens)
+}
+
+// UserPurger permanently removes users once they have been soft deleted for
+// longer than the retention period, so a deletion can be undone until then
+type UserPurger struct {
+	repo      interfaces.RepoInterface
+	retention time.Duration
+	interval  time.Duration
+}
+
+// NewUserPurger returns a UserPurger that runs every interval and purges
+// users deleted more than retention ago
+func NewUserPurger(repo interfaces.RepoInterface, retention, interval time.Duration) *UserPurger {
+	return &UserPurger{repo: repo, retention: retention, interval: interval}
+}
+
+// Run purges right away and then once per interval until ctx is done
+func (p *UserPurger) Run(ctx context.Context) {
+	runEvery(ctx, p.interval, func() { p.Purge(ctx) })
+}
+
+// Purge removes the users deleted more than the retention period before now
+// and returns how many. failures are logged like those of a sweep
+func (p *UserPurger) Purge(ctx context.Context) int64 {
+	return sweep(ctx, "users past their retention", time.Now().Add(-p.retention), p.repo.PurgeDeletedUsers)
+}
+
+// runEvery calls fn right away and then once per interval until ctx is done
+func runEvery(ctx context.Context, interval time.Duration, fn func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		fn()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweep deletes what deleteExpired finds before before and logs the outcome
+func sweep(ctx context.Context, what string, before time.Time, deleteExpired func(context.Context, time.Time) (int64, error)) int64 {
+	deleted, err := deleteExpired(ctx, before)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Error("unable to delete "+what, "err", err)
+		}
+		return 0
+	}
+	if deleted > 0 {
+		slog.Info("deleted "+what, "count", deleted)
+	}
+	return deleted
+}
@@ -0,0 +1,73 @@
+package usecase_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/yishak-cs/CleanGrpc/Internal/auth"
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+	usecase "github.com/yishak-cs/CleanGrpc/pkg/v1/UseCase"
+	"gorm.io/gorm"
+)
+
+// callerContext is what the policy hands the use case for principal
+func callerContext(principal *auth.Principal, grant auth.Grant) context.Context {
+	ctx := context.Background()
+	if principal != nil {
+		ctx = auth.WithPrincipal(ctx, principal)
+	}
+	return auth.WithGrant(ctx, grant)
+}
+
+func TestUseCase_Ownership(t *testing.T) {
+	admin := &auth.Principal{Subject: "1", Roles: []string{"admin"}}
+	owner := &auth.Principal{Subject: "2", Roles: []string{"user"}}
+	other := &auth.Principal{Subject: "3", Roles: []string{"user"}}
+	bogus := &auth.Principal{Subject: "service-account"}
+
+	target := &model.User{Model: gorm.Model{ID: 2}, Name: "Owner", Email: "owner@example.com"}
+
+	cases := []struct {
+		name    string
+		ctx     context.Context
+		allowed bool
+	}{
+		{"admin with full grant", callerContext(admin, auth.GrantFull), true},
+		{"owner limited to self", callerContext(owner, auth.GrantSelf), true},
+		{"other user limited to self", callerContext(other, auth.GrantSelf), false},
+		{"non numeric subject limited to self", callerContext(bogus, auth.GrantSelf), false},
+		{"self grant without principal", callerContext(nil, auth.GrantSelf), false},
+		{"no policy installed", context.Background(), true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(MockRepository)
+			useCase := usecase.NewUseCase(mockRepo)
+			mockRepo.On("GetUser", "2").Return(target, nil)
+			mockRepo.On("GetUserByEmail", "new@example.com").Return(nil, gorm.ErrRecordNotFound)
+			mockRepo.On("UpdateUser", &model.User{Model: gorm.Model{ID: 2}, Name: "New", Email: "new@example.com"}, model.UserUpdatableFields).Return(nil)
+			mockRepo.On("DeleteUser", "2").Return(nil)
+
+			_, getErr := useCase.GetUser(tc.ctx, "2")
+			updateErr := useCase.UpdateUser(tc.ctx, &model.User{Model: gorm.Model{ID: 2}, Name: "New", Email: "new@example.com"}, nil)
+			deleteErr := useCase.DeleteUser(tc.ctx, "2")
+
+			if tc.allowed {
+				assert.NoError(t, getErr)
+				assert.NoError(t, updateErr)
+				assert.NoError(t, deleteErr)
+				return
+			}
+			// denied before the repository is asked anything
+			assert.ErrorIs(t, getErr, usecase.ErrNotOwner)
+			assert.ErrorIs(t, updateErr, usecase.ErrNotOwner)
+			assert.ErrorIs(t, deleteErr, usecase.ErrNotOwner)
+			mockRepo.AssertNotCalled(t, "GetUser", "2")
+			mockRepo.AssertNotCalled(t, "UpdateUser", mock.Anything, mock.Anything)
+			mockRepo.AssertNotCalled(t, "DeleteUser", "2")
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package usecase_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/yishak-cs/CleanGrpc/Internal/errs"
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+	usecase "github.com/yishak-cs/CleanGrpc/pkg/v1/UseCase"
+	"gorm.io/gorm"
+)
+
+func TestUseCase_BatchCreateUsers(t *testing.T) {
+	ctx := context.Background()
+	batch := func() []*model.User {
+		return []*model.User{
+			{Name: "A", Email: "a@example.com"},
+			{Name: "", Email: "b@example.com"},
+			{Name: "C", Email: "taken@example.com"},
+			{Name: "D", Email: "a@example.com"},
+		}
+	}
+
+	// Test case: All or nothing writes nothing once an item fails, the others report aborted
+	mockRepo := new(MockRepository)
+	useCase := usecase.NewUseCase(mockRepo)
+	mockRepo.On("GetUsersByEmails", []string{"a@example.com", "taken@example.com", "a@example.com"}).
+		Return([]*model.User{{Model: gorm.Model{ID: 9}, Email: "taken@example.com"}}, nil)
+
+	results, err := useCase.BatchCreateUsers(ctx, batch(), model.BatchAllOrNothing)
+
+	assert.NoError(t, err)
+	assert.ErrorIs(t, results[0].Err, usecase.ErrBatchAborted)
+	assert.Equal(t, errs.Aborted, errs.CodeOf(results[0].Err))
+	assert.ErrorIs(t, results[1].Err, usecase.ErrNameRequired)
+	assert.ErrorIs(t, results[2].Err, usecase.ErrEmailTaken)
+	assert.ErrorIs(t, results[3].Err, usecase.ErrEmailTaken)
+	mockRepo.AssertNotCalled(t, "CreateUsers", mock.Anything)
+
+	// Test case: Best effort creates the items that passed with a single bulk write
+	mockRepo.On("CreateUsers", mock.MatchedBy(func(users []*model.User) bool {
+		return len(users) == 1 && users[0].Email == "a@example.com"
+	})).Run(func(args mock.Arguments) {
+		args.Get(0).([]*model.User)[0].ID = 10
+	}).Return(nil)
+
+	results, err = useCase.BatchCreateUsers(ctx, batch(), model.BatchBestEffort)
+
+	assert.NoError(t, err)
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, uint(10), results[0].User.ID)
+	assert.Nil(t, results[1].User)
+	assert.ErrorIs(t, results[2].Err, usecase.ErrEmailTaken)
+	mockRepo.AssertNumberOfCalls(t, "GetUsersByEmails", 2)
+	mockRepo.AssertNotCalled(t, "GetUserByEmail", mock.Anything)
+
+	// Test case: A failing bulk write fails the call
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo)
+	mockRepo.On("GetUsersByEmails", mock.Anything).Return([]*model.User{}, nil)
+	mockRepo.On("CreateUsers", mock.Anything).Return(errors.New("disk full"))
+
+	_, err = useCase.BatchCreateUsers(ctx, []*model.User{{Name: "A", Email: "a@example.com"}}, model.BatchAllOrNothing)
+	assert.ErrorContains(t, err, "disk full")
+
+	// Test case: Batches are capped
+	_, err = useCase.BatchCreateUsers(ctx, make([]*model.User, usecase.MaxBatchSize+1), model.BatchBestEffort)
+	assert.ErrorIs(t, err, usecase.ErrBatchTooLarge)
+}
+
+func TestUseCase_BatchUpdateUsers(t *testing.T) {
+	ctx := context.Background()
+	stored := []*model.User{
+		{Model: gorm.Model{ID: 1}, Name: "One", Email: "one@example.com", Version: 3},
+		{Model: gorm.Model{ID: 2}, Name: "Two", Email: "two@example.com", Version: 1},
+	}
+	batch := func() []model.UserUpdate {
+		return []model.UserUpdate{
+			{User: &model.User{Model: gorm.Model{ID: 1}, Name: "Uno"}, Fields: []string{"name"}},
+			{User: &model.User{Model: gorm.Model{ID: 2}, Name: "Dos", Email: "two@example.com", Version: 1}},
+			{User: &model.User{Model: gorm.Model{ID: 3}, Name: "Tres", Email: "three@example.com"}},
+			{User: &model.User{Model: gorm.Model{ID: 1}, Name: "Again"}, Fields: []string{"name"}},
+		}
+	}
+
+	// Test case: Missing users and repeated ids fail their item and abort an all or nothing batch
+	mockRepo := new(MockRepository)
+	useCase := usecase.NewUseCase(mockRepo)
+	mockRepo.On("GetUsersByIDs", []uint{1, 2, 3}).Return(stored, nil)
+	mockRepo.On("GetUsersByEmails", []string(nil)).Return([]*model.User{}, nil)
+
+	results, err := useCase.BatchUpdateUsers(ctx, batch(), model.BatchAllOrNothing)
+
+	assert.NoError(t, err)
+	assert.ErrorIs(t, results[0].Err, usecase.ErrBatchAborted)
+	assert.ErrorIs(t, results[1].Err, usecase.ErrBatchAborted)
+	assert.Equal(t, errs.NotFound, errs.CodeOf(results[2].Err))
+	assert.ErrorIs(t, results[3].Err, usecase.ErrDuplicateBatchItem)
+	mockRepo.AssertNotCalled(t, "UpdateUsers", mock.Anything)
+
+	// Test case: Best effort writes each valid update and reports the stored users
+	mockRepo.On("UpdateUser", mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("GetUsersByIDs", []uint{1, 2}).Return(stored, nil)
+
+	results, err = useCase.BatchUpdateUsers(ctx, batch(), model.BatchBestEffort)
+
+	assert.NoError(t, err)
+	assert.Equal(t, stored[0], results[0].User)
+	assert.Equal(t, stored[1], results[1].User)
+	assert.Error(t, results[2].Err)
+	mockRepo.AssertCalled(t, "UpdateUser", &model.User{Model: gorm.Model{ID: 1}, Name: "Uno"}, []string{model.UserFieldName})
+	mockRepo.AssertNumberOfCalls(t, "UpdateUser", 2)
+
+	// Test case: Stale versions and taken emails are caught before anything is written
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo)
+	mockRepo.On("GetUsersByIDs", []uint{1, 2}).Return(stored, nil)
+	mockRepo.On("GetUsersByEmails", []string{"taken@example.com"}).Return([]*model.User{{Model: gorm.Model{ID: 5}, Email: "taken@example.com"}}, nil)
+
+	results, err = useCase.BatchUpdateUsers(ctx, []model.UserUpdate{
+		{User: &model.User{Model: gorm.Model{ID: 1}, Name: "Uno", Email: "one@example.com", Version: 2}},
+		{User: &model.User{Model: gorm.Model{ID: 2}, Email: "taken@example.com"}, Fields: []string{"email"}},
+	}, model.BatchAllOrNothing)
+
+	assert.NoError(t, err)
+	assert.ErrorIs(t, results[0].Err, usecase.ErrVersionConflict)
+	assert.ErrorIs(t, results[1].Err, usecase.ErrEmailTaken)
+
+	// Test case: A race lost inside the transaction names the item and aborts the rest
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo)
+	mockRepo.On("GetUsersByIDs", []uint{1, 2}).Return(stored, nil)
+	mockRepo.On("GetUsersByEmails", []string(nil)).Return([]*model.User{}, nil)
+	mockRepo.On("UpdateUsers", mock.Anything).Return(&model.BatchItemError{Index: 1, Err: fmt.Errorf("failed to update user: %w", model.ErrVersionConflict)})
+
+	results, err = useCase.BatchUpdateUsers(ctx, batch()[:2], model.BatchAllOrNothing)
+
+	assert.NoError(t, err)
+	assert.ErrorIs(t, results[0].Err, usecase.ErrBatchAborted)
+	assert.ErrorIs(t, results[1].Err, usecase.ErrVersionConflict)
+}
+
+func TestUseCase_BatchDeleteUsers(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepository)
+	useCase := usecase.NewUseCase(mockRepo)
+	mockRepo.On("GetUsersByIDs", []uint{1, 2, 3}).Return([]*model.User{{Model: gorm.Model{ID: 1}}, {Model: gorm.Model{ID: 2}}}, nil)
+
+	// Test case: All or nothing deletes nothing when one id is bad or unknown
+	results, err := useCase.BatchDeleteUsers(ctx, []string{"1", "2", "3", "x", "1"}, model.BatchAllOrNothing)
+
+	assert.NoError(t, err)
+	assert.ErrorIs(t, results[0].Err, usecase.ErrBatchAborted)
+	assert.Equal(t, errs.NotFound, errs.CodeOf(results[2].Err))
+	assert.ErrorIs(t, results[3].Err, usecase.ErrInvalidUserID)
+	assert.ErrorIs(t, results[4].Err, usecase.ErrDuplicateBatchItem)
+	mockRepo.AssertNotCalled(t, "DeleteUsers", mock.Anything)
+
+	// Test case: Best effort deletes the existing users in one statement
+	mockRepo.On("DeleteUsers", []uint{1, 2}).Return(nil)
+
+	results, err = useCase.BatchDeleteUsers(ctx, []string{"1", "2", "3"}, model.BatchBestEffort)
+
+	assert.NoError(t, err)
+	assert.NoError(t, results[0].Err)
+	assert.NoError(t, results[1].Err)
+	assert.Error(t, results[2].Err)
+	mockRepo.AssertExpectations(t)
+}
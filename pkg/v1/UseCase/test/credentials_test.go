@@ -0,0 +1,169 @@
+package usecase_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/yishak-cs/CleanGrpc/Internal/auth"
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+	"github.com/yishak-cs/CleanGrpc/Internal/password"
+	usecase "github.com/yishak-cs/CleanGrpc/pkg/v1/UseCase"
+	"gorm.io/gorm"
+)
+
+var tokenSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func newIssuer(t *testing.T) *auth.Issuer {
+	t.Helper()
+	issuer, err := auth.NewIssuer(auth.IssuerConfig{HMACSecret: tokenSecret, AccessTTL: time.Minute})
+	if err != nil {
+		t.Fatalf("Failed to create issuer: %v", err)
+	}
+	return issuer
+}
+
+func hashed(t *testing.T, pass string) string {
+	t.Helper()
+	hash, err := password.Hash(pass)
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	return hash
+}
+
+func TestUseCase_Login(t *testing.T) {
+	ctx := context.Background()
+	hash := hashed(t, "correct horse")
+	verifier, _ := auth.NewVerifier(auth.VerifierConfig{HMACSecret: tokenSecret})
+	lockout := usecase.Lockout{Threshold: 3, Duration: time.Minute}
+
+	// Test case: The right password starts a session for the user
+	mockRepo := new(MockRepository)
+	useCase := usecase.NewUseCase(mockRepo, usecase.WithIssuer(newIssuer(t)), usecase.WithLockout(lockout))
+	mockRepo.On("GetUserByEmail", "a@example.com").Return(&model.User{Model: gorm.Model{ID: 7}, PasswordHash: hash}, nil)
+	mockRepo.On("CreateSession", mock.MatchedBy(func(s *model.Session) bool { return s.UserID == 7 })).Return(nil)
+
+	tokens, err := useCase.Login(ctx, "a@example.com", "correct horse")
+	assert.NoError(t, err)
+	principal, err := verifier.Verify(tokens.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "7", principal.Subject)
+	sessionID, _ := auth.ParseRefreshToken(tokens.RefreshToken)
+	assert.Equal(t, tokens.SessionID, sessionID)
+	mockRepo.AssertNotCalled(t, "SetLoginLock", mock.Anything, mock.Anything, mock.Anything)
+
+	// Test case: Earlier failures are forgotten after a success
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo, usecase.WithIssuer(newIssuer(t)), usecase.WithLockout(lockout))
+	expired := time.Now().Add(-time.Second)
+	mockRepo.On("GetUserByEmail", "a@example.com").Return(&model.User{Model: gorm.Model{ID: 7}, PasswordHash: hash, FailedLogins: 2, LockedUntil: &expired}, nil)
+	mockRepo.On("SetLoginLock", uint(7), 0, (*time.Time)(nil)).Return(nil)
+	mockRepo.On("CreateSession", mock.Anything).Return(nil)
+
+	_, err = useCase.Login(ctx, "a@example.com", "correct horse")
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+
+	// Test case: A wrong password is counted and the last allowed failure locks the account
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo, usecase.WithIssuer(newIssuer(t)), usecase.WithLockout(lockout))
+	mockRepo.On("GetUserByEmail", "a@example.com").Return(&model.User{Model: gorm.Model{ID: 7}, PasswordHash: hash}, nil)
+	mockRepo.On("RecordLoginFailure", uint(7)).Return(1, nil).Once()
+	mockRepo.On("RecordLoginFailure", uint(7)).Return(3, nil).Once()
+	mockRepo.On("SetLoginLock", uint(7), 0, mock.MatchedBy(func(until *time.Time) bool {
+		return until != nil && time.Until(*until) > 50*time.Second
+	})).Return(nil).Once()
+
+	_, err = useCase.Login(ctx, "a@example.com", "wrong")
+	assert.ErrorIs(t, err, usecase.ErrInvalidCredentials)
+	mockRepo.AssertNotCalled(t, "SetLoginLock", mock.Anything, mock.Anything, mock.Anything)
+	_, err = useCase.Login(ctx, "a@example.com", "wrong")
+	assert.ErrorIs(t, err, usecase.ErrInvalidCredentials)
+	mockRepo.AssertExpectations(t)
+
+	// Test case: A locked account refuses even the right password
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo, usecase.WithIssuer(newIssuer(t)), usecase.WithLockout(lockout))
+	locked := time.Now().Add(time.Minute)
+	mockRepo.On("GetUserByEmail", "a@example.com").Return(&model.User{Model: gorm.Model{ID: 7}, PasswordHash: hash, LockedUntil: &locked}, nil)
+
+	_, err = useCase.Login(ctx, "a@example.com", "correct horse")
+	assert.ErrorIs(t, err, usecase.ErrAccountLocked)
+
+	// Test case: Unknown emails and users without a password look like a wrong password
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo, usecase.WithIssuer(newIssuer(t)))
+	mockRepo.On("GetUserByEmail", "nobody@example.com").Return(nil, gorm.ErrRecordNotFound)
+	mockRepo.On("GetUserByEmail", "b@example.com").Return(&model.User{Model: gorm.Model{ID: 8}}, nil)
+	mockRepo.On("RecordLoginFailure", uint(8)).Return(1, nil)
+
+	_, err = useCase.Login(ctx, "nobody@example.com", "correct horse")
+	assert.ErrorIs(t, err, usecase.ErrInvalidCredentials)
+	_, err = useCase.Login(ctx, "b@example.com", "correct horse")
+	assert.ErrorIs(t, err, usecase.ErrInvalidCredentials)
+
+	// Test case: Pending accounts cannot log in until their address is verified
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo, usecase.WithIssuer(newIssuer(t)))
+	mockRepo.On("GetUserByEmail", "p@example.com").Return(&model.User{Model: gorm.Model{ID: 9}, PasswordHash: hash, Status: model.UserStatusPending}, nil)
+
+	_, err = useCase.Login(ctx, "p@example.com", "correct horse")
+	assert.ErrorIs(t, err, usecase.ErrEmailNotVerified)
+	mockRepo.AssertNotCalled(t, "CreateSession", mock.Anything)
+
+	// Test case: Missing input and a server without a signing key are rejected up front
+	_, err = useCase.Login(ctx, "", "correct horse")
+	assert.ErrorIs(t, err, usecase.ErrEmailRequired)
+	_, err = useCase.Login(ctx, "a@example.com", "")
+	assert.ErrorIs(t, err, usecase.ErrPasswordRequired)
+	_, err = usecase.NewUseCase(mockRepo).Login(ctx, "a@example.com", "correct horse")
+	assert.ErrorIs(t, err, usecase.ErrLoginDisabled)
+}
+
+func TestUseCase_SetPassword(t *testing.T) {
+	admin := callerContext(&auth.Principal{Subject: "1", Roles: []string{"admin"}}, auth.GrantFull)
+	owner := callerContext(&auth.Principal{Subject: "2"}, auth.GrantSelf)
+	other := callerContext(&auth.Principal{Subject: "3"}, auth.GrantSelf)
+	withPassword := &model.User{Model: gorm.Model{ID: 2}, PasswordHash: hashed(t, "old password")}
+	newHash := mock.MatchedBy(func(hash string) bool {
+		ok, _ := password.Verify(hash, "new password")
+		return ok
+	})
+
+	cases := []struct {
+		name    string
+		ctx     context.Context
+		user    *model.User
+		current string
+		next    string
+		err     error
+	}{
+		{"owner with the current password", owner, withPassword, "old password", "new password", nil},
+		{"owner with a wrong current password", owner, withPassword, "guess", "new password", usecase.ErrWrongPassword},
+		{"owner setting a first password", owner, &model.User{Model: gorm.Model{ID: 2}}, "", "new password", nil},
+		{"admin resetting a password", admin, withPassword, "", "new password", nil},
+		{"other user", other, withPassword, "old password", "new password", usecase.ErrNotOwner},
+		{"too short", owner, withPassword, "old password", "short", usecase.ErrPasswordTooShort},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(MockRepository)
+			useCase := usecase.NewUseCase(mockRepo)
+			mockRepo.On("GetUser", "2").Return(tc.user, nil)
+			mockRepo.On("SetPasswordHash", uint(2), newHash).Return(nil)
+
+			err := useCase.SetPassword(tc.ctx, "2", tc.current, tc.next)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				mockRepo.AssertNotCalled(t, "SetPasswordHash", mock.Anything, mock.Anything)
+				return
+			}
+			assert.NoError(t, err)
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
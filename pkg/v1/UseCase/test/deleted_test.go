@@ -0,0 +1,147 @@
+package usecase_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/yishak-cs/CleanGrpc/Internal/auth"
+	"github.com/yishak-cs/CleanGrpc/Internal/errs"
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+	usecase "github.com/yishak-cs/CleanGrpc/pkg/v1/UseCase"
+	"gorm.io/gorm"
+)
+
+func TestUseCase_UndeleteUser(t *testing.T) {
+	ctx := context.Background()
+	deleted := &model.User{
+		Model: gorm.Model{ID: 3, DeletedAt: gorm.DeletedAt{Time: time.Now(), Valid: true}},
+		Name:  "Gone",
+		Email: "gone@example.com",
+	}
+	live := &model.User{Model: gorm.Model{ID: 4}, Name: "Here", Email: "here@example.com"}
+
+	// Test case: A deleted user is restored and returned
+	mockRepo := new(MockRepository)
+	useCase := usecase.NewUseCase(mockRepo)
+	restored := &model.User{Model: gorm.Model{ID: 3}, Name: "Gone", Email: "gone@example.com", Version: 2}
+	mockRepo.On("GetUserIncludingDeleted", "3").Return(deleted, nil)
+	mockRepo.On("GetUserByEmail", "gone@example.com").Return(nil, gorm.ErrRecordNotFound)
+	mockRepo.On("RestoreUser", uint(3)).Return(nil)
+	mockRepo.On("GetUser", "3").Return(restored, nil)
+
+	user, err := useCase.UndeleteUser(ctx, "3")
+
+	assert.NoError(t, err)
+	assert.Equal(t, restored, user)
+	mockRepo.AssertExpectations(t)
+
+	// Test case: A live user cannot be undeleted
+	mockRepo.On("GetUserIncludingDeleted", "4").Return(live, nil)
+	_, err = useCase.UndeleteUser(ctx, "4")
+	assert.ErrorIs(t, err, usecase.ErrUserNotDeleted)
+	assert.Equal(t, errs.FailedPrecondition, errs.CodeOf(err))
+
+	// Test case: A missing user is not found
+	mockRepo.On("GetUserIncludingDeleted", "9").Return(nil, gorm.ErrRecordNotFound)
+	_, err = useCase.UndeleteUser(ctx, "9")
+	assert.Equal(t, errs.NotFound, errs.CodeOf(err))
+
+	// Test case: The email was taken by someone else since the delete
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo)
+	mockRepo.On("GetUserIncludingDeleted", "3").Return(deleted, nil)
+	mockRepo.On("GetUserByEmail", "gone@example.com").Return(live, nil)
+
+	_, err = useCase.UndeleteUser(ctx, "3")
+
+	assert.ErrorIs(t, err, usecase.ErrEmailTaken)
+	mockRepo.AssertNotCalled(t, "RestoreUser", mock.Anything)
+
+	// Test case: An invalid id never reaches the repository
+	_, err = useCase.UndeleteUser(ctx, "abc")
+	assert.ErrorIs(t, err, usecase.ErrInvalidUserID)
+}
+
+func TestUseCase_PurgeUser(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepository)
+	useCase := usecase.NewUseCase(mockRepo)
+	deleted := &model.User{Model: gorm.Model{ID: 3, DeletedAt: gorm.DeletedAt{Time: time.Now(), Valid: true}}}
+
+	// Test case: A deleted user is purged
+	mockRepo.On("GetUserIncludingDeleted", "3").Return(deleted, nil)
+	mockRepo.On("PurgeUser", uint(3)).Return(nil).Once()
+	assert.NoError(t, useCase.PurgeUser(ctx, "3"))
+
+	// Test case: A user restored in the meantime is not purged
+	mockRepo.On("PurgeUser", uint(3)).Return(gorm.ErrRecordNotFound).Once()
+	assert.ErrorIs(t, useCase.PurgeUser(ctx, "3"), usecase.ErrUserNotDeleted)
+
+	// Test case: A live user has to be deleted first
+	mockRepo.On("GetUserIncludingDeleted", "4").Return(&model.User{Model: gorm.Model{ID: 4}}, nil)
+	assert.ErrorIs(t, useCase.PurgeUser(ctx, "4"), usecase.ErrUserNotDeleted)
+	mockRepo.AssertNotCalled(t, "PurgeUser", uint(4))
+}
+
+func TestUseCase_GetUsersList_IncludeDeleted(t *testing.T) {
+	mockRepo := new(MockRepository)
+	useCase := usecase.NewUseCase(mockRepo)
+	admin := callerContext(&auth.Principal{Subject: "1", Roles: []string{"admin"}}, auth.GrantFull)
+	plain := callerContext(&auth.Principal{Subject: "2"}, auth.GrantAny)
+	self := callerContext(&auth.Principal{Subject: "2"}, auth.GrantSelf)
+	// what a caller of a method without a rule would carry if the policy granted nothing
+	ungranted := auth.WithPrincipal(context.Background(), &auth.Principal{Subject: "2"})
+	byID := model.UserOrder{Field: model.FieldID}
+	deleted := []*model.User{{Model: gorm.Model{ID: 3, DeletedAt: gorm.DeletedAt{Time: time.Now(), Valid: true}}}}
+	mockRepo.On("GetUsersList", model.UserQuery{OrderBy: byID, Limit: 11, IncludeDeleted: true}).Return(deleted, nil)
+
+	// Test case: A caller with full access lists deleted users
+	page, err := useCase.GetUsersList(admin, model.ListUsersParams{PageSize: 10, IncludeDeleted: true})
+	assert.NoError(t, err)
+	assert.Equal(t, deleted, page.Users)
+	mockRepo.AssertNumberOfCalls(t, "GetUsersList", 1)
+
+	// Test case: Plain, self limited and ungranted authenticated callers are refused before the repository is asked
+	for _, ctx := range []context.Context{plain, self, ungranted} {
+		_, err = useCase.GetUsersList(ctx, model.ListUsersParams{PageSize: 10, IncludeDeleted: true})
+		assert.ErrorIs(t, err, usecase.ErrIncludeDeletedDenied)
+		assert.Equal(t, errs.PermissionDenied, errs.CodeOf(err))
+	}
+	mockRepo.AssertNumberOfCalls(t, "GetUsersList", 1)
+
+	// Test case: Without authentication no policy is installed and deleted users are listed
+	_, err = useCase.GetUsersList(context.Background(), model.ListUsersParams{PageSize: 10, IncludeDeleted: true})
+	assert.NoError(t, err)
+	mockRepo.AssertNumberOfCalls(t, "GetUsersList", 2)
+
+	// Test case: A plain caller still lists live users
+	mockRepo.On("GetUsersList", model.UserQuery{OrderBy: byID, Limit: 11}).Return([]*model.User{}, nil)
+	_, err = useCase.GetUsersList(plain, model.ListUsersParams{PageSize: 10})
+	assert.NoError(t, err)
+}
+
+func TestUserPurger(t *testing.T) {
+	mockRepo := new(MockRepository)
+	purger := usecase.NewUserPurger(mockRepo, 24*time.Hour, time.Hour)
+
+	// Test case: Users deleted longer than the retention period ago are purged
+	beforeRetention := mock.MatchedBy(func(before time.Time) bool {
+		return time.Since(before) >= 24*time.Hour && time.Since(before) < 25*time.Hour
+	})
+	mockRepo.On("PurgeDeletedUsers", beforeRetention).Return(int64(2), nil).Once()
+	assert.Equal(t, int64(2), purger.Purge(context.Background()))
+
+	// Test case: A failing purge removes nothing and leaves the rest to the next one
+	mockRepo.On("PurgeDeletedUsers", mock.Anything).Return(int64(0), errors.New("database is locked")).Once()
+	assert.Equal(t, int64(0), purger.Purge(context.Background()))
+
+	// Test case: Run purges once right away and stops with its context
+	ctx, cancel := context.WithCancel(context.Background())
+	mockRepo.On("PurgeDeletedUsers", mock.Anything).Run(func(mock.Arguments) { cancel() }).Return(int64(0), nil).Once()
+	purger.Run(ctx)
+	mockRepo.AssertExpectations(t)
+}
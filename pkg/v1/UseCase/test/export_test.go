@@ -0,0 +1,66 @@
+package usecase_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+	usecase "github.com/yishak-cs/CleanGrpc/pkg/v1/UseCase"
+	"gorm.io/gorm"
+)
+
+func TestUseCase_ExportUsers(t *testing.T) {
+	ctx := context.Background()
+	nameDesc := model.UserOrder{Field: model.FieldName, Desc: true}
+	partners := []model.UserCondition{{Field: model.FieldEmail, Op: model.OpSuffix, Value: "@partner.com"}}
+
+	var full []*model.User
+	for i := 1000; i > 0; i-- {
+		full = append(full, &model.User{Model: gorm.Model{ID: uint(i)}, Name: fmt.Sprintf("User %04d", i), Email: fmt.Sprintf("u%d@partner.com", i)})
+	}
+	rest := []*model.User{{Model: gorm.Model{ID: 1001}, Name: "Aaron", Email: "aaron@partner.com"}}
+
+	// Test case: Full pages are followed by the next keyset window until a short one
+	mockRepo := new(MockRepository)
+	useCase := usecase.NewUseCase(mockRepo)
+	mockRepo.On("GetUsersList", model.UserQuery{Conditions: partners, OrderBy: nameDesc, Limit: 1000}).Return(full, nil)
+	mockRepo.On("GetUsersList", model.UserQuery{
+		Conditions: partners,
+		OrderBy:    nameDesc,
+		After:      &model.UserCursor{ID: 1, Value: "User 0001"},
+		Limit:      1000,
+	}).Return(rest, nil)
+
+	var exported []*model.User
+	err := useCase.ExportUsers(ctx, `email suffix "@partner.com"`, "name desc", func(user *model.User) error {
+		exported = append(exported, user)
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Len(t, exported, 1001)
+	assert.Equal(t, "Aaron", exported[1000].Name)
+	mockRepo.AssertExpectations(t)
+
+	// Test case: An error from the callback stops the export
+	stop := errors.New("disk full")
+	calls := 0
+	err = useCase.ExportUsers(ctx, `email suffix "@partner.com"`, "name desc", func(*model.User) error {
+		calls++
+		return stop
+	})
+
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 1, calls)
+
+	// Test case: Filters and orders are checked like a listing's
+	err = useCase.ExportUsers(ctx, `password = "x"`, "", func(*model.User) error { return nil })
+	assert.Error(t, err)
+	err = useCase.ExportUsers(ctx, "", "shoe_size", func(*model.User) error { return nil })
+	assert.Error(t, err)
+	mockRepo.AssertNotCalled(t, "GetUsersList", mock.MatchedBy(func(q model.UserQuery) bool { return len(q.Conditions) == 0 }))
+}
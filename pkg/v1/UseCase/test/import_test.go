@@ -0,0 +1,166 @@
+package usecase_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/yishak-cs/CleanGrpc/Internal/errs"
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+	usecase "github.com/yishak-cs/CleanGrpc/pkg/v1/UseCase"
+	"gorm.io/gorm"
+)
+
+// importRows hands out rows the way a stream would
+func importRows(rows ...model.ImportRow) func() (*model.ImportRow, error) {
+	return func() (*model.ImportRow, error) {
+		if len(rows) == 0 {
+			return nil, io.EOF
+		}
+		row := rows[0]
+		rows = rows[1:]
+		return &row, nil
+	}
+}
+
+func TestUseCase_ImportUsers(t *testing.T) {
+	ctx := context.Background()
+	rows := func() func() (*model.ImportRow, error) {
+		return importRows(
+			model.ImportRow{Line: 2, Name: " Ann ", Email: "ann@example.com "},
+			model.ImportRow{Line: 3, Name: "", Email: "nameless@example.com"},
+			model.ImportRow{Line: 4, Name: "Bob", Email: "bob@example.com"},
+			model.ImportRow{Line: 5, Name: "Ann again", Email: "ann@example.com"},
+			model.ImportRow{Line: 6, Name: "Cid", Email: "cid@example.com"},
+		)
+	}
+	bob := &model.User{Model: gorm.Model{ID: 7}, Name: "Robert", Email: "bob@example.com"}
+	newUsers := func(emails ...string) interface{} {
+		return mock.MatchedBy(func(users []*model.User) bool {
+			if len(users) != len(emails) {
+				return false
+			}
+			for i, user := range users {
+				if user.Email != emails[i] {
+					return false
+				}
+			}
+			return true
+		})
+	}
+
+	// Test case: Rows are trimmed and validated, repeated emails rejected and taken ones skipped
+	mockRepo := new(MockRepository)
+	useCase := usecase.NewUseCase(mockRepo)
+	mockRepo.On("GetUsersByEmails", []string{"ann@example.com", "bob@example.com", "cid@example.com"}).Return([]*model.User{bob}, nil)
+	mockRepo.On("CreateUsers", newUsers("ann@example.com", "cid@example.com")).Return(nil)
+
+	report, err := useCase.ImportUsers(ctx, rows(), model.ConflictSkip)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2, 6}, report.Created)
+	assert.Equal(t, []int64{4}, report.Skipped)
+	assert.Empty(t, report.Updated)
+	if assert.Len(t, report.Rejected, 2) {
+		assert.Equal(t, int64(3), report.Rejected[0].Line)
+		assert.ErrorIs(t, report.Rejected[0].Err, usecase.ErrNameRequired)
+		assert.Equal(t, int64(5), report.Rejected[1].Line)
+		assert.ErrorIs(t, report.Rejected[1].Err, usecase.ErrDuplicateImportEmail)
+	}
+	assert.False(t, report.Aborted)
+
+	// Test case: Overwrite gives the existing user the name from the row
+	mockRepo.On("UpdateUsers", []model.UserUpdate{
+		{User: &model.User{Model: gorm.Model{ID: 7}, Name: "Bob"}, Fields: []string{model.UserFieldName}},
+	}).Return(nil)
+
+	report, err = useCase.ImportUsers(ctx, rows(), model.ConflictOverwrite)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{4}, report.Updated)
+	assert.Empty(t, report.Skipped)
+
+	// Test case: Fail stops at the conflict, only the rows before it are written
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo)
+	mockRepo.On("GetUsersByEmails", mock.Anything).Return([]*model.User{bob}, nil)
+	mockRepo.On("CreateUsers", newUsers("ann@example.com")).Return(nil)
+
+	report, err = useCase.ImportUsers(ctx, rows(), model.ConflictFail)
+
+	assert.NoError(t, err)
+	assert.True(t, report.Aborted)
+	assert.Equal(t, []int64{2}, report.Created)
+	if assert.Len(t, report.Rejected, 2) {
+		assert.Equal(t, int64(3), report.Rejected[0].Line)
+		assert.Equal(t, int64(4), report.Rejected[1].Line)
+		assert.ErrorIs(t, report.Rejected[1].Err, usecase.ErrEmailTaken)
+	}
+	mockRepo.AssertNotCalled(t, "UpdateUsers", mock.Anything)
+
+	// Test case: Large imports are written in chunks
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo)
+	mockRepo.On("GetUsersByEmails", mock.Anything).Return([]*model.User{}, nil)
+	mockRepo.On("CreateUsers", mock.Anything).Return(nil)
+	var many []model.ImportRow
+	for i := 0; i < 1200; i++ {
+		many = append(many, model.ImportRow{Line: int64(i + 1), Name: "User", Email: fmt.Sprintf("user%d@example.com", i)})
+	}
+
+	report, err = useCase.ImportUsers(ctx, importRows(many...), model.ConflictSkip)
+
+	assert.NoError(t, err)
+	assert.Len(t, report.Created, 1200)
+	mockRepo.AssertNumberOfCalls(t, "CreateUsers", 3)
+
+	// Test case: A chunk that fails to write rejects its rows and aborts, earlier chunks stay imported
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo)
+	mockRepo.On("GetUsersByEmails", mock.Anything).Return([]*model.User{}, nil)
+	mockRepo.On("CreateUsers", mock.Anything).Return(nil).Once()
+	mockRepo.On("CreateUsers", mock.Anything).Return(errors.New("UNIQUE constraint failed: users.email")).Once()
+
+	report, err = useCase.ImportUsers(ctx, importRows(many...), model.ConflictSkip)
+
+	assert.NoError(t, err)
+	assert.True(t, report.Aborted)
+	assert.Len(t, report.Created, 500)
+	if assert.Len(t, report.Rejected, 500) {
+		assert.Equal(t, int64(501), report.Rejected[0].Line)
+		assert.Equal(t, "user500@example.com", report.Rejected[0].Email)
+		assert.ErrorContains(t, report.Rejected[0].Err, "UNIQUE constraint failed")
+		assert.Equal(t, int64(1000), report.Rejected[499].Line)
+	}
+	mockRepo.AssertNumberOfCalls(t, "CreateUsers", 2)
+
+	// Test case: A failed overwrite names the row that failed, the others were rolled back with it
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo)
+	cid := &model.User{Model: gorm.Model{ID: 8}, Name: "Cid", Email: "cid@example.com"}
+	mockRepo.On("GetUsersByEmails", mock.Anything).Return([]*model.User{bob, cid}, nil)
+	mockRepo.On("CreateUsers", newUsers("ann@example.com")).Return(nil)
+	mockRepo.On("UpdateUsers", mock.Anything).Return(&model.BatchItemError{Index: 1, Err: gorm.ErrRecordNotFound})
+
+	report, err = useCase.ImportUsers(ctx, rows(), model.ConflictOverwrite)
+
+	assert.NoError(t, err)
+	assert.True(t, report.Aborted)
+	assert.Equal(t, []int64{2}, report.Created)
+	assert.Empty(t, report.Updated)
+	if assert.Len(t, report.Rejected, 4) {
+		assert.Equal(t, int64(4), report.Rejected[2].Line)
+		assert.ErrorIs(t, report.Rejected[2].Err, usecase.ErrBatchAborted)
+		assert.Equal(t, int64(6), report.Rejected[3].Line)
+		assert.Equal(t, errs.NotFound, errs.CodeOf(report.Rejected[3].Err))
+	}
+
+	// Test case: A broken stream fails the import
+	broken := func() (*model.ImportRow, error) { return nil, errors.New("connection reset") }
+	_, err = useCase.ImportUsers(ctx, broken, model.ConflictSkip)
+	assert.ErrorContains(t, err, "connection reset")
+}
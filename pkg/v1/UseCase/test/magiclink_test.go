@@ -0,0 +1,109 @@
+package usecase_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/yishak-cs/CleanGrpc/Internal/auth"
+	"github.com/yishak-cs/CleanGrpc/Internal/errs"
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+	usecase "github.com/yishak-cs/CleanGrpc/pkg/v1/UseCase"
+	"gorm.io/gorm"
+)
+
+func TestUseCase_RequestMagicLink(t *testing.T) {
+	ctx := context.Background()
+	magicLink := usecase.MagicLink{URL: "https://example.com/signin?token={token}", TTL: 10 * time.Minute, Limit: 2, Window: time.Hour}
+
+	// Test case: A known address is mailed a link to a stored single-use token
+	mailer := &fakeMailer{}
+	mockRepo := new(MockRepository)
+	useCase := usecase.NewUseCase(mockRepo, usecase.WithIssuer(newIssuer(t)), usecase.WithMailer(mailer), usecase.WithMagicLink(magicLink))
+	stored := make(chan *model.MagicLinkToken, 1)
+	mockRepo.On("GetUserByEmail", "a@example.com").Return(&model.User{Model: gorm.Model{ID: 7}, Name: "A", Email: "a@example.com"}, nil)
+	mockRepo.On("ReplaceMagicLinkToken", mock.Anything).Run(func(args mock.Arguments) {
+		stored <- args.Get(0).(*model.MagicLinkToken)
+	}).Return(nil)
+
+	assert.NoError(t, useCase.RequestMagicLink(ctx, "a@example.com"))
+	record := <-stored
+	assert.Equal(t, uint(7), record.UserID)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), record.ExpiresAt, time.Second)
+	assert.Eventually(t, func() bool { return len(mailer.messages()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	sent := mailer.messages()[0]
+	assert.Equal(t, "a@example.com", sent.To)
+	token := mailedToken(t, sent.Body, record.Hash)
+	assert.Contains(t, sent.Body, "https://example.com/signin?token="+token)
+
+	// Test case: Unknown addresses get the same answer and no mail
+	mockRepo.On("GetUserByEmail", "nobody@example.com").Return(nil, gorm.ErrRecordNotFound)
+	assert.NoError(t, useCase.RequestMagicLink(ctx, "nobody@example.com"))
+	assert.NoError(t, useCase.RequestMagicLink(ctx, "nobody@example.com"))
+
+	// Test case: The limit applies per address, known or not, ignoring case
+	err := useCase.RequestMagicLink(ctx, "Nobody@Example.com ")
+	assert.ErrorIs(t, err, usecase.ErrTooManyMagicLinks)
+	assert.Equal(t, errs.ResourceExhausted, errs.CodeOf(err))
+	assert.NoError(t, useCase.RequestMagicLink(ctx, "a@example.com"))
+	<-stored
+	assert.ErrorIs(t, useCase.RequestMagicLink(ctx, "a@example.com"), usecase.ErrTooManyMagicLinks)
+
+	// Test case: Wait returns once the links sent in the background are out
+	useCase.Wait()
+	assert.Len(t, mailer.messages(), 2)
+
+	// Test case: Without a mailer or a signing key there are no links
+	assert.ErrorIs(t, usecase.NewUseCase(mockRepo, usecase.WithIssuer(newIssuer(t))).RequestMagicLink(ctx, "a@example.com"), usecase.ErrMagicLinkDisabled)
+	assert.ErrorIs(t, usecase.NewUseCase(mockRepo, usecase.WithMailer(mailer)).RequestMagicLink(ctx, "a@example.com"), usecase.ErrMagicLinkDisabled)
+	assert.ErrorIs(t, useCase.RequestMagicLink(ctx, ""), usecase.ErrEmailRequired)
+}
+
+func TestUseCase_RedeemMagicLink(t *testing.T) {
+	ctx := context.Background()
+	token, hash, _ := auth.NewEmailToken()
+	record := func(expires time.Time) *model.MagicLinkToken {
+		return &model.MagicLinkToken{Hash: hash, UserID: 7, Email: "a@example.com", ExpiresAt: expires}
+	}
+
+	// Test case: A live link starts a session and activates a pending account
+	mockRepo := new(MockRepository)
+	useCase := usecase.NewUseCase(mockRepo, usecase.WithIssuer(newIssuer(t)))
+	mockRepo.On("ConsumeMagicLinkToken", hash).Return(record(time.Now().Add(time.Minute)), nil)
+	mockRepo.On("GetUser", "7").Return(&model.User{Model: gorm.Model{ID: 7}, Email: "a@example.com", Status: model.UserStatusPending}, nil)
+	mockRepo.On("SetUserStatus", uint(7), model.UserStatusActive).Return(nil)
+	mockRepo.On("CreateSession", mock.MatchedBy(func(s *model.Session) bool { return s.UserID == 7 })).Return(nil)
+
+	tokens, err := useCase.RedeemMagicLink(ctx, token)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, tokens.AccessToken)
+	mockRepo.AssertExpectations(t)
+
+	// Test case: Used, expired and outdated links are refused
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo, usecase.WithIssuer(newIssuer(t)))
+	mockRepo.On("ConsumeMagicLinkToken", hash).Return(nil, gorm.ErrRecordNotFound).Once()
+	_, err = useCase.RedeemMagicLink(ctx, token)
+	assert.ErrorIs(t, err, usecase.ErrInvalidMagicLink)
+	assert.Equal(t, errs.Unauthenticated, errs.CodeOf(err))
+
+	mockRepo.On("ConsumeMagicLinkToken", hash).Return(record(time.Now().Add(-time.Second)), nil).Once()
+	_, err = useCase.RedeemMagicLink(ctx, token)
+	assert.ErrorIs(t, err, usecase.ErrInvalidMagicLink)
+
+	mockRepo.On("ConsumeMagicLinkToken", hash).Return(record(time.Now().Add(time.Minute)), nil).Once()
+	mockRepo.On("GetUser", "7").Return(&model.User{Model: gorm.Model{ID: 7}, Email: "changed@example.com"}, nil).Once()
+	_, err = useCase.RedeemMagicLink(ctx, token)
+	assert.ErrorIs(t, err, usecase.ErrInvalidMagicLink)
+	mockRepo.AssertNotCalled(t, "CreateSession", mock.Anything)
+
+	// Test case: Links are only looked for among magic links, never among email tokens
+	mockRepo.AssertNotCalled(t, "ConsumeEmailToken", mock.Anything, mock.Anything)
+
+	_, err = useCase.RedeemMagicLink(ctx, "")
+	assert.ErrorIs(t, err, usecase.ErrInvalidMagicLink)
+	_, err = usecase.NewUseCase(mockRepo).RedeemMagicLink(ctx, token)
+	assert.ErrorIs(t, err, usecase.ErrLoginDisabled)
+}
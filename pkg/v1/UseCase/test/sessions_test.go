@@ -0,0 +1,220 @@
+package usecase_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/yishak-cs/CleanGrpc/Internal/auth"
+	"github.com/yishak-cs/CleanGrpc/Internal/errs"
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+	usecase "github.com/yishak-cs/CleanGrpc/pkg/v1/UseCase"
+	"gorm.io/gorm"
+)
+
+const sessionID = "0b5cbd8e-5a8a-4b8f-9d3e-4c0f6a1f2e3d"
+
+// liveSession returns a session of user 7 and its current refresh token
+func liveSession(t *testing.T) (*model.Session, string) {
+	t.Helper()
+	token, hash, err := auth.NewRefreshToken(sessionID)
+	if err != nil {
+		t.Fatalf("Failed to create refresh token: %v", err)
+	}
+	return &model.Session{ID: sessionID, UserID: 7, TokenHash: hash, ExpiresAt: time.Now().Add(time.Hour)}, token
+}
+
+func TestUseCase_IssueSession(t *testing.T) {
+	mockRepo := new(MockRepository)
+	useCase := usecase.NewUseCase(mockRepo, usecase.WithIssuer(newIssuer(t)), usecase.WithSessionTTL(time.Hour))
+
+	// Test case: A session is stored with the hash of the refresh token handed out
+	var stored *model.Session
+	mockRepo.On("GetUser", "7").Return(&model.User{Model: gorm.Model{ID: 7}}, nil)
+	mockRepo.On("CreateSession", mock.Anything).Run(func(args mock.Arguments) {
+		stored = args.Get(0).(*model.Session)
+	}).Return(nil)
+
+	tokens, err := useCase.IssueSession(context.Background(), "7")
+	assert.NoError(t, err)
+	assert.Equal(t, uint(7), stored.UserID)
+	assert.Equal(t, stored.ID, tokens.SessionID)
+	assert.Equal(t, auth.HashRefreshToken(tokens.RefreshToken), stored.TokenHash)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), stored.ExpiresAt, time.Second)
+
+	// Test case: Only the owner or an admin may start a session for a user
+	_, err = useCase.IssueSession(callerContext(&auth.Principal{Subject: "8"}, auth.GrantSelf), "7")
+	assert.ErrorIs(t, err, usecase.ErrNotOwner)
+
+	// Test case: Unknown users get no session
+	mockRepo.On("GetUser", "9").Return(nil, gorm.ErrRecordNotFound)
+	_, err = useCase.IssueSession(context.Background(), "9")
+	assert.Equal(t, errs.NotFound, errs.CodeOf(err))
+
+	// Test case: Users with an unverified email get no session, as with Login
+	mockRepo.On("GetUser", "10").Return(&model.User{Model: gorm.Model{ID: 10}, Status: model.UserStatusPending}, nil)
+	_, err = useCase.IssueSession(context.Background(), "10")
+	assert.ErrorIs(t, err, usecase.ErrEmailNotVerified)
+	mockRepo.AssertNumberOfCalls(t, "CreateSession", 1)
+}
+
+func TestUseCase_RefreshSession(t *testing.T) {
+	ctx := context.Background()
+	user := &model.User{Model: gorm.Model{ID: 7}}
+
+	// Test case: A refresh rotates the token and extends the session
+	session, token := liveSession(t)
+	mockRepo := new(MockRepository)
+	useCase := usecase.NewUseCase(mockRepo, usecase.WithIssuer(newIssuer(t)), usecase.WithSessionTTL(time.Hour))
+	mockRepo.On("GetSession", sessionID).Return(session, nil)
+	mockRepo.On("GetUser", "7").Return(user, nil)
+	var nextHash string
+	mockRepo.On("RotateSession", sessionID, session.TokenHash, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		nextHash = args.String(2)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), args.Get(3).(time.Time), time.Second)
+	}).Return(true, nil)
+
+	tokens, err := useCase.RefreshSession(ctx, token)
+	assert.NoError(t, err)
+	assert.NotEqual(t, token, tokens.RefreshToken)
+	assert.Equal(t, auth.HashRefreshToken(tokens.RefreshToken), nextHash)
+	assert.Equal(t, sessionID, tokens.SessionID)
+
+	// Test case: Presenting an already exchanged token revokes the session
+	session, _ = liveSession(t)
+	// a token of the same session that is no longer the current one
+	staleToken, staleHash, _ := auth.NewRefreshToken(sessionID)
+	session.PreviousTokenHash = staleHash
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo, usecase.WithIssuer(newIssuer(t)))
+	mockRepo.On("GetSession", sessionID).Return(session, nil)
+	mockRepo.On("DeleteSession", sessionID).Return(nil)
+
+	_, err = useCase.RefreshSession(ctx, staleToken)
+	assert.ErrorIs(t, err, usecase.ErrRefreshTokenReused)
+	mockRepo.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "RotateSession", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	// Test case: A forged secret for a known session id leaves the session alive
+	session, _ = liveSession(t)
+	session.PreviousTokenHash = staleHash
+	forged, _, _ := auth.NewRefreshToken(sessionID)
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo, usecase.WithIssuer(newIssuer(t)))
+	mockRepo.On("GetSession", sessionID).Return(session, nil)
+
+	_, err = useCase.RefreshSession(ctx, forged)
+	assert.ErrorIs(t, err, usecase.ErrInvalidRefreshToken)
+	mockRepo.AssertNotCalled(t, "DeleteSession", mock.Anything)
+	mockRepo.AssertNotCalled(t, "RotateSession", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	// Test case: Losing a race to rotate the same token counts as reuse
+	session, token = liveSession(t)
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo, usecase.WithIssuer(newIssuer(t)))
+	mockRepo.On("GetSession", sessionID).Return(session, nil)
+	mockRepo.On("GetUser", "7").Return(user, nil)
+	mockRepo.On("RotateSession", sessionID, session.TokenHash, mock.Anything, mock.Anything).Return(false, nil)
+	mockRepo.On("DeleteSession", sessionID).Return(nil)
+
+	_, err = useCase.RefreshSession(ctx, token)
+	assert.ErrorIs(t, err, usecase.ErrRefreshTokenReused)
+	mockRepo.AssertExpectations(t)
+
+	// Test case: Expired sessions, deleted users, unknown sessions and garbage are refused
+	session, token = liveSession(t)
+	session.ExpiresAt = time.Now().Add(-time.Second)
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo, usecase.WithIssuer(newIssuer(t)))
+	mockRepo.On("GetSession", sessionID).Return(session, nil)
+	_, err = useCase.RefreshSession(ctx, token)
+	assert.ErrorIs(t, err, usecase.ErrInvalidRefreshToken)
+
+	session, token = liveSession(t)
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo, usecase.WithIssuer(newIssuer(t)))
+	mockRepo.On("GetSession", sessionID).Return(session, nil)
+	mockRepo.On("GetUser", "7").Return(nil, gorm.ErrRecordNotFound)
+	mockRepo.On("DeleteSession", sessionID).Return(nil)
+	_, err = useCase.RefreshSession(ctx, token)
+	assert.ErrorIs(t, err, usecase.ErrInvalidRefreshToken)
+	mockRepo.AssertExpectations(t)
+
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo, usecase.WithIssuer(newIssuer(t)))
+	mockRepo.On("GetSession", sessionID).Return(nil, gorm.ErrRecordNotFound)
+	_, err = useCase.RefreshSession(ctx, token)
+	assert.ErrorIs(t, err, usecase.ErrInvalidRefreshToken)
+	_, err = useCase.RefreshSession(ctx, "garbage")
+	assert.ErrorIs(t, err, usecase.ErrInvalidRefreshToken)
+}
+
+func TestUseCase_RevokeSession(t *testing.T) {
+	owner := callerContext(&auth.Principal{Subject: "7"}, auth.GrantSelf)
+	other := callerContext(&auth.Principal{Subject: "8"}, auth.GrantSelf)
+	session, _ := liveSession(t)
+
+	// Test case: The owner revokes their session
+	mockRepo := new(MockRepository)
+	useCase := usecase.NewUseCase(mockRepo)
+	mockRepo.On("GetSession", sessionID).Return(session, nil)
+	mockRepo.On("DeleteSession", sessionID).Return(nil)
+
+	assert.NoError(t, useCase.RevokeSession(owner, "7", sessionID))
+	mockRepo.AssertExpectations(t)
+
+	// Test case: Another user's session is not found, even under their own user ID
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo)
+	mockRepo.On("GetSession", sessionID).Return(session, nil)
+
+	err := useCase.RevokeSession(other, "8", sessionID)
+	assert.Equal(t, errs.NotFound, errs.CodeOf(err))
+	assert.ErrorIs(t, useCase.RevokeSession(other, "7", sessionID), usecase.ErrNotOwner)
+	mockRepo.AssertNotCalled(t, "DeleteSession", sessionID)
+
+	// Test case: Listing is limited to the owner too
+	mockRepo.On("ListSessions", uint(7)).Return([]*model.Session{session}, nil)
+	sessions, err := useCase.ListSessions(owner, "7")
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.Session{session}, sessions)
+	_, err = useCase.ListSessions(other, "7")
+	assert.ErrorIs(t, err, usecase.ErrNotOwner)
+}
+
+func TestSessionSweeper(t *testing.T) {
+	mockRepo := new(MockRepository)
+	sweeper := usecase.NewSessionSweeper(mockRepo, time.Hour)
+
+	// Test case: A sweep reports how many sessions, email tokens and magic links it deleted
+	mockRepo.On("DeleteExpiredSessions", mock.Anything).Return(int64(3), nil).Once()
+	mockRepo.On("DeleteExpiredEmailTokens", mock.Anything).Return(int64(2), nil).Once()
+	mockRepo.On("DeleteExpiredMagicLinkTokens", mock.Anything).Return(int64(4), nil).Once()
+	assert.Equal(t, int64(9), sweeper.Sweep(context.Background()))
+
+	// Test case: A failing sweep deletes nothing and leaves the rest to the next one
+	mockRepo.On("DeleteExpiredSessions", mock.Anything).Return(int64(0), errors.New("database is locked")).Once()
+	mockRepo.On("DeleteExpiredEmailTokens", mock.Anything).Return(int64(1), nil).Once()
+	mockRepo.On("DeleteExpiredMagicLinkTokens", mock.Anything).Return(int64(0), nil).Once()
+	assert.Equal(t, int64(1), sweeper.Sweep(context.Background()))
+
+	// Test case: Run sweeps once right away and stops with its context
+	ctx, cancel := context.WithCancel(context.Background())
+	mockRepo.On("DeleteExpiredSessions", mock.Anything).Run(func(mock.Arguments) { cancel() }).Return(int64(0), nil).Once()
+	mockRepo.On("DeleteExpiredEmailTokens", mock.Anything).Return(int64(0), nil).Once()
+	mockRepo.On("DeleteExpiredMagicLinkTokens", mock.Anything).Return(int64(0), nil).Once()
+	done := make(chan struct{})
+	go func() {
+		sweeper.Run(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after its context was cancelled")
+	}
+	mockRepo.AssertExpectations(t)
+}
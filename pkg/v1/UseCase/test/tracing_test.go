@@ -0,0 +1,85 @@
+package usecase_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+	usecase "github.com/yishak-cs/CleanGrpc/pkg/v1/UseCase"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gorm.io/gorm"
+)
+
+// spans records what the use case exports through the global provider
+var spans = tracetest.NewInMemoryExporter()
+
+func init() {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans)))
+}
+
+// spanNamed returns the first recorded span called name
+func spanNamed(t *testing.T, name string) tracetest.SpanStub {
+	t.Helper()
+	for _, span := range spans.GetSpans() {
+		if span.Name == name {
+			return span
+		}
+	}
+	t.Fatalf("no span named %s in %v", name, spans.GetSpans().Snapshots())
+	return tracetest.SpanStub{}
+}
+
+func TestUseCase_Tracing(t *testing.T) {
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "handler")
+	defer parent.End()
+
+	// Test case: CreateUser is a child of the caller and the duplicate check a child of it
+	spans.Reset()
+	mockRepo := new(MockRepository)
+	useCase := usecase.NewUseCase(mockRepo)
+	user := &model.User{Name: "Test User", Email: "test@example.com"}
+	mockRepo.On("GetUserByEmail", user.Email).Return(nil, gorm.ErrRecordNotFound)
+	mockRepo.On("CreateUser", user).Return(&model.User{Model: gorm.Model{ID: 1}}, nil)
+
+	_, err := useCase.CreateUser(ctx, user)
+	assert.NoError(t, err)
+
+	create := spanNamed(t, "UseCase.CreateUser")
+	check := spanNamed(t, "UseCase.checkEmailAvailable")
+	assert.Equal(t, parent.SpanContext().SpanID(), create.Parent.SpanID())
+	assert.Equal(t, create.SpanContext.SpanID(), check.Parent.SpanID())
+	assert.Equal(t, codes.Unset, create.Status.Code)
+	assert.Equal(t, codes.Unset, check.Status.Code)
+
+	// Test case: Errors are recorded on the span that returned them
+	spans.Reset()
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo)
+	mockRepo.On("GetUser", "999").Return(nil, gorm.ErrRecordNotFound)
+
+	_, err = useCase.GetUser(ctx, "999")
+	assert.Error(t, err)
+
+	get := spanNamed(t, "UseCase.GetUser")
+	assert.Equal(t, codes.Error, get.Status.Code)
+	assert.Equal(t, err.Error(), get.Status.Description)
+	if assert.Len(t, get.Events, 1) {
+		assert.Equal(t, "exception", get.Events[0].Name)
+	}
+
+	// Test case: Streams report how many users they handed out
+	spans.Reset()
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo)
+	mockRepo.On("StreamUsers", mock.Anything).Return([]*model.User{{Name: "A"}, {Name: "B"}}, nil)
+
+	assert.NoError(t, useCase.StreamUsers(ctx, func(*model.User) error { return nil }))
+	stream := spanNamed(t, "UseCase.StreamUsers")
+	assert.Contains(t, stream.Attributes, attribute.Int("users.streamed", 2))
+}
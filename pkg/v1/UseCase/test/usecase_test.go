@@ -1,27 +1,36 @@
 package usecase_test
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/yishak-cs/CleanGrpc/Internal/errs"
 	"github.com/yishak-cs/CleanGrpc/Internal/model"
 	usecase "github.com/yishak-cs/CleanGrpc/pkg/v1/UseCase"
 	"gorm.io/gorm"
 )
 
-// MockRepository is a mock implementation of the RepoInterface
+// default order of a listing
+var idOrder = model.UserOrder{Field: model.FieldID}
+
+// MockRepository is a mock implementation of the RepoInterface. the context
+// argument is not recorded so expectations only list the remaining ones
 type MockRepository struct {
 	mock.Mock
 }
 
-func (m *MockRepository) CreateUser(user *model.User) (*model.User, error) {
+func (m *MockRepository) CreateUser(_ context.Context, user *model.User) (*model.User, error) {
 	args := m.Called(user)
 	return args.Get(0).(*model.User), args.Error(1)
 }
 
-func (m *MockRepository) GetUser(id string) (*model.User, error) {
+func (m *MockRepository) GetUser(_ context.Context, id string) (*model.User, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -29,7 +38,7 @@ func (m *MockRepository) GetUser(id string) (*model.User, error) {
 	return args.Get(0).(*model.User), args.Error(1)
 }
 
-func (m *MockRepository) GetUserByEmail(email string) (*model.User, error) {
+func (m *MockRepository) GetUserByEmail(_ context.Context, email string) (*model.User, error) {
 	args := m.Called(email)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -37,24 +46,192 @@ func (m *MockRepository) GetUserByEmail(email string) (*model.User, error) {
 	return args.Get(0).(*model.User), args.Error(1)
 }
 
-func (m *MockRepository) GetUsersList() []*model.User {
-	args := m.Called()
-	return args.Get(0).([]*model.User)
+func (m *MockRepository) GetUsersList(_ context.Context, query model.UserQuery) ([]*model.User, error) {
+	args := m.Called(query)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*model.User), args.Error(1)
 }
 
-func (m *MockRepository) UpdateUser(user *model.User) error {
-	args := m.Called(user)
+func (m *MockRepository) StreamUsers(_ context.Context, fn func(*model.User) error) error {
+	args := m.Called(mock.Anything)
+	for _, user := range args.Get(0).([]*model.User) {
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
+}
+
+func (m *MockRepository) SearchUsers(_ context.Context, match string, limit int) ([]*model.UserSearchResult, error) {
+	args := m.Called(match, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*model.UserSearchResult), args.Error(1)
+}
+
+func (m *MockRepository) UpdateUser(_ context.Context, user *model.User, fields []string) error {
+	args := m.Called(user, fields)
+	return args.Error(0)
+}
+
+func (m *MockRepository) CreateUsers(_ context.Context, users []*model.User) error {
+	args := m.Called(users)
+	return args.Error(0)
+}
+
+func (m *MockRepository) UpdateUsers(_ context.Context, updates []model.UserUpdate) error {
+	args := m.Called(updates)
+	return args.Error(0)
+}
+
+func (m *MockRepository) DeleteUsers(_ context.Context, ids []uint) error {
+	args := m.Called(ids)
 	return args.Error(0)
 }
 
-func (m *MockRepository) DeleteUser(id string) error {
+func (m *MockRepository) GetUsersByIDs(_ context.Context, ids []uint) ([]*model.User, error) {
+	args := m.Called(ids)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*model.User), args.Error(1)
+}
+
+func (m *MockRepository) GetUsersByEmails(_ context.Context, emails []string) ([]*model.User, error) {
+	args := m.Called(emails)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*model.User), args.Error(1)
+}
+
+func (m *MockRepository) SetUserStatus(_ context.Context, id uint, status string) error {
+	args := m.Called(id, status)
+	return args.Error(0)
+}
+
+func (m *MockRepository) DeleteUser(_ context.Context, id string) error {
 	args := m.Called(id)
 	return args.Error(0)
 }
 
+func (m *MockRepository) GetUserIncludingDeleted(_ context.Context, id string) (*model.User, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.User), args.Error(1)
+}
+
+func (m *MockRepository) RestoreUser(_ context.Context, id uint) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockRepository) PurgeUser(_ context.Context, id uint) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockRepository) PurgeDeletedUsers(_ context.Context, before time.Time) (int64, error) {
+	args := m.Called(before)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepository) SetPasswordHash(_ context.Context, id uint, hash string) error {
+	args := m.Called(id, hash)
+	return args.Error(0)
+}
+
+func (m *MockRepository) RecordLoginFailure(_ context.Context, id uint) (int, error) {
+	args := m.Called(id)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockRepository) SetLoginLock(_ context.Context, id uint, failures int, lockedUntil *time.Time) error {
+	args := m.Called(id, failures, lockedUntil)
+	return args.Error(0)
+}
+
+func (m *MockRepository) CreateSession(_ context.Context, session *model.Session) error {
+	args := m.Called(session)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetSession(_ context.Context, id string) (*model.Session, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Session), args.Error(1)
+}
+
+func (m *MockRepository) ListSessions(_ context.Context, userID uint, now time.Time) ([]*model.Session, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*model.Session), args.Error(1)
+}
+
+func (m *MockRepository) RotateSession(_ context.Context, id, oldHash, newHash string, usedAt, expiresAt time.Time) (bool, error) {
+	args := m.Called(id, oldHash, newHash, expiresAt)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRepository) DeleteSession(_ context.Context, id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockRepository) DeleteExpiredSessions(_ context.Context, now time.Time) (int64, error) {
+	args := m.Called(mock.Anything)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepository) ReplaceEmailToken(_ context.Context, token *model.EmailToken) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *MockRepository) ConsumeEmailToken(_ context.Context, hash, purpose string) (*model.EmailToken, error) {
+	args := m.Called(hash, purpose)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.EmailToken), args.Error(1)
+}
+
+func (m *MockRepository) DeleteExpiredEmailTokens(_ context.Context, now time.Time) (int64, error) {
+	args := m.Called(mock.Anything)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepository) ReplaceMagicLinkToken(_ context.Context, token *model.MagicLinkToken) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *MockRepository) ConsumeMagicLinkToken(_ context.Context, hash string) (*model.MagicLinkToken, error) {
+	args := m.Called(hash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.MagicLinkToken), args.Error(1)
+}
+
+func (m *MockRepository) DeleteExpiredMagicLinkTokens(_ context.Context, now time.Time) (int64, error) {
+	args := m.Called(mock.Anything)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 func TestUseCase_CreateUser(t *testing.T) {
 	mockRepo := new(MockRepository)
 	useCase := usecase.NewUseCase(mockRepo)
+	ctx := context.Background()
 
 	// Test case: Create a new user successfully
 	user := &model.User{
@@ -73,7 +250,7 @@ func TestUseCase_CreateUser(t *testing.T) {
 	mockRepo.On("CreateUser", user).Return(expectedUser, nil)
 
 	// Call the method
-	createdUser, err := useCase.CreateUser(user)
+	createdUser, err := useCase.CreateUser(ctx, user)
 
 	// Assertions
 	assert.NoError(t, err)
@@ -91,17 +268,35 @@ func TestUseCase_CreateUser(t *testing.T) {
 	mockRepo.On("GetUserByEmail", existingUser.Email).Return(existingUser, nil)
 
 	// Call the method
-	_, err = useCase.CreateUser(existingUser)
+	_, err = useCase.CreateUser(ctx, existingUser)
 
 	// Assertions
 	assert.Error(t, err)
-	assert.Equal(t, gorm.ErrDuplicatedKey, err)
+	assert.ErrorIs(t, err, usecase.ErrEmailTaken)
+	assert.Equal(t, errs.AlreadyExists, errs.CodeOf(err))
+	mockRepo.AssertExpectations(t)
+
+	// Test case: Lookup failures other than not found are not reported as duplicates
+	mockRepo.ExpectedCalls = nil
+	mockRepo.On("GetUserByEmail", "new@example.com").Return(nil, errors.New("database error"))
+
+	_, err = useCase.CreateUser(ctx, &model.User{Name: "New User", Email: "new@example.com"})
+
+	assert.Error(t, err)
+	assert.Equal(t, errs.Unknown, errs.CodeOf(err))
 	mockRepo.AssertExpectations(t)
+
+	// Test case: Missing fields are rejected before touching the repository
+	_, err = useCase.CreateUser(ctx, &model.User{Email: "noname@example.com"})
+
+	assert.ErrorIs(t, err, usecase.ErrNameRequired)
+	assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err))
 }
 
 func TestUseCase_GetUser(t *testing.T) {
 	mockRepo := new(MockRepository)
 	useCase := usecase.NewUseCase(mockRepo)
+	ctx := context.Background()
 
 	// Test case: Get existing user
 	expectedUser := &model.User{
@@ -113,7 +308,7 @@ func TestUseCase_GetUser(t *testing.T) {
 	mockRepo.On("GetUser", "1").Return(expectedUser, nil)
 
 	// Call the method
-	user, err := useCase.GetUser("1")
+	user, err := useCase.GetUser(ctx, "1")
 
 	// Assertions
 	assert.NoError(t, err)
@@ -125,17 +320,27 @@ func TestUseCase_GetUser(t *testing.T) {
 	mockRepo.On("GetUser", "999").Return(nil, gorm.ErrRecordNotFound)
 
 	// Call the method
-	_, err = useCase.GetUser("999")
+	_, err = useCase.GetUser(ctx, "999")
 
 	// Assertions
 	assert.Error(t, err)
 	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	assert.Equal(t, errs.NotFound, errs.CodeOf(err))
 	mockRepo.AssertExpectations(t)
+
+	// Test case: Ids that are not primary keys never reach the repository
+	mockRepo.ExpectedCalls = nil
+	for _, id := range []string{"", "abc", "0", "-1", "1 OR 1=1"} {
+		_, err = useCase.GetUser(ctx, id)
+		assert.ErrorIs(t, err, usecase.ErrInvalidUserID, id)
+	}
+	mockRepo.AssertNotCalled(t, "GetUser", "1 OR 1=1")
 }
 
 func TestUseCase_GetUsersList(t *testing.T) {
 	mockRepo := new(MockRepository)
 	useCase := usecase.NewUseCase(mockRepo)
+	ctx := context.Background()
 
 	// Test case: Get all users
 	expectedUsers := []*model.User{
@@ -143,19 +348,293 @@ func TestUseCase_GetUsersList(t *testing.T) {
 		{Model: gorm.Model{ID: 2}, Name: "User 2", Email: "user2@example.com"},
 	}
 
-	mockRepo.On("GetUsersList").Return(expectedUsers)
+	mockRepo.On("GetUsersList", model.UserQuery{OrderBy: idOrder, Limit: 51}).Return(expectedUsers, nil)
 
 	// Call the method
-	users := useCase.GetUsersList()
+	page, err := useCase.GetUsersList(ctx, model.ListUsersParams{})
 
 	// Assertions
-	assert.Equal(t, expectedUsers, users)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedUsers, page.Users)
+	assert.Empty(t, page.NextPageToken)
 	mockRepo.AssertExpectations(t)
 }
 
+func TestUseCase_GetUsersList_Pagination(t *testing.T) {
+	mockRepo := new(MockRepository)
+	useCase := usecase.NewUseCase(mockRepo)
+	ctx := context.Background()
+
+	// Test case: More rows than the page size yields a next page token
+	firstRows := []*model.User{
+		{Model: gorm.Model{ID: 1}, Name: "User 1", Email: "user1@example.com"},
+		{Model: gorm.Model{ID: 2}, Name: "User 2", Email: "user2@example.com"},
+		{Model: gorm.Model{ID: 3}, Name: "User 3", Email: "user3@example.com"},
+	}
+	mockRepo.On("GetUsersList", model.UserQuery{OrderBy: idOrder, Limit: 3}).Return(firstRows, nil)
+
+	page, err := useCase.GetUsersList(ctx, model.ListUsersParams{PageSize: 2})
+
+	assert.NoError(t, err)
+	assert.Len(t, page.Users, 2)
+	assert.NotEmpty(t, page.NextPageToken)
+	mockRepo.AssertExpectations(t)
+
+	// Test case: The token resumes after the last user of the previous page
+	mockRepo.ExpectedCalls = nil
+	mockRepo.On("GetUsersList", model.UserQuery{
+		OrderBy: idOrder,
+		After:   &model.UserCursor{ID: 2},
+		Limit:   3,
+	}).Return(firstRows[2:], nil)
+
+	page, err = useCase.GetUsersList(ctx, model.ListUsersParams{PageSize: 2, PageToken: page.NextPageToken})
+
+	assert.NoError(t, err)
+	assert.Len(t, page.Users, 1)
+	assert.Empty(t, page.NextPageToken)
+	mockRepo.AssertExpectations(t)
+
+	// Test case: Oversized pages are clamped
+	mockRepo.ExpectedCalls = nil
+	mockRepo.On("GetUsersList", model.UserQuery{OrderBy: idOrder, Limit: 1001}).Return([]*model.User{}, nil)
+
+	_, err = useCase.GetUsersList(ctx, model.ListUsersParams{PageSize: 5000})
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+
+	// Test case: Malformed token and negative page size
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo)
+
+	_, err = useCase.GetUsersList(ctx, model.ListUsersParams{PageSize: 10, PageToken: "not-a-token"})
+	assert.ErrorIs(t, err, usecase.ErrInvalidPageToken)
+
+	_, err = useCase.GetUsersList(ctx, model.ListUsersParams{PageSize: -1})
+	assert.ErrorIs(t, err, usecase.ErrInvalidPageSize)
+	mockRepo.AssertNotCalled(t, "GetUsersList", mock.Anything)
+}
+
+func TestUseCase_GetUsersList_Filter(t *testing.T) {
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	valid := []struct {
+		name       string
+		filter     string
+		orderBy    string
+		conditions []model.UserCondition
+		order      model.UserOrder
+	}{
+		{
+			name:       "empty filter",
+			order:      idOrder,
+			conditions: nil,
+		},
+		{
+			name:       "email suffix",
+			filter:     `email suffix "@partner.com"`,
+			order:      idOrder,
+			conditions: []model.UserCondition{{Field: model.FieldEmail, Op: model.OpSuffix, Value: "@partner.com"}},
+		},
+		{
+			name:    "combined with a date range and ordering",
+			filter:  `name contains X and created_at >= "2024-01-01T00:00:00Z" AND created_at<2024-01-01T00:00:00Z`,
+			orderBy: "created_at DESC",
+			order:   model.UserOrder{Field: model.FieldCreatedAt, Desc: true},
+			conditions: []model.UserCondition{
+				{Field: model.FieldName, Op: model.OpContains, Value: "X"},
+				{Field: model.FieldCreatedAt, Op: model.OpGreaterEqual, Value: "2024-01-01T00:00:00Z", Time: since},
+				{Field: model.FieldCreatedAt, Op: model.OpLess, Value: "2024-01-01T00:00:00Z", Time: since},
+			},
+		},
+		{
+			name:       "sql in a quoted value stays a value",
+			filter:     `name = "x' OR '1'='1\" --"`,
+			orderBy:    "name",
+			order:      model.UserOrder{Field: model.FieldName},
+			conditions: []model.UserCondition{{Field: model.FieldName, Op: model.OpEquals, Value: `x' OR '1'='1" --`}},
+		},
+	}
+
+	for _, tc := range valid {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(MockRepository)
+			useCase := usecase.NewUseCase(mockRepo)
+			ctx := context.Background()
+
+			mockRepo.On("GetUsersList", model.UserQuery{
+				Conditions: tc.conditions,
+				OrderBy:    tc.order,
+				Limit:      51,
+			}).Return([]*model.User{}, nil)
+
+			_, err := useCase.GetUsersList(ctx, model.ListUsersParams{Filter: tc.filter, OrderBy: tc.orderBy})
+
+			assert.NoError(t, err)
+			mockRepo.AssertExpectations(t)
+		})
+	}
+
+	invalid := []struct {
+		name    string
+		filter  string
+		orderBy string
+	}{
+		{name: "unknown field", filter: `password = "x"`},
+		{name: "column injection", filter: `name; DROP TABLE users; -- = "x"`},
+		{name: "quoted field", filter: `"name" = "x"`},
+		{name: "operator not valid for field", filter: `email > "a"`},
+		{name: "range operator on a string field", filter: `name <= "a"`},
+		{name: "bad timestamp", filter: `created_at > "yesterday"`},
+		{name: "missing value", filter: `name prefix`},
+		{name: "OR is not supported", filter: `name = a OR name = b`},
+		{name: "unterminated string", filter: `name = "abc`},
+		{name: "too many conditions", filter: strings.Repeat(`name = a AND `, 10) + `name = a`},
+		{name: "value too long", filter: `name = ` + strings.Repeat("a", 257)},
+		{name: "unknown order field", orderBy: "password"},
+		{name: "order injection", orderBy: "name; DROP TABLE users"},
+		{name: "bad direction", orderBy: "name sideways"},
+	}
+
+	for _, tc := range invalid {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(MockRepository)
+			useCase := usecase.NewUseCase(mockRepo)
+			ctx := context.Background()
+
+			_, err := useCase.GetUsersList(ctx, model.ListUsersParams{Filter: tc.filter, OrderBy: tc.orderBy})
+
+			assert.Error(t, err)
+			assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err))
+			mockRepo.AssertNotCalled(t, "GetUsersList", mock.Anything)
+		})
+	}
+}
+
+func TestUseCase_GetUsersList_OrderedPagination(t *testing.T) {
+	mockRepo := new(MockRepository)
+	useCase := usecase.NewUseCase(mockRepo)
+	ctx := context.Background()
+
+	nameDesc := model.UserOrder{Field: model.FieldName, Desc: true}
+	rows := []*model.User{
+		{Model: gorm.Model{ID: 7}, Name: "Zed"},
+		{Model: gorm.Model{ID: 3}, Name: "Amy"},
+	}
+	mockRepo.On("GetUsersList", model.UserQuery{OrderBy: nameDesc, Limit: 2}).Return(rows, nil)
+
+	page, err := useCase.GetUsersList(ctx, model.ListUsersParams{PageSize: 1, OrderBy: "name desc"})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, page.NextPageToken)
+
+	// Test case: The cursor carries the sort key of the last row
+	mockRepo.On("GetUsersList", model.UserQuery{
+		OrderBy: nameDesc,
+		After:   &model.UserCursor{ID: 7, Value: "Zed"},
+		Limit:   2,
+	}).Return(rows[1:], nil)
+
+	_, err = useCase.GetUsersList(ctx, model.ListUsersParams{PageSize: 1, OrderBy: "name desc", PageToken: page.NextPageToken})
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+
+	// Test case: The token cannot be reused with another order or filter
+	_, err = useCase.GetUsersList(ctx, model.ListUsersParams{PageSize: 1, OrderBy: "email", PageToken: page.NextPageToken})
+	assert.ErrorIs(t, err, usecase.ErrPageTokenMismatch)
+
+	_, err = useCase.GetUsersList(ctx, model.ListUsersParams{PageSize: 1, OrderBy: "name desc", Filter: "name = Amy", PageToken: page.NextPageToken})
+	assert.ErrorIs(t, err, usecase.ErrPageTokenMismatch)
+
+	_, err = useCase.GetUsersList(ctx, model.ListUsersParams{PageSize: 1, OrderBy: "name desc", IncludeDeleted: true, PageToken: page.NextPageToken})
+	assert.ErrorIs(t, err, usecase.ErrPageTokenMismatch)
+
+	// Test case: Deleted users are asked of the repository when included
+	mockRepo.On("GetUsersList", model.UserQuery{OrderBy: nameDesc, Limit: 2, IncludeDeleted: true}).Return(rows[1:], nil)
+
+	page, err = useCase.GetUsersList(ctx, model.ListUsersParams{PageSize: 1, OrderBy: "name desc", IncludeDeleted: true})
+	assert.NoError(t, err)
+	assert.Len(t, page.Users, 1)
+}
+
+func TestUseCase_StreamUsers(t *testing.T) {
+	mockRepo := new(MockRepository)
+	useCase := usecase.NewUseCase(mockRepo)
+	ctx := context.Background()
+
+	// Test case: Users from the repository reach the callback
+	expectedUsers := []*model.User{
+		{Model: gorm.Model{ID: 1}, Name: "User 1", Email: "user1@example.com"},
+		{Model: gorm.Model{ID: 2}, Name: "User 2", Email: "user2@example.com"},
+	}
+	mockRepo.On("StreamUsers", mock.Anything).Return(expectedUsers, nil)
+
+	var streamed []*model.User
+	err := useCase.StreamUsers(ctx, func(user *model.User) error {
+		streamed = append(streamed, user)
+		return nil
+	})
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Equal(t, expectedUsers, streamed)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestUseCase_SearchUsers(t *testing.T) {
+	hits := []*model.UserSearchResult{
+		{User: &model.User{Model: gorm.Model{ID: 1}, Name: "Alice"}, Score: 2, NameSnippet: "<mark>Ali</mark>ce"},
+	}
+
+	valid := []struct {
+		name  string
+		query string
+		limit int
+		match string
+		want  int
+	}{
+		{name: "single term", query: "ali", match: `"ali"*`, want: 20},
+		{name: "terms are combined", query: "  jo   example ", limit: 5, match: `"jo"* "example"*`, want: 5},
+		{name: "limit is clamped", query: "ali", limit: 1000, match: `"ali"*`, want: 100},
+		{name: "fts operators are plain text", query: `ali OR NEAR(x) "quoted`, match: `"ali"* "OR"* "NEAR(x)"* """quoted"*`, want: 20},
+		{name: "punctuation only terms are dropped", query: `ali -- *`, match: `"ali"*`, want: 20},
+	}
+
+	for _, tc := range valid {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(MockRepository)
+			useCase := usecase.NewUseCase(mockRepo)
+			ctx := context.Background()
+			mockRepo.On("SearchUsers", tc.match, tc.want).Return(hits, nil)
+
+			results, err := useCase.SearchUsers(ctx, tc.query, tc.limit)
+
+			assert.NoError(t, err)
+			assert.Equal(t, hits, results)
+			mockRepo.AssertExpectations(t)
+		})
+	}
+
+	// Test case: Unusable queries never reach the repository
+	mockRepo := new(MockRepository)
+	useCase := usecase.NewUseCase(mockRepo)
+	ctx := context.Background()
+
+	_, err := useCase.SearchUsers(ctx, "   ", 0)
+	assert.ErrorIs(t, err, usecase.ErrSearchQueryRequired)
+	_, err = useCase.SearchUsers(ctx, `"" ** --`, 0)
+	assert.ErrorIs(t, err, usecase.ErrSearchQueryRequired)
+	_, err = useCase.SearchUsers(ctx, "a b c d e f g h i", 0)
+	assert.ErrorIs(t, err, usecase.ErrSearchQueryTooLong)
+	_, err = useCase.SearchUsers(ctx, "ali", -1)
+	assert.ErrorIs(t, err, usecase.ErrInvalidSearchLimit)
+	mockRepo.AssertNotCalled(t, "SearchUsers", mock.Anything, mock.Anything)
+}
+
 func TestUseCase_UpdateUser(t *testing.T) {
 	mockRepo := new(MockRepository)
 	useCase := usecase.NewUseCase(mockRepo)
+	ctx := context.Background()
 
 	// Test case: Update user successfully
 	userToUpdate := &model.User{
@@ -172,10 +651,10 @@ func TestUseCase_UpdateUser(t *testing.T) {
 
 	mockRepo.On("GetUser", "1").Return(existingUser, nil)
 	mockRepo.On("GetUserByEmail", userToUpdate.Email).Return(nil, gorm.ErrRecordNotFound)
-	mockRepo.On("UpdateUser", userToUpdate).Return(nil)
+	mockRepo.On("UpdateUser", userToUpdate, model.UserUpdatableFields).Return(nil)
 
 	// Call the method
-	err := useCase.UpdateUser(userToUpdate)
+	err := useCase.UpdateUser(ctx, userToUpdate, nil)
 
 	// Assertions
 	assert.NoError(t, err)
@@ -192,7 +671,7 @@ func TestUseCase_UpdateUser(t *testing.T) {
 	mockRepo.On("GetUser", "999").Return(nil, gorm.ErrRecordNotFound)
 
 	// Call the method
-	err = useCase.UpdateUser(nonExistentUser)
+	err = useCase.UpdateUser(ctx, nonExistentUser, nil)
 
 	// Assertions
 	assert.Error(t, err)
@@ -223,17 +702,66 @@ func TestUseCase_UpdateUser(t *testing.T) {
 	mockRepo.On("GetUserByEmail", conflictUser.Email).Return(anotherUser, nil)
 
 	// Call the method
-	err = useCase.UpdateUser(conflictUser)
+	err = useCase.UpdateUser(ctx, conflictUser, nil)
 
 	// Assertions
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "email already exists")
+	assert.Equal(t, errs.AlreadyExists, errs.CodeOf(err))
 	mockRepo.AssertExpectations(t)
+
+	// Test case: An update based on an older version is refused up front
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo)
+	mockRepo.On("GetUser", "1").Return(&model.User{Model: gorm.Model{ID: 1}, Email: "original@example.com", Version: 5}, nil)
+
+	err = useCase.UpdateUser(ctx, &model.User{Model: gorm.Model{ID: 1}, Name: "Stale", Email: "stale@example.com", Version: 4}, nil)
+
+	assert.ErrorIs(t, err, usecase.ErrVersionConflict)
+	assert.Equal(t, errs.Aborted, errs.CodeOf(err))
+	mockRepo.AssertNotCalled(t, "UpdateUser", mock.Anything, mock.Anything)
+
+	// Test case: Losing the race to a concurrent update is a conflict too
+	mockRepo.On("GetUserByEmail", "racy@example.com").Return(nil, gorm.ErrRecordNotFound)
+	mockRepo.On("UpdateUser", mock.Anything, model.UserUpdatableFields).Return(fmt.Errorf("failed to update user: %w", model.ErrVersionConflict))
+
+	err = useCase.UpdateUser(ctx, &model.User{Model: gorm.Model{ID: 1}, Name: "Racy", Email: "racy@example.com", Version: 5}, nil)
+
+	assert.ErrorIs(t, err, usecase.ErrVersionConflict)
+
+	// Test case: A name-only update needs no email and skips the uniqueness check
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo)
+	mockRepo.On("GetUser", "1").Return(existingUser, nil)
+	mockRepo.On("UpdateUser", &model.User{Model: gorm.Model{ID: 1}, Name: "Renamed"}, []string{model.UserFieldName}).Return(nil)
+
+	err = useCase.UpdateUser(ctx, &model.User{Model: gorm.Model{ID: 1}, Name: "Renamed"}, []string{"name", "name"})
+
+	assert.NoError(t, err)
+	mockRepo.AssertNotCalled(t, "GetUserByEmail", mock.Anything)
+	mockRepo.AssertExpectations(t)
+
+	// Test case: Keeping the current email does not collide with oneself
+	mockRepo.On("UpdateUser", mock.Anything, model.UserUpdatableFields).Return(nil)
+
+	err = useCase.UpdateUser(ctx, &model.User{Model: gorm.Model{ID: 1}, Name: "Renamed", Email: existingUser.Email}, []string{"*"})
+
+	assert.NoError(t, err)
+	mockRepo.AssertNotCalled(t, "GetUserByEmail", mock.Anything)
+
+	// Test case: Only masked fields are validated, unknown paths are refused
+	err = useCase.UpdateUser(ctx, &model.User{Model: gorm.Model{ID: 1}, Name: "Renamed"}, []string{"email"})
+	assert.ErrorIs(t, err, usecase.ErrEmailRequired)
+
+	err = useCase.UpdateUser(ctx, &model.User{Model: gorm.Model{ID: 1}, Name: "Renamed"}, []string{"name", "status"})
+	assert.ErrorIs(t, err, usecase.ErrInvalidUpdateMask)
+	assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err))
 }
 
 func TestUseCase_DeleteUser(t *testing.T) {
 	mockRepo := new(MockRepository)
 	useCase := usecase.NewUseCase(mockRepo)
+	ctx := context.Background()
 
 	// Test case: Delete user successfully
 	existingUser := &model.User{
@@ -246,7 +774,7 @@ func TestUseCase_DeleteUser(t *testing.T) {
 	mockRepo.On("DeleteUser", "1").Return(nil)
 
 	// Call the method
-	err := useCase.DeleteUser("1")
+	err := useCase.DeleteUser(ctx, "1")
 
 	// Assertions
 	assert.NoError(t, err)
@@ -257,7 +785,7 @@ func TestUseCase_DeleteUser(t *testing.T) {
 	mockRepo.On("GetUser", "999").Return(nil, gorm.ErrRecordNotFound)
 
 	// Call the method
-	err = useCase.DeleteUser("999")
+	err = useCase.DeleteUser(ctx, "999")
 
 	// Assertions
 	assert.Error(t, err)
@@ -270,7 +798,7 @@ func TestUseCase_DeleteUser(t *testing.T) {
 	mockRepo.On("DeleteUser", "2").Return(errors.New("database error"))
 
 	// Call the method
-	err = useCase.DeleteUser("2")
+	err = useCase.DeleteUser(ctx, "2")
 
 	// Assertions
 	assert.Error(t, err)
@@ -0,0 +1,169 @@
+package usecase_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/yishak-cs/CleanGrpc/Internal/auth"
+	"github.com/yishak-cs/CleanGrpc/Internal/errs"
+	"github.com/yishak-cs/CleanGrpc/Internal/mail"
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+	usecase "github.com/yishak-cs/CleanGrpc/pkg/v1/UseCase"
+	"gorm.io/gorm"
+)
+
+// fakeMailer keeps the messages it is asked to send
+type fakeMailer struct {
+	mu   sync.Mutex
+	sent []mail.Message
+	err  error
+}
+
+func (m *fakeMailer) Send(_ context.Context, msg mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// messages returns a copy of what was sent so far, for mails sent in the
+// background
+func (m *fakeMailer) messages() []mail.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]mail.Message(nil), m.sent...)
+}
+
+// mailedToken finds the token in body whose hash is hash
+func mailedToken(t *testing.T, body, hash string) string {
+	t.Helper()
+	for _, field := range strings.FieldsFunc(body, func(r rune) bool { return r == '\n' || r == '=' }) {
+		if auth.HashEmailToken(field) == hash {
+			return field
+		}
+	}
+	t.Fatalf("no token with hash %s in %q", hash, body)
+	return ""
+}
+
+func TestUseCase_CreateUser_Verification(t *testing.T) {
+	ctx := context.Background()
+	mailer := &fakeMailer{}
+	verify := usecase.EmailVerification{URL: "https://example.com/verify?token={token}", TTL: time.Hour}
+
+	// Test case: With a mailer new users are pending and mailed a link with their token
+	mockRepo := new(MockRepository)
+	useCase := usecase.NewUseCase(mockRepo, usecase.WithMailer(mailer), usecase.WithEmailVerification(verify))
+	var stored *model.EmailToken
+	mockRepo.On("GetUserByEmail", "a@example.com").Return(nil, gorm.ErrRecordNotFound)
+	mockRepo.On("CreateUser", mock.MatchedBy(func(u *model.User) bool { return u.Status == model.UserStatusPending })).
+		Return(&model.User{Model: gorm.Model{ID: 7}, Name: "A", Email: "a@example.com", Status: model.UserStatusPending}, nil)
+	mockRepo.On("ReplaceEmailToken", mock.Anything).Run(func(args mock.Arguments) {
+		stored = args.Get(0).(*model.EmailToken)
+	}).Return(nil)
+
+	_, err := useCase.CreateUser(ctx, &model.User{Name: "A", Email: "a@example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint(7), stored.UserID)
+	assert.Equal(t, model.TokenPurposeVerifyEmail, stored.Purpose)
+	assert.Equal(t, "a@example.com", stored.Email)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), stored.ExpiresAt, time.Second)
+	assert.Len(t, mailer.sent, 1)
+	assert.Equal(t, "a@example.com", mailer.sent[0].To)
+	token := mailedToken(t, mailer.sent[0].Body, stored.Hash)
+	assert.Contains(t, mailer.sent[0].Body, "https://example.com/verify?token="+token)
+
+	// Test case: A mail that cannot be sent does not undo the signup
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo, usecase.WithMailer(&fakeMailer{err: errors.New("smtp down")}))
+	mockRepo.On("GetUserByEmail", "b@example.com").Return(nil, gorm.ErrRecordNotFound)
+	mockRepo.On("CreateUser", mock.Anything).Return(&model.User{Model: gorm.Model{ID: 8}, Email: "b@example.com"}, nil)
+	mockRepo.On("ReplaceEmailToken", mock.Anything).Return(nil)
+
+	_, err = useCase.CreateUser(ctx, &model.User{Name: "B", Email: "b@example.com"})
+	assert.NoError(t, err)
+
+	// Test case: Changing the address makes the account pending again
+	mailer = &fakeMailer{}
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo, usecase.WithMailer(mailer))
+	mockRepo.On("GetUser", "7").Return(&model.User{Model: gorm.Model{ID: 7}, Name: "A", Email: "a@example.com", Status: model.UserStatusActive}, nil)
+	mockRepo.On("GetUserByEmail", "new@example.com").Return(nil, gorm.ErrRecordNotFound)
+	mockRepo.On("UpdateUser", mock.MatchedBy(func(u *model.User) bool { return u.Status == model.UserStatusPending }), model.UserUpdatableFields).Return(nil)
+	mockRepo.On("ReplaceEmailToken", mock.MatchedBy(func(t *model.EmailToken) bool { return t.Email == "new@example.com" })).Return(nil)
+
+	err = useCase.UpdateUser(ctx, &model.User{Model: gorm.Model{ID: 7}, Name: "A", Email: "new@example.com"}, nil)
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	assert.Equal(t, "new@example.com", mailer.sent[0].To)
+}
+
+func TestUseCase_VerifyEmail(t *testing.T) {
+	ctx := context.Background()
+	token, hash, _ := auth.NewEmailToken()
+	record := func(expires time.Time) *model.EmailToken {
+		return &model.EmailToken{Hash: hash, UserID: 7, Purpose: model.TokenPurposeVerifyEmail, Email: "a@example.com", ExpiresAt: expires}
+	}
+	pending := &model.User{Model: gorm.Model{ID: 7}, Email: "a@example.com", Status: model.UserStatusPending}
+
+	// Test case: A live token activates the account it was sent for
+	mockRepo := new(MockRepository)
+	useCase := usecase.NewUseCase(mockRepo)
+	mockRepo.On("ConsumeEmailToken", hash, model.TokenPurposeVerifyEmail).Return(record(time.Now().Add(time.Hour)), nil)
+	mockRepo.On("GetUser", "7").Return(pending, nil)
+	mockRepo.On("SetUserStatus", uint(7), model.UserStatusActive).Return(nil)
+
+	assert.NoError(t, useCase.VerifyEmail(ctx, token))
+	mockRepo.AssertExpectations(t)
+
+	// Test case: Used, unknown, expired and outdated tokens are refused
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo)
+	mockRepo.On("ConsumeEmailToken", hash, model.TokenPurposeVerifyEmail).Return(nil, gorm.ErrRecordNotFound).Once()
+	assert.ErrorIs(t, useCase.VerifyEmail(ctx, token), usecase.ErrInvalidVerificationToken)
+
+	mockRepo.On("ConsumeEmailToken", hash, model.TokenPurposeVerifyEmail).Return(record(time.Now().Add(-time.Second)), nil).Once()
+	assert.ErrorIs(t, useCase.VerifyEmail(ctx, token), usecase.ErrInvalidVerificationToken)
+
+	mockRepo.On("ConsumeEmailToken", hash, model.TokenPurposeVerifyEmail).Return(record(time.Now().Add(time.Hour)), nil).Once()
+	mockRepo.On("GetUser", "7").Return(&model.User{Model: gorm.Model{ID: 7}, Email: "changed@example.com", Status: model.UserStatusPending}, nil).Once()
+	err := useCase.VerifyEmail(ctx, token)
+	assert.ErrorIs(t, err, usecase.ErrInvalidVerificationToken)
+	assert.Equal(t, errs.InvalidArgument, errs.CodeOf(err))
+	mockRepo.AssertNotCalled(t, "SetUserStatus", mock.Anything, mock.Anything)
+
+	assert.ErrorIs(t, useCase.VerifyEmail(ctx, ""), usecase.ErrVerificationTokenRequired)
+}
+
+func TestUseCase_ResendVerification(t *testing.T) {
+	owner := callerContext(&auth.Principal{Subject: "7"}, auth.GrantSelf)
+	other := callerContext(&auth.Principal{Subject: "8"}, auth.GrantSelf)
+	pending := &model.User{Model: gorm.Model{ID: 7}, Email: "a@example.com", Status: model.UserStatusPending}
+
+	// Test case: The owner gets a new token mailed
+	mailer := &fakeMailer{}
+	mockRepo := new(MockRepository)
+	useCase := usecase.NewUseCase(mockRepo, usecase.WithMailer(mailer))
+	mockRepo.On("GetUser", "7").Return(pending, nil)
+	mockRepo.On("ReplaceEmailToken", mock.Anything).Return(nil)
+
+	assert.NoError(t, useCase.ResendVerification(owner, "7"))
+	assert.Len(t, mailer.sent, 1)
+	assert.ErrorIs(t, useCase.ResendVerification(other, "7"), usecase.ErrNotOwner)
+
+	// Test case: Verified accounts and servers without a mailer are refused
+	mockRepo = new(MockRepository)
+	useCase = usecase.NewUseCase(mockRepo, usecase.WithMailer(mailer))
+	mockRepo.On("GetUser", "7").Return(&model.User{Model: gorm.Model{ID: 7}, Status: model.UserStatusActive}, nil)
+	assert.ErrorIs(t, useCase.ResendVerification(owner, "7"), usecase.ErrEmailAlreadyVerified)
+	assert.ErrorIs(t, usecase.NewUseCase(mockRepo).ResendVerification(owner, "7"), usecase.ErrVerificationDisabled)
+}
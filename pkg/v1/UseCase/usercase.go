@@ -1,72 +1,275 @@
 package usecase
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"sync"
+	"time"
 
+	"github.com/yishak-cs/CleanGrpc/Internal/auth"
+	"github.com/yishak-cs/CleanGrpc/Internal/mail"
 	"github.com/yishak-cs/CleanGrpc/Internal/model"
 	interfaces "github.com/yishak-cs/CleanGrpc/pkg/v1"
+	"go.opentelemetry.io/otel/attribute"
 	"gorm.io/gorm"
 )
 
 // implements the businesslogic layer or the domain layer. it interface with
 // datalayer (Repo)
 type UseCase struct {
-	repo interfaces.RepoInterface
+	repo       interfaces.RepoInterface
+	issuer     *auth.Issuer
+	lockout    Lockout
+	sessionTTL time.Duration
+	mailer     mail.Mailer
+	verify     EmailVerification
+	magicLink  MagicLink
+	// requests for magic links per email address
+	magicLimiter *rateLimiter
+	// mails still being sent after their RPC returned
+	background sync.WaitGroup
+}
+
+// Option customises a UseCase created by NewUseCase
+type Option func(*UseCase)
+
+// WithIssuer lets Login and sessions hand out access tokens signed by
+// issuer. without it they fail with ErrLoginDisabled
+func WithIssuer(issuer *auth.Issuer) Option {
+	return func(uc *UseCase) { uc.issuer = issuer }
+}
+
+// WithLockout replaces DefaultLockout
+func WithLockout(lockout Lockout) Option {
+	return func(uc *UseCase) { uc.lockout = lockout }
+}
+
+// WithSessionTTL replaces DefaultSessionTTL
+func WithSessionTTL(ttl time.Duration) Option {
+	return func(uc *UseCase) { uc.sessionTTL = ttl }
+}
+
+// WithMailer turns on email verification: new users start out pending and
+// are mailed a token through mailer. without it users are active right away
+func WithMailer(mailer mail.Mailer) Option {
+	return func(uc *UseCase) { uc.mailer = mailer }
+}
+
+// WithEmailVerification replaces DefaultEmailVerification
+func WithEmailVerification(verify EmailVerification) Option {
+	return func(uc *UseCase) { uc.verify = verify }
+}
+
+// WithMagicLink replaces DefaultMagicLink. sign-in links also need a mailer
+// and an issuer
+func WithMagicLink(magicLink MagicLink) Option {
+	return func(uc *UseCase) { uc.magicLink = magicLink }
 }
 
 // get a new UseCase instance or a type that abides to UseCaseInterface contract
-func NewUseCase(repo interfaces.RepoInterface) interfaces.UseCaseInterface {
-	return &UseCase{repo}
+func NewUseCase(repo interfaces.RepoInterface, opts ...Option) interfaces.UseCaseInterface {
+	uc := &UseCase{repo: repo, lockout: DefaultLockout, sessionTTL: DefaultSessionTTL, verify: DefaultEmailVerification, magicLink: DefaultMagicLink}
+	for _, opt := range opts {
+		opt(uc)
+	}
+	uc.magicLimiter = newRateLimiter(uc.magicLink.Limit, uc.magicLink.Window)
+	return uc
+}
+
+// Wait blocks until the mails sent in the background, e.g. sign-in links,
+// are out. call it once no more RPCs arrive and before closing what they use
+func (uc *UseCase) Wait() {
+	uc.background.Wait()
 }
 
-func (uc *UseCase) CreateUser(user *model.User) (*model.User, error) {
+func (uc *UseCase) CreateUser(ctx context.Context, user *model.User) (_ *model.User, err error) {
+	ctx, span := startSpan(ctx, "CreateUser")
+	defer func() { endSpan(span, err) }()
+
+	if err := validateUser(user); err != nil {
+		return &model.User{}, err
+	}
 	//make sure the email is not taken
-	if _, err := uc.repo.GetUserByEmail(user.Email); !errors.Is(err, gorm.ErrRecordNotFound) {
-		return &model.User{}, gorm.ErrDuplicatedKey
+	if err := uc.checkEmailAvailable(ctx, user.Email); err != nil {
+		return &model.User{}, err
+	}
+	// the address has to be proven before the account can be used
+	if uc.mailer != nil {
+		user.Status = model.UserStatusPending
 	}
 	// then create a user
-	return uc.repo.CreateUser(user)
+	created, err := uc.repo.CreateUser(ctx, user)
+	if err != nil {
+		return created, err
+	}
+	uc.sendVerificationOrLog(ctx, created)
+	return created, nil
 }
 
 // retreive a user
-func (uc *UseCase) GetUser(id string) (*model.User, error) {
-	return uc.repo.GetUser(id)
+func (uc *UseCase) GetUser(ctx context.Context, id string) (_ *model.User, err error) {
+	ctx, span := startSpan(ctx, "GetUser")
+	defer func() { endSpan(span, err) }()
+
+	if err := validateUserID(id); err != nil {
+		return nil, err
+	}
+	if err := authorizeOwner(ctx, id); err != nil {
+		return nil, err
+	}
+	user, err := uc.repo.GetUser(ctx, id)
+	if err != nil {
+		return nil, userLookupError(err)
+	}
+	return user, nil
+}
+
+// retreive a page of users from Repository matching params.Filter in
+// params.OrderBy order. params.PageToken is the NextPageToken of a previous
+// page or empty for the first one
+func (uc *UseCase) GetUsersList(ctx context.Context, params model.ListUsersParams) (_ *model.UsersPage, err error) {
+	ctx, span := startSpan(ctx, "GetUsersList")
+	defer func() { endSpan(span, err) }()
+
+	if params.IncludeDeleted {
+		if err = authorizeIncludeDeleted(ctx); err != nil {
+			return nil, err
+		}
+	}
+	pageSize := params.PageSize
+	if pageSize < 0 {
+		return nil, ErrInvalidPageSize
+	}
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	conditions, err := parseFilter(params.Filter)
+	if err != nil {
+		return nil, err
+	}
+	order, err := parseOrderBy(params.OrderBy)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := queryFingerprint(params.Filter, order, params.IncludeDeleted)
+
+	query := model.UserQuery{Conditions: conditions, OrderBy: order, Limit: pageSize + 1, IncludeDeleted: params.IncludeDeleted}
+	if params.PageToken != "" {
+		c, err := decodeCursor(params.PageToken)
+		if err != nil {
+			return nil, err
+		}
+		if query.After, err = userCursor(c, order, fingerprint); err != nil {
+			return nil, err
+		}
+	}
+
+	// one extra row is fetched to know whether another page follows
+	users, err := uc.repo.GetUsersList(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &model.UsersPage{Users: users}
+	if len(users) > pageSize {
+		page.Users = users[:pageSize]
+		page.NextPageToken = encodeCursor(cursorAfter(page.Users[pageSize-1], order, fingerprint))
+	}
+	return page, nil
 }
 
-// retreive all users from Repository
-func (uc *UseCase) GetUsersList() []*model.User {
-	return uc.repo.GetUsersList()
+// hand every user to fn one at a time without loading the whole table
+func (uc *UseCase) StreamUsers(ctx context.Context, fn func(*model.User) error) (err error) {
+	ctx, span := startSpan(ctx, "StreamUsers")
+	streamed := 0
+	defer func() {
+		span.SetAttributes(attribute.Int("users.streamed", streamed))
+		endSpan(span, err)
+	}()
+
+	return uc.repo.StreamUsers(ctx, func(user *model.User) error {
+		streamed++
+		return fn(user)
+	})
 }
 
-// UpdateUser updates an existing user's information
-func (uc *UseCase) UpdateUser(update *model.User) error {
+// UpdateUser updates an existing user's information. only the fields named
+// by mask are validated and written, an empty mask writes name and email.
+// an update carrying the version it was based on fails with
+// ErrVersionConflict once the user has moved past it, without one the last
+// write wins
+func (uc *UseCase) UpdateUser(ctx context.Context, update *model.User, mask []string) (err error) {
+	ctx, span := startSpan(ctx, "UpdateUser")
+	defer func() { endSpan(span, err) }()
+
+	// owners may not even probe other records
+	if err := authorizeOwner(ctx, fmt.Sprintf("%d", update.ID)); err != nil {
+		return err
+	}
+	fields, err := updateFields(mask)
+	if err != nil {
+		return err
+	}
+	if err := validateUserFields(update, fields); err != nil {
+		return err
+	}
 
 	//check if the user exists
-	if _, err := uc.repo.GetUser(fmt.Sprintf("%d", (*update).ID)); err != nil {
+	current, err := uc.GetUser(ctx, fmt.Sprintf("%d", (*update).ID))
+	if err != nil {
 		return err
 	}
+	// fail early on a stale version, the repository checks again atomically
+	if update.Version != 0 && update.Version != current.Version {
+		return ErrVersionConflict
+	}
 
-	//check if the email is available
-	if _, err := uc.repo.GetUserByEmail(update.Email); !errors.Is(err, gorm.ErrRecordNotFound) {
-		return errors.New("the email already exists. please choose another email")
+	emailChanged := false
+	if slices.Contains(fields, model.UserFieldEmail) && update.Email != current.Email {
+		//check if the email is available
+		if err := uc.checkEmailAvailable(ctx, update.Email); err != nil {
+			return err
+		}
+		// a new address has to be proven again
+		if uc.mailer != nil {
+			emailChanged = true
+			update.Status = model.UserStatusPending
+		}
 	}
 
 	// update the user
-	if err := uc.repo.UpdateUser(update); err != nil {
+	if err := uc.repo.UpdateUser(ctx, update, fields); err != nil {
+		if errors.Is(err, model.ErrVersionConflict) {
+			return ErrVersionConflict
+		}
 		return fmt.Errorf("something went wrong: %w", err)
 	}
 
+	if emailChanged {
+		uc.sendVerificationOrLog(ctx, update)
+	}
 	return nil
 }
-func (uc *UseCase) DeleteUser(id string) error {
-	var err error
+
+func (uc *UseCase) DeleteUser(ctx context.Context, id string) (err error) {
+	ctx, span := startSpan(ctx, "DeleteUser")
+	defer func() { endSpan(span, err) }()
+
+	if err = authorizeOwner(ctx, id); err != nil {
+		return err
+	}
 	// check if user exists
-	if _, err = uc.GetUser(id); err != nil {
+	if _, err = uc.GetUser(ctx, id); err != nil {
 		return err
 	}
 
-	err = uc.repo.DeleteUser(id)
+	err = uc.repo.DeleteUser(ctx, id)
 	if err != nil {
 		// handle the error as it might be something worth to debug
 		return err
@@ -74,3 +277,18 @@ func (uc *UseCase) DeleteUser(id string) error {
 
 	return nil
 }
+
+// checkEmailAvailable fails with ErrEmailTaken when a user already owns email
+func (uc *UseCase) checkEmailAvailable(ctx context.Context, email string) (err error) {
+	ctx, span := startSpan(ctx, "checkEmailAvailable")
+	defer func() { endSpan(span, err) }()
+
+	_, err = uc.repo.GetUserByEmail(ctx, email)
+	if err == nil {
+		return ErrEmailTaken
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return nil
+}
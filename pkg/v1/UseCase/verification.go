@@ -0,0 +1,153 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yishak-cs/CleanGrpc/Internal/auth"
+	"github.com/yishak-cs/CleanGrpc/Internal/mail"
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+	"gorm.io/gorm"
+)
+
+// EmailVerification shapes the verification mails. URL is the link mailed
+// with "{token}" standing in for the token, empty mails the bare token. the
+// token works for TTL
+type EmailVerification struct {
+	URL string
+	TTL time.Duration
+}
+
+// DefaultEmailVerification is used unless WithEmailVerification says otherwise
+var DefaultEmailVerification = EmailVerification{TTL: 48 * time.Hour}
+
+// VerifyEmail redeems a token mailed by CreateUser, UpdateUser or
+// ResendVerification and activates the account it was sent for. a token
+// works once and only for the address it was sent to
+func (uc *UseCase) VerifyEmail(ctx context.Context, token string) (err error) {
+	ctx, span := startSpan(ctx, "VerifyEmail")
+	defer func() { endSpan(span, err) }()
+
+	if token == "" {
+		return ErrVerificationTokenRequired
+	}
+	record, err := uc.repo.ConsumeEmailToken(ctx, auth.HashEmailToken(token), model.TokenPurposeVerifyEmail)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return err
+	}
+	if !time.Now().Before(record.ExpiresAt) {
+		return ErrInvalidVerificationToken
+	}
+
+	user, err := uc.repo.GetUser(ctx, strconv.FormatUint(uint64(record.UserID), 10))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidVerificationToken
+	}
+	if err != nil {
+		return err
+	}
+	// the address changed since the mail went out
+	if user.Email != record.Email {
+		return ErrInvalidVerificationToken
+	}
+	if user.Status == model.UserStatusActive {
+		return nil
+	}
+	return uc.repo.SetUserStatus(ctx, user.ID, model.UserStatusActive)
+}
+
+// ResendVerification mails user id a new verification token, the earlier
+// one stops working
+func (uc *UseCase) ResendVerification(ctx context.Context, id string) (err error) {
+	ctx, span := startSpan(ctx, "ResendVerification")
+	defer func() { endSpan(span, err) }()
+
+	if err := validateUserID(id); err != nil {
+		return err
+	}
+	if err := authorizeOwner(ctx, id); err != nil {
+		return err
+	}
+	if uc.mailer == nil {
+		return ErrVerificationDisabled
+	}
+	user, err := uc.repo.GetUser(ctx, id)
+	if err != nil {
+		return userLookupError(err)
+	}
+	if user.Status != model.UserStatusPending {
+		return ErrEmailAlreadyVerified
+	}
+	return uc.sendVerification(ctx, user)
+}
+
+// sendVerification stores a new verification token for user and mails it
+func (uc *UseCase) sendVerification(ctx context.Context, user *model.User) error {
+	token, hash, err := auth.NewEmailToken()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	record := &model.EmailToken{
+		Hash:      hash,
+		UserID:    user.ID,
+		Purpose:   model.TokenPurposeVerifyEmail,
+		Email:     user.Email,
+		CreatedAt: now,
+		ExpiresAt: now.Add(uc.verify.TTL),
+	}
+	if err := uc.repo.ReplaceEmailToken(ctx, record); err != nil {
+		return err
+	}
+	return uc.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body:    uc.verificationBody(user, token),
+	})
+}
+
+// sendVerificationOrLog mails the verification token of a user that was just
+// created or changed their address. the change is already stored so a
+// failure only gets logged, ResendVerification can be used to try again
+func (uc *UseCase) sendVerificationOrLog(ctx context.Context, user *model.User) {
+	if uc.mailer == nil {
+		return
+	}
+	if err := uc.sendVerification(ctx, user); err != nil {
+		slog.ErrorContext(ctx, "unable to send verification email", "user_id", user.ID, "err", err)
+	}
+}
+
+func (uc *UseCase) verificationBody(user *model.User, token string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Hi %s,\n\nplease confirm that %s is your email address ", user.Name, user.Email)
+	if uc.verify.URL != "" {
+		fmt.Fprintf(&b, "by opening\n\n%s\n\n", strings.ReplaceAll(uc.verify.URL, "{token}", url.QueryEscape(token)))
+	} else {
+		fmt.Fprintf(&b, "with this verification token:\n\n%s\n\n", token)
+	}
+	fmt.Fprintf(&b, "It expires in %s. If you did not sign up, ignore this email.", shortDuration(uc.verify.TTL))
+	return b.String()
+}
+
+// shortDuration drops the zero minutes and seconds of d, "48h" instead of
+// "48h0m0s"
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
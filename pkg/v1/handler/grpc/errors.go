@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/yishak-cs/CleanGrpc/Internal/errs"
+	pb "github.com/yishak-cs/CleanGrpc/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// domain reported in google.rpc.ErrorInfo so clients can tell our reasons
+// apart from those of other services
+const errorDomain = "cleangrpc.yishak-cs.github.com"
+
+// toStatus converts an error coming out of the UseCase layer into a gRPC
+// status error. domain errors get a matching code plus ErrorInfo and, for bad
+// input, BadRequest details. a cancelled or expired request context keeps its
+// meaning and anything else is logged and reported as a bare codes.Internal,
+// its text may name tables or quote SQL and is not for clients
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	// already a status, e.g. a failed stream.Send
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if errors.Is(err, context.Canceled) {
+		return status.Error(codes.Canceled, err.Error())
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+
+	var domainErr *errs.Error
+	if !errors.As(err, &domainErr) {
+		return internalError(err)
+	}
+
+	st := status.New(grpcCode(domainErr.Code), domainErr.Message)
+	info := &errdetails.ErrorInfo{Reason: domainErr.Reason, Domain: errorDomain}
+	var detailed *status.Status
+	if domainErr.Field != "" {
+		detailed, err = st.WithDetails(info, &errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{
+				{Field: domainErr.Field, Description: domainErr.Message},
+			},
+		})
+	} else {
+		detailed, err = st.WithDetails(info)
+	}
+	if err != nil {
+		// details are a nicety, never lose the code because of them
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// toBatchItemError describes the failure of one batch item with the code,
+// reason and field toStatus would have given a single call
+func toBatchItemError(err error) *pb.BatchItemError {
+	var domainErr *errs.Error
+	if !errors.As(err, &domainErr) {
+		st := status.Convert(toStatus(err))
+		return &pb.BatchItemError{Code: int32(st.Code()), Message: st.Message()}
+	}
+	return &pb.BatchItemError{
+		Code:    int32(grpcCode(domainErr.Code)),
+		Reason:  domainErr.Reason,
+		Message: domainErr.Message,
+		Field:   domainErr.Field,
+	}
+}
+
+// internalError logs err in full and hides it behind a generic status
+func internalError(err error) error {
+	slog.Error("internal error", "err", err)
+	return status.Error(codes.Internal, "internal error")
+}
+
+func grpcCode(code errs.Code) codes.Code {
+	switch code {
+	case errs.NotFound:
+		return codes.NotFound
+	case errs.AlreadyExists:
+		return codes.AlreadyExists
+	case errs.InvalidArgument:
+		return codes.InvalidArgument
+	case errs.FailedPrecondition:
+		return codes.FailedPrecondition
+	case errs.PermissionDenied:
+		return codes.PermissionDenied
+	case errs.Unauthenticated:
+		return codes.Unauthenticated
+	case errs.ResourceExhausted:
+		return codes.ResourceExhausted
+	case errs.Aborted:
+		return codes.Aborted
+	default:
+		return codes.Internal
+	}
+}
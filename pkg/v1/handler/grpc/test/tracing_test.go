@@ -0,0 +1,93 @@
+package handler_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	database "github.com/yishak-cs/CleanGrpc/Internal/db"
+	"github.com/yishak-cs/CleanGrpc/Internal/model"
+	repository "github.com/yishak-cs/CleanGrpc/pkg/v1/Repository"
+	usecase "github.com/yishak-cs/CleanGrpc/pkg/v1/UseCase"
+	pb "github.com/yishak-cs/CleanGrpc/proto"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestUserServiceServer_Tracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	// the use case reports to the global provider
+	otel.SetTracerProvider(tp)
+	propagator := propagation.TraceContext{}
+
+	db, err := gorm.Open(sqlite.Open("file:tracing?mode=memory&cache=shared"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(&model.User{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	if err := db.Create(&model.User{Name: "Test User", Email: "test@example.com"}).Error; err != nil {
+		t.Fatalf("Failed to seed test database: %v", err)
+	}
+	if err := database.RegisterTracing(db, tp); err != nil {
+		t.Fatalf("Failed to register tracing: %v", err)
+	}
+
+	conn, client := setupGrpcServer(t, usecase.NewUseCase(repository.NewRepo(db)),
+		grpc.StatsHandler(otelgrpc.NewServerHandler(
+			otelgrpc.WithTracerProvider(tp),
+			otelgrpc.WithPropagators(propagator),
+		)),
+	)
+	defer conn.Close()
+
+	// the caller's trace travels in the traceparent header
+	ctx, caller := tp.Tracer("test").Start(context.Background(), "caller")
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	ctx = metadata.AppendToOutgoingContext(ctx, "traceparent", carrier.Get("traceparent"))
+
+	// Call the method
+	_, err = client.GetUser(ctx, &pb.SingleUserRequest{Id: "1"})
+	caller.End()
+	assert.NoError(t, err)
+
+	// Test case: handler -> use case -> sqlite spans form one trace under the caller
+	byName := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		byName[span.Name] = span
+	}
+	server, ok := byName["UserService/GetUser"]
+	if !assert.True(t, ok, "missing server span") {
+		return
+	}
+	useCase := byName["UseCase.GetUser"]
+	query := byName["gorm.query"]
+
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind)
+	assert.Equal(t, caller.SpanContext().TraceID(), server.SpanContext.TraceID())
+	assert.Equal(t, caller.SpanContext().SpanID(), server.Parent.SpanID())
+	assert.Equal(t, server.SpanContext.SpanID(), useCase.Parent.SpanID())
+	assert.Equal(t, useCase.SpanContext.SpanID(), query.Parent.SpanID())
+	assert.Contains(t, spanAttr(query, "db.query.text"), "SELECT * FROM `users`")
+}
+
+func spanAttr(span tracetest.SpanStub, key string) string {
+	for _, kv := range span.Attributes {
+		if string(kv.Key) == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
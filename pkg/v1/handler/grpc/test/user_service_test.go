@@ -3,28 +3,40 @@ package handler_test
 import (
 	"context"
 	"errors"
+	"io"
 	"net"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/yishak-cs/CleanGrpc/Internal/auth"
+	"github.com/yishak-cs/CleanGrpc/Internal/errs"
 	"github.com/yishak-cs/CleanGrpc/Internal/model"
 	interfaces "github.com/yishak-cs/CleanGrpc/pkg/v1"
+	repository "github.com/yishak-cs/CleanGrpc/pkg/v1/Repository"
+	usecase "github.com/yishak-cs/CleanGrpc/pkg/v1/UseCase"
 	handler "github.com/yishak-cs/CleanGrpc/pkg/v1/handler/grpc"
 	pb "github.com/yishak-cs/CleanGrpc/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
-// MockUseCase is a mock implementation of the UseCaseInterface
+// MockUseCase is a mock implementation of the UseCaseInterface. the context
+// argument is not recorded so expectations only list the remaining ones
 type MockUseCase struct {
 	mock.Mock
 }
 
-func (m *MockUseCase) CreateUser(user *model.User) (*model.User, error) {
+func (m *MockUseCase) CreateUser(_ context.Context, user *model.User) (*model.User, error) {
 	args := m.Called(user)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -32,7 +44,7 @@ func (m *MockUseCase) CreateUser(user *model.User) (*model.User, error) {
 	return args.Get(0).(*model.User), args.Error(1)
 }
 
-func (m *MockUseCase) GetUser(id string) (*model.User, error) {
+func (m *MockUseCase) GetUser(_ context.Context, id string) (*model.User, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
@@ -40,25 +52,181 @@ func (m *MockUseCase) GetUser(id string) (*model.User, error) {
 	return args.Get(0).(*model.User), args.Error(1)
 }
 
-func (m *MockUseCase) GetUsersList() []*model.User {
-	args := m.Called()
-	return args.Get(0).([]*model.User)
+func (m *MockUseCase) GetUsersList(_ context.Context, params model.ListUsersParams) (*model.UsersPage, error) {
+	args := m.Called(params)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.UsersPage), args.Error(1)
 }
 
-func (m *MockUseCase) UpdateUser(user *model.User) error {
-	args := m.Called(user)
+func (m *MockUseCase) StreamUsers(_ context.Context, fn func(*model.User) error) error {
+	args := m.Called(mock.Anything)
+	for _, user := range args.Get(0).([]*model.User) {
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
+}
+
+func (m *MockUseCase) SearchUsers(_ context.Context, query string, limit int) ([]*model.UserSearchResult, error) {
+	args := m.Called(query, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*model.UserSearchResult), args.Error(1)
+}
+
+func (m *MockUseCase) UpdateUser(_ context.Context, user *model.User, mask []string) error {
+	args := m.Called(user, mask)
+	return args.Error(0)
+}
+
+func (m *MockUseCase) DeleteUser(_ context.Context, id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockUseCase) UndeleteUser(_ context.Context, id string) (*model.User, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.User), args.Error(1)
+}
+
+func (m *MockUseCase) Wait() {
+	m.Called()
+}
+
+func (m *MockUseCase) PurgeUser(_ context.Context, id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockUseCase) BatchCreateUsers(_ context.Context, users []*model.User, mode model.BatchMode) ([]model.BatchResult, error) {
+	args := m.Called(users, mode)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.BatchResult), args.Error(1)
+}
+
+func (m *MockUseCase) BatchUpdateUsers(_ context.Context, updates []model.UserUpdate, mode model.BatchMode) ([]model.BatchResult, error) {
+	args := m.Called(updates, mode)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.BatchResult), args.Error(1)
+}
+
+func (m *MockUseCase) BatchDeleteUsers(_ context.Context, ids []string, mode model.BatchMode) ([]model.BatchResult, error) {
+	args := m.Called(ids, mode)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]model.BatchResult), args.Error(1)
+}
+
+func (m *MockUseCase) ExportUsers(_ context.Context, filter, orderBy string, fn func(*model.User) error) error {
+	args := m.Called(filter, orderBy)
+	for _, user := range args.Get(0).([]*model.User) {
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
+}
+
+func (m *MockUseCase) ImportUsers(_ context.Context, next func() (*model.ImportRow, error), policy model.ConflictPolicy) (*model.ImportReport, error) {
+	var rows []model.ImportRow
+	for {
+		row, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, *row)
+	}
+	args := m.Called(rows, policy)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.ImportReport), args.Error(1)
+}
+
+func (m *MockUseCase) SetPassword(_ context.Context, id, currentPassword, newPassword string) error {
+	args := m.Called(id, currentPassword, newPassword)
+	return args.Error(0)
+}
+
+func (m *MockUseCase) Login(_ context.Context, email, password string) (*auth.TokenPair, error) {
+	args := m.Called(email, password)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*auth.TokenPair), args.Error(1)
+}
+
+func (m *MockUseCase) IssueSession(_ context.Context, userID string) (*auth.TokenPair, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*auth.TokenPair), args.Error(1)
+}
+
+func (m *MockUseCase) RefreshSession(_ context.Context, refreshToken string) (*auth.TokenPair, error) {
+	args := m.Called(refreshToken)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*auth.TokenPair), args.Error(1)
+}
+
+func (m *MockUseCase) ListSessions(_ context.Context, userID string) ([]*model.Session, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*model.Session), args.Error(1)
+}
+
+func (m *MockUseCase) RevokeSession(_ context.Context, userID, sessionID string) error {
+	args := m.Called(userID, sessionID)
+	return args.Error(0)
+}
+
+func (m *MockUseCase) VerifyEmail(_ context.Context, token string) error {
+	args := m.Called(token)
 	return args.Error(0)
 }
 
-func (m *MockUseCase) DeleteUser(id string) error {
+func (m *MockUseCase) ResendVerification(_ context.Context, id string) error {
 	args := m.Called(id)
 	return args.Error(0)
 }
 
+func (m *MockUseCase) RequestMagicLink(_ context.Context, email string) error {
+	args := m.Called(email)
+	return args.Error(0)
+}
+
+func (m *MockUseCase) RedeemMagicLink(_ context.Context, token string) (*auth.TokenPair, error) {
+	args := m.Called(token)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*auth.TokenPair), args.Error(1)
+}
+
 // Fixed setupGrpcServer function that doesn't call t.Fatalf in a goroutine
-func setupGrpcServer(t *testing.T, mockUseCase interfaces.UseCaseInterface) (*grpc.ClientConn, pb.UserServiceClient) {
+func setupGrpcServer(t *testing.T, mockUseCase interfaces.UseCaseInterface, opts ...grpc.ServerOption) (*grpc.ClientConn, pb.UserServiceClient) {
 	lis := bufconn.Listen(1024 * 1024)
-	s := grpc.NewServer()
+	s := grpc.NewServer(opts...)
 
 	// Register our service
 	handler.NewUserServer(s, mockUseCase)
@@ -99,6 +267,31 @@ func setupGrpcServer(t *testing.T, mockUseCase interfaces.UseCaseInterface) (*gr
 	return conn, client
 }
 
+// assertErrorReason checks the google.rpc.ErrorInfo detail attached to err
+func assertErrorReason(t *testing.T, err error, reason string) {
+	t.Helper()
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			assert.Equal(t, reason, info.Reason)
+			return
+		}
+	}
+	t.Errorf("no ErrorInfo detail in %v", err)
+}
+
+// assertFieldViolation checks the google.rpc.BadRequest detail attached to err
+func assertFieldViolation(t *testing.T, err error, field string) {
+	t.Helper()
+	for _, detail := range status.Convert(err).Details() {
+		if br, ok := detail.(*errdetails.BadRequest); ok {
+			assert.Len(t, br.FieldViolations, 1)
+			assert.Equal(t, field, br.FieldViolations[0].Field)
+			return
+		}
+	}
+	t.Errorf("no BadRequest detail in %v", err)
+}
+
 func TestUserServiceServer_CreateUser(t *testing.T) {
 	mockUseCase := new(MockUseCase)
 	conn, client := setupGrpcServer(t, mockUseCase)
@@ -129,20 +322,23 @@ func TestUserServiceServer_CreateUser(t *testing.T) {
 	assert.Equal(t, "User Created Successfully", resp.Status)
 	mockUseCase.AssertExpectations(t)
 
-	// Test case: Missing required fields
+	// Test case: Missing required fields are reported the way the UseCase validates them
 	mockUseCase.ExpectedCalls = nil
 	emptyReq := &pb.CreateUserRequest{
 		Name:  "",
 		Email: "",
 	}
+	mockUseCase.On("CreateUser", mock.Anything).Return(nil, usecase.ErrNameRequired)
 
 	// Call the method - this should return an error but not panic
 	_, err = client.CreateUser(context.Background(), emptyReq)
 
 	// Assertions - we expect an error but the test shouldn't crash
 	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "please provide your name and email")
-	mockUseCase.AssertNotCalled(t, "CreateUser")
+	assert.Contains(t, err.Error(), "please provide your name")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assertFieldViolation(t, err, "name")
+	assertErrorReason(t, err, "NAME_REQUIRED")
 
 	// Test case: UseCase returns an error
 	mockUseCase.ExpectedCalls = nil
@@ -160,8 +356,21 @@ func TestUserServiceServer_CreateUser(t *testing.T) {
 
 	// Assertions
 	assert.Error(t, err)
-	assert.Contains(t, err.Error(), "database error")
+	assert.Equal(t, codes.Internal, status.Code(err))
+	// the cause is logged, never sent
+	assert.Equal(t, "internal error", status.Convert(err).Message())
 	mockUseCase.AssertExpectations(t)
+
+	// Test case: Email is already taken
+	mockUseCase.ExpectedCalls = nil
+	mockUseCase.On("CreateUser", mock.Anything).Return(nil, usecase.ErrEmailTaken)
+
+	// Call the method
+	_, err = client.CreateUser(context.Background(), createReq)
+
+	// Assertions
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	assertErrorReason(t, err, "EMAIL_TAKEN")
 }
 
 func TestUserServiceServer_GetUsersList(t *testing.T) {
@@ -175,10 +384,18 @@ func TestUserServiceServer_GetUsersList(t *testing.T) {
 		{Model: gorm.Model{ID: 2}, Name: "User 2", Email: "user2@example.com"},
 	}
 
-	mockUseCase.On("GetUsersList").Return(expectedUsers)
+	mockUseCase.On("GetUsersList", model.ListUsersParams{
+		PageSize: 2,
+		Filter:   `email suffix "@example.com"`,
+		OrderBy:  "name desc",
+	}).Return(&model.UsersPage{Users: expectedUsers, NextPageToken: "next"}, nil)
 
 	// Call the method
-	resp, err := client.GetUsersList(context.Background(), &pb.Empty{})
+	resp, err := client.GetUsersList(context.Background(), &pb.UsersListRequest{
+		PageSize: 2,
+		Filter:   `email suffix "@example.com"`,
+		OrderBy:  "name desc",
+	})
 
 	// Assertions
 	assert.NoError(t, err)
@@ -187,7 +404,72 @@ func TestUserServiceServer_GetUsersList(t *testing.T) {
 	assert.Equal(t, "User 1", resp.Users[0].Name)
 	assert.Equal(t, "user1@example.com", resp.Users[0].Email)
 	assert.Equal(t, "2", resp.Users[1].Id)
+	assert.Equal(t, "next", resp.NextPageToken)
+	mockUseCase.AssertExpectations(t)
+
+	// Test case: UseCase rejects the page token
+	mockUseCase.ExpectedCalls = nil
+	mockUseCase.On("GetUsersList", model.ListUsersParams{PageToken: "bad"}).Return(nil, usecase.ErrInvalidPageToken)
+
+	// Call the method
+	_, err = client.GetUsersList(context.Background(), &pb.UsersListRequest{PageToken: "bad"})
+
+	// Assertions
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid page token")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assertFieldViolation(t, err, "page_token")
+	mockUseCase.AssertExpectations(t)
+}
+
+func TestUserServiceServer_ListUsers(t *testing.T) {
+	mockUseCase := new(MockUseCase)
+	conn, client := setupGrpcServer(t, mockUseCase)
+	defer conn.Close()
+
+	// Test case: Stream all users
+	expectedUsers := []*model.User{
+		{Model: gorm.Model{ID: 1}, Name: "User 1", Email: "user1@example.com"},
+		{Model: gorm.Model{ID: 2}, Name: "User 2", Email: "user2@example.com"},
+	}
+
+	mockUseCase.On("StreamUsers", mock.Anything).Return(expectedUsers, nil)
+
+	// Call the method
+	stream, err := client.ListUsers(context.Background(), &pb.Empty{})
+	assert.NoError(t, err)
+
+	var received []*pb.UserResponse
+	for {
+		user, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(t, err) {
+			break
+		}
+		received = append(received, user)
+	}
+
+	// Assertions
+	assert.Len(t, received, 2)
+	assert.Equal(t, "1", received[0].Id)
+	assert.Equal(t, "User 2", received[1].Name)
 	mockUseCase.AssertExpectations(t)
+
+	// Test case: UseCase fails part way through
+	mockUseCase.ExpectedCalls = nil
+	mockUseCase.On("StreamUsers", mock.Anything).Return(expectedUsers[:1], errors.New("database error"))
+
+	stream, err = client.ListUsers(context.Background(), &pb.Empty{})
+	assert.NoError(t, err)
+
+	_, err = stream.Recv()
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Error(t, err)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.NotContains(t, err.Error(), "database error")
 }
 
 func TestUserServiceServer_GetUser(t *testing.T) {
@@ -197,9 +479,11 @@ func TestUserServiceServer_GetUser(t *testing.T) {
 
 	// Test case: Get user successfully
 	expectedUser := &model.User{
-		Model: gorm.Model{ID: 1},
-		Name:  "Test User",
-		Email: "test@example.com",
+		Model:   gorm.Model{ID: 1},
+		Name:    "Test User",
+		Email:   "test@example.com",
+		Status:  model.UserStatusPending,
+		Version: 4,
 	}
 
 	mockUseCase.On("GetUser", "1").Return(expectedUser, nil)
@@ -212,17 +496,68 @@ func TestUserServiceServer_GetUser(t *testing.T) {
 	assert.Equal(t, "1", resp.Id)
 	assert.Equal(t, "Test User", resp.Name)
 	assert.Equal(t, "test@example.com", resp.Email)
+	assert.Equal(t, "pending", resp.Status)
+	assert.Equal(t, uint64(4), resp.Version)
 	mockUseCase.AssertExpectations(t)
 
 	// Test case: User not found
 	mockUseCase.ExpectedCalls = nil
-	mockUseCase.On("GetUser", "999").Return(nil, gorm.ErrRecordNotFound)
+	mockUseCase.On("GetUser", "999").Return(nil, errs.NewNotFound("USER_NOT_FOUND", "user not found", gorm.ErrRecordNotFound))
 
 	// Call the method
 	_, err = client.GetUser(context.Background(), &pb.SingleUserRequest{Id: "999"})
 
 	// Assertions
 	assert.Error(t, err)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assertErrorReason(t, err, "USER_NOT_FOUND")
+	mockUseCase.AssertExpectations(t)
+}
+
+func TestUserServiceServer_SearchUsers(t *testing.T) {
+	mockUseCase := new(MockUseCase)
+	conn, client := setupGrpcServer(t, mockUseCase)
+	defer conn.Close()
+
+	// Test case: Hits are returned in the order the use case ranked them
+	hits := []*model.UserSearchResult{
+		{
+			User:         &model.User{Model: gorm.Model{ID: 2}, Name: "Alice", Email: "alice@example.com"},
+			Score:        3.5,
+			NameSnippet:  "<mark>Ali</mark>ce",
+			EmailSnippet: "alice@example.com",
+		},
+		{
+			User:         &model.User{Model: gorm.Model{ID: 1}, Name: "Carol", Email: "carol@alimail.com"},
+			Score:        1.25,
+			NameSnippet:  "Carol",
+			EmailSnippet: "carol@<mark>alimail</mark>.com",
+		},
+	}
+	mockUseCase.On("SearchUsers", "ali", 10).Return(hits, nil)
+
+	// Call the method
+	resp, err := client.SearchUsers(context.Background(), &pb.SearchUsersRequest{Query: "ali", Limit: 10})
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.Len(t, resp.Results, 2)
+	assert.Equal(t, "2", resp.Results[0].User.Id)
+	assert.Equal(t, 3.5, resp.Results[0].Score)
+	assert.Equal(t, "<mark>Ali</mark>ce", resp.Results[0].NameSnippet)
+	assert.Equal(t, "carol@<mark>alimail</mark>.com", resp.Results[1].EmailSnippet)
+	mockUseCase.AssertExpectations(t)
+
+	// Test case: Search is unavailable on this server
+	mockUseCase.ExpectedCalls = nil
+	mockUseCase.On("SearchUsers", "ali", 0).Return(nil, errs.NewFailedPrecondition("SEARCH_UNAVAILABLE", "full-text search is not available on this server"))
+
+	// Call the method
+	_, err = client.SearchUsers(context.Background(), &pb.SearchUsersRequest{Query: "ali"})
+
+	// Assertions
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assertErrorReason(t, err, "SEARCH_UNAVAILABLE")
 	mockUseCase.AssertExpectations(t)
 }
 
@@ -242,7 +577,7 @@ func TestUserServiceServer_UpdateUser(t *testing.T) {
 		return u.ID == uint(updateReq.Id) &&
 			u.Name == updateReq.Name &&
 			u.Email == updateReq.Email
-	})).Return(nil)
+	}), []string(nil)).Return(nil)
 
 	// Call the method
 	resp, err := client.UpdateUser(context.Background(), updateReq)
@@ -267,7 +602,7 @@ func TestUserServiceServer_UpdateUser(t *testing.T) {
 		return u.ID == uint(errorReq.Id) &&
 			u.Name == errorReq.Name &&
 			u.Email == errorReq.Email
-	})).Return(errors.New("user not found"))
+	}), mock.Anything).Return(errors.New("user not found"))
 
 	// Call the method
 	resp, err = client.UpdateUser(context.Background(), errorReq)
@@ -279,4 +614,442 @@ func TestUserServiceServer_UpdateUser(t *testing.T) {
 		assert.Equal(t, "Failed to update user", resp.Status)
 	}
 	mockUseCase.AssertExpectations(t)
+
+	// Test case: Updating someone else's record is denied
+	mockUseCase.ExpectedCalls = nil
+	mockUseCase.On("UpdateUser", mock.Anything, mock.Anything).Return(usecase.ErrNotOwner)
+
+	// Call the method
+	_, err = client.UpdateUser(context.Background(), &pb.UpdateUserRequest{Id: 2, Name: "Other", Email: "other@example.com"})
+
+	// Assertions
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assertErrorReason(t, err, "NOT_OWNER")
+
+	// Test case: The version is passed on and a stale one aborts
+	mockUseCase.ExpectedCalls = nil
+	mockUseCase.On("UpdateUser", mock.MatchedBy(func(u *model.User) bool { return u.Version == 3 }), mock.Anything).Return(usecase.ErrVersionConflict)
+
+	_, err = client.UpdateUser(context.Background(), &pb.UpdateUserRequest{Id: 1, Name: "Stale", Email: "stale@example.com", Version: 3})
+
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	assertErrorReason(t, err, "VERSION_CONFLICT")
+	mockUseCase.AssertExpectations(t)
+
+	// Test case: The update mask is passed on as its paths
+	mockUseCase.ExpectedCalls = nil
+	mockUseCase.On("UpdateUser", mock.MatchedBy(func(u *model.User) bool { return u.Name == "Renamed" }), []string{"name"}).Return(nil)
+
+	_, err = client.UpdateUser(context.Background(), &pb.UpdateUserRequest{Id: 1, Name: "Renamed", UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"name"}}})
+
+	assert.NoError(t, err)
+	mockUseCase.AssertExpectations(t)
+}
+
+func TestUserServiceServer_SetPassword(t *testing.T) {
+	mockUseCase := new(MockUseCase)
+	conn, client := setupGrpcServer(t, mockUseCase)
+	defer conn.Close()
+
+	// Test case: Set a password successfully
+	mockUseCase.On("SetPassword", "2", "old password", "new password").Return(nil)
+
+	resp, err := client.SetPassword(context.Background(), &pb.SetPasswordRequest{Id: "2", CurrentPassword: "old password", NewPassword: "new password"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Password set successfully", resp.Status)
+	mockUseCase.AssertExpectations(t)
+
+	// Test case: A weak password names the offending field
+	mockUseCase.ExpectedCalls = nil
+	mockUseCase.On("SetPassword", "2", "", "short").Return(usecase.ErrPasswordTooShort)
+
+	_, err = client.SetPassword(context.Background(), &pb.SetPasswordRequest{Id: "2", NewPassword: "short"})
+
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assertErrorReason(t, err, "PASSWORD_TOO_SHORT")
+	assertFieldViolation(t, err, "new_password")
+}
+
+func TestUserServiceServer_VerifyEmail(t *testing.T) {
+	mockUseCase := new(MockUseCase)
+	conn, client := setupGrpcServer(t, mockUseCase)
+	defer conn.Close()
+	ctx := context.Background()
+
+	// Test case: A valid token verifies the address
+	mockUseCase.On("VerifyEmail", "good").Return(nil)
+	resp, err := client.VerifyEmail(ctx, &pb.VerifyEmailRequest{Token: "good"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Email verified successfully", resp.Status)
+
+	// Test case: A bad token is an invalid argument on the token field
+	mockUseCase.On("VerifyEmail", "bad").Return(usecase.ErrInvalidVerificationToken)
+	_, err = client.VerifyEmail(ctx, &pb.VerifyEmailRequest{Token: "bad"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assertErrorReason(t, err, "INVALID_VERIFICATION_TOKEN")
+	assertFieldViolation(t, err, "token")
+
+	// Test case: Resending to a verified account fails its precondition
+	mockUseCase.On("ResendVerification", "7").Return(nil)
+	mockUseCase.On("ResendVerification", "8").Return(usecase.ErrEmailAlreadyVerified)
+	resp, err = client.ResendVerification(ctx, &pb.SingleUserRequest{Id: "7"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Verification email sent", resp.Status)
+	_, err = client.ResendVerification(ctx, &pb.SingleUserRequest{Id: "8"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assertErrorReason(t, err, "EMAIL_ALREADY_VERIFIED")
+	mockUseCase.AssertExpectations(t)
+}
+
+func TestUserServiceServer_MagicLink(t *testing.T) {
+	mockUseCase := new(MockUseCase)
+	conn, client := setupGrpcServer(t, mockUseCase)
+	defer conn.Close()
+	ctx := context.Background()
+
+	// Test case: Known and unknown addresses get the same answer
+	mockUseCase.On("RequestMagicLink", "a@example.com").Return(nil)
+	mockUseCase.On("RequestMagicLink", "nobody@example.com").Return(nil)
+	known, err := client.RequestMagicLink(ctx, &pb.RequestMagicLinkRequest{Email: "a@example.com"})
+	assert.NoError(t, err)
+	unknown, err := client.RequestMagicLink(ctx, &pb.RequestMagicLinkRequest{Email: "nobody@example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, known.Status, unknown.Status)
+
+	// Test case: Too many requests for an address are resource exhausted
+	mockUseCase.On("RequestMagicLink", "busy@example.com").Return(usecase.ErrTooManyMagicLinks)
+	_, err = client.RequestMagicLink(ctx, &pb.RequestMagicLinkRequest{Email: "busy@example.com"})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assertErrorReason(t, err, "MAGIC_LINK_RATE_LIMITED")
+
+	// Test case: A redeemed link returns session tokens, a bad one is unauthenticated
+	mockUseCase.On("RedeemMagicLink", "good").Return(&auth.TokenPair{SessionID: "s1", AccessToken: "access", RefreshToken: "refresh", ExpiresAt: time.Now().Add(time.Minute)}, nil)
+	mockUseCase.On("RedeemMagicLink", "bad").Return(nil, usecase.ErrInvalidMagicLink)
+	tokens, err := client.RedeemMagicLink(ctx, &pb.RedeemMagicLinkRequest{Token: "good"})
+	assert.NoError(t, err)
+	assert.Equal(t, "access", tokens.AccessToken)
+	assert.Equal(t, "s1", tokens.SessionId)
+	_, err = client.RedeemMagicLink(ctx, &pb.RedeemMagicLinkRequest{Token: "bad"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assertErrorReason(t, err, "INVALID_MAGIC_LINK")
+	mockUseCase.AssertExpectations(t)
+}
+
+func TestUserServiceServer_Login(t *testing.T) {
+	mockUseCase := new(MockUseCase)
+	conn, client := setupGrpcServer(t, mockUseCase)
+	defer conn.Close()
+
+	// Test case: Valid credentials return a bearer token pair
+	mockUseCase.On("Login", "a@example.com", "correct horse").Return(&auth.TokenPair{
+		SessionID:    "s1",
+		AccessToken:  "access",
+		RefreshToken: "refresh",
+		ExpiresAt:    time.Now().Add(15 * time.Minute),
+	}, nil)
+
+	resp, err := client.Login(context.Background(), &pb.LoginRequest{Email: "a@example.com", Password: "correct horse"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "access", resp.AccessToken)
+	assert.Equal(t, "refresh", resp.RefreshToken)
+	assert.Equal(t, "Bearer", resp.TokenType)
+	assert.Equal(t, "s1", resp.SessionId)
+	assert.InDelta(t, 900, resp.ExpiresIn, 2)
+
+	// Test case: Bad credentials and locked accounts are Unauthenticated
+	for reason, err := range map[string]error{
+		"INVALID_CREDENTIALS": usecase.ErrInvalidCredentials,
+		"ACCOUNT_LOCKED":      usecase.ErrAccountLocked,
+	} {
+		mockUseCase.ExpectedCalls = nil
+		mockUseCase.On("Login", "a@example.com", "wrong").Return(nil, err)
+
+		_, err = client.Login(context.Background(), &pb.LoginRequest{Email: "a@example.com", Password: "wrong"})
+
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		assertErrorReason(t, err, reason)
+	}
+}
+
+func TestUserServiceServer_Sessions(t *testing.T) {
+	mockUseCase := new(MockUseCase)
+	conn, client := setupGrpcServer(t, mockUseCase)
+	defer conn.Close()
+	ctx := context.Background()
+
+	// Test case: Issuing and refreshing return the tokens and the session ID
+	pair := &auth.TokenPair{SessionID: "s1", AccessToken: "access", RefreshToken: "s1.next", ExpiresAt: time.Now().Add(time.Minute)}
+	mockUseCase.On("IssueSession", "7").Return(pair, nil)
+	mockUseCase.On("RefreshSession", "s1.current").Return(pair, nil)
+
+	issued, err := client.IssueSession(ctx, &pb.IssueSessionRequest{UserId: "7"})
+	assert.NoError(t, err)
+	assert.Equal(t, "s1", issued.SessionId)
+	assert.Equal(t, "s1.next", issued.RefreshToken)
+	refreshed, err := client.RefreshSession(ctx, &pb.RefreshSessionRequest{RefreshToken: "s1.current"})
+	assert.NoError(t, err)
+	assert.Equal(t, "access", refreshed.AccessToken)
+
+	// Test case: A reused refresh token is Unauthenticated
+	mockUseCase.On("RefreshSession", "s1.old").Return(nil, usecase.ErrRefreshTokenReused)
+	_, err = client.RefreshSession(ctx, &pb.RefreshSessionRequest{RefreshToken: "s1.old"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assertErrorReason(t, err, "REFRESH_TOKEN_REUSED")
+
+	// Test case: Sessions are listed with their timestamps
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	mockUseCase.On("ListSessions", "7").Return([]*model.Session{
+		{ID: "s1", UserID: 7, CreatedAt: created, LastUsedAt: created.Add(time.Hour), ExpiresAt: created.Add(24 * time.Hour)},
+	}, nil)
+	list, err := client.ListSessions(ctx, &pb.ListSessionsRequest{UserId: "7"})
+	assert.NoError(t, err)
+	if assert.Len(t, list.Sessions, 1) {
+		assert.Equal(t, "s1", list.Sessions[0].Id)
+		assert.Equal(t, "7", list.Sessions[0].UserId)
+		assert.Equal(t, created, list.Sessions[0].CreatedAt.AsTime())
+		assert.Equal(t, created.Add(time.Hour), list.Sessions[0].LastUsedAt.AsTime())
+		assert.Equal(t, created.Add(24*time.Hour), list.Sessions[0].ExpiresAt.AsTime())
+	}
+
+	// Test case: Revoking an unknown session is NotFound
+	mockUseCase.On("RevokeSession", "7", "s1").Return(nil)
+	mockUseCase.On("RevokeSession", "7", "s2").Return(errs.NewNotFound("SESSION_NOT_FOUND", "session not found", nil))
+	resp, err := client.RevokeSession(ctx, &pb.RevokeSessionRequest{UserId: "7", SessionId: "s1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Session revoked successfully", resp.Status)
+	_, err = client.RevokeSession(ctx, &pb.RevokeSessionRequest{UserId: "7", SessionId: "s2"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestUserServiceServer_DeletedUsers(t *testing.T) {
+	mockUseCase := new(MockUseCase)
+	conn, client := setupGrpcServer(t, mockUseCase)
+	defer conn.Close()
+	deletedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// Test case: Listing with include_deleted marks the deleted users
+	mockUseCase.On("GetUsersList", model.ListUsersParams{IncludeDeleted: true}).Return(&model.UsersPage{Users: []*model.User{
+		{Model: gorm.Model{ID: 1}, Name: "Live"},
+		{Model: gorm.Model{ID: 2, DeletedAt: gorm.DeletedAt{Time: deletedAt, Valid: true}}, Name: "Gone"},
+	}}, nil)
+
+	list, err := client.GetUsersList(context.Background(), &pb.UsersListRequest{IncludeDeleted: true})
+
+	assert.NoError(t, err)
+	if assert.Len(t, list.Users, 2) {
+		assert.Nil(t, list.Users[0].DeletedAt)
+		assert.Equal(t, deletedAt, list.Users[1].DeletedAt.AsTime())
+	}
+
+	// Test case: Undelete returns the restored user
+	mockUseCase.On("UndeleteUser", "2").Return(&model.User{Model: gorm.Model{ID: 2}, Name: "Gone", Version: 3}, nil)
+
+	user, err := client.UndeleteUser(context.Background(), &pb.SingleUserRequest{Id: "2"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "2", user.Id)
+	assert.Equal(t, uint64(3), user.Version)
+	assert.Nil(t, user.DeletedAt)
+
+	// Test case: Purge a deleted user
+	mockUseCase.On("PurgeUser", "2").Return(nil)
+
+	resp, err := client.PurgeUser(context.Background(), &pb.SingleUserRequest{Id: "2"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "User purged successfully", resp.Status)
+
+	// Test case: A live user cannot be purged or undeleted
+	mockUseCase.On("PurgeUser", "1").Return(usecase.ErrUserNotDeleted)
+	mockUseCase.On("UndeleteUser", "1").Return(nil, usecase.ErrUserNotDeleted)
+
+	_, err = client.PurgeUser(context.Background(), &pb.SingleUserRequest{Id: "1"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assertErrorReason(t, err, "USER_NOT_DELETED")
+	_, err = client.UndeleteUser(context.Background(), &pb.SingleUserRequest{Id: "1"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	mockUseCase.AssertExpectations(t)
+}
+
+func TestUserServiceServer_Batch(t *testing.T) {
+	mockUseCase := new(MockUseCase)
+	conn, client := setupGrpcServer(t, mockUseCase)
+	defer conn.Close()
+
+	// Test case: Per item results carry the user or the reason the item failed
+	mockUseCase.On("BatchCreateUsers", []*model.User{{Name: "A", Email: "a@example.com"}, {Name: "B", Email: "taken@example.com"}}, model.BatchBestEffort).Return([]model.BatchResult{
+		{User: &model.User{Model: gorm.Model{ID: 4}, Name: "A", Email: "a@example.com"}},
+		{Err: usecase.ErrEmailTaken},
+	}, nil)
+
+	resp, err := client.BatchCreateUsers(context.Background(), &pb.BatchCreateUsersRequest{
+		Users: []*pb.CreateUserRequest{{Name: "A", Email: "a@example.com"}, {Name: "B", Email: "taken@example.com"}},
+		Mode:  pb.BatchMode_BEST_EFFORT,
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), resp.Succeeded)
+	assert.Equal(t, int32(1), resp.Failed)
+	assert.Equal(t, "4", resp.Results[0].User.Id)
+	assert.Nil(t, resp.Results[0].Error)
+	assert.Equal(t, int32(1), resp.Results[1].Index)
+	assert.Equal(t, int32(codes.AlreadyExists), resp.Results[1].Error.Code)
+	assert.Equal(t, "EMAIL_TAKEN", resp.Results[1].Error.Reason)
+
+	// Test case: Updates pass their masks on, the mode defaults to all or nothing
+	mockUseCase.On("BatchUpdateUsers", []model.UserUpdate{
+		{User: &model.User{Model: gorm.Model{ID: 1}, Name: "Uno", Version: 2}, Fields: []string{"name"}},
+	}, model.BatchAllOrNothing).Return([]model.BatchResult{{Err: usecase.ErrVersionConflict}}, nil)
+
+	resp, err = client.BatchUpdateUsers(context.Background(), &pb.BatchUpdateUsersRequest{
+		Users: []*pb.UpdateUserRequest{{Id: 1, Name: "Uno", Version: 2, UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"name"}}}},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, int32(codes.Aborted), resp.Results[0].Error.Code)
+	assert.Equal(t, "VERSION_CONFLICT", resp.Results[0].Error.Reason)
+
+	// Test case: Field errors name the field, failures of the whole call are a status
+	mockUseCase.On("BatchDeleteUsers", []string{"x"}, model.BatchAllOrNothing).Return([]model.BatchResult{{Err: usecase.ErrInvalidUserID}}, nil)
+	mockUseCase.On("BatchDeleteUsers", []string{"1"}, model.BatchAllOrNothing).Return(nil, usecase.ErrBatchTooLarge)
+
+	resp, err = client.BatchDeleteUsers(context.Background(), &pb.BatchDeleteUsersRequest{Ids: []string{"x"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "id", resp.Results[0].Error.Field)
+	assert.Nil(t, resp.Results[0].User)
+
+	_, err = client.BatchDeleteUsers(context.Background(), &pb.BatchDeleteUsersRequest{Ids: []string{"1"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assertErrorReason(t, err, "BATCH_TOO_LARGE")
+	mockUseCase.AssertExpectations(t)
+}
+
+func TestUserServiceServer_ExportUsers(t *testing.T) {
+	mockUseCase := new(MockUseCase)
+	conn, client := setupGrpcServer(t, mockUseCase)
+	defer conn.Close()
+
+	// Test case: Matching users are streamed with their timestamps
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	mockUseCase.On("ExportUsers", `name prefix "A"`, "created_at desc").Return([]*model.User{
+		{Model: gorm.Model{ID: 1, CreatedAt: created, UpdatedAt: created}, Name: "Ann", Email: "ann@example.com", Status: model.UserStatusActive, Version: 2},
+	}, nil)
+
+	stream, err := client.ExportUsers(context.Background(), &pb.ExportUsersRequest{Filter: `name prefix "A"`, OrderBy: "created_at desc"})
+	assert.NoError(t, err)
+	user, err := stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, "Ann", user.Name)
+	assert.Equal(t, uint64(2), user.Version)
+	assert.True(t, created.Equal(user.CreatedAt.AsTime()))
+	_, err = stream.Recv()
+	assert.Equal(t, io.EOF, err)
+
+	// Test case: A bad filter fails the stream
+	mockUseCase.On("ExportUsers", "bogus", "").Return([]*model.User{}, errs.NewInvalidArgument("filter", "INVALID_FILTER", "invalid filter"))
+
+	stream, err = client.ExportUsers(context.Background(), &pb.ExportUsersRequest{Filter: "bogus"})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assertErrorReason(t, err, "INVALID_FILTER")
+	mockUseCase.AssertExpectations(t)
+}
+
+func TestUserServiceServer_ImportUsers(t *testing.T) {
+	mockUseCase := new(MockUseCase)
+	conn, client := setupGrpcServer(t, mockUseCase)
+	defer conn.Close()
+
+	// Test case: Rows from every message reach the usecase with the policy of the first
+	mockUseCase.On("ImportUsers", []model.ImportRow{
+		{Line: 2, Name: "Ann", Email: "ann@example.com"},
+		{Line: 3, Name: "Bob", Email: "bob@example.com"},
+		{Line: 4, Name: "", Email: "cid@example.com"},
+	}, model.ConflictOverwrite).Return(&model.ImportReport{
+		Created:  []int64{2},
+		Updated:  []int64{3},
+		Rejected: []model.ImportRejection{{Line: 4, Email: "cid@example.com", Err: usecase.ErrNameRequired}},
+	}, nil)
+
+	stream, err := client.ImportUsers(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Send(&pb.ImportUsersRequest{
+		OnConflict: pb.ConflictPolicy_OVERWRITE,
+		Rows:       []*pb.ImportRow{{Line: 2, Name: "Ann", Email: "ann@example.com"}, {Line: 3, Name: "Bob", Email: "bob@example.com"}},
+	}))
+	assert.NoError(t, stream.Send(&pb.ImportUsersRequest{OnConflict: pb.ConflictPolicy_FAIL}))
+	assert.NoError(t, stream.Send(&pb.ImportUsersRequest{Rows: []*pb.ImportRow{{Line: 4, Email: "cid@example.com"}}}))
+	resp, err := stream.CloseAndRecv()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2}, resp.Created)
+	assert.Equal(t, []int64{3}, resp.Updated)
+	if assert.Len(t, resp.Rejected, 1) {
+		assert.Equal(t, int64(4), resp.Rejected[0].Line)
+		assert.Equal(t, "NAME_REQUIRED", resp.Rejected[0].Error.Reason)
+		assert.Equal(t, "name", resp.Rejected[0].Error.Field)
+	}
+
+	// Test case: An empty stream is an empty import skipping conflicts
+	mockUseCase.On("ImportUsers", []model.ImportRow(nil), model.ConflictSkip).Return(&model.ImportReport{}, nil)
+
+	stream, err = client.ImportUsers(context.Background())
+	assert.NoError(t, err)
+	resp, err = stream.CloseAndRecv()
+
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Created)
+	mockUseCase.AssertExpectations(t)
+}
+
+func TestUserServiceServer_CancelAbortsQuery(t *testing.T) {
+	// wire the real use case and repository so the RPC context has to travel
+	// all the way down to sqlite
+	db, err := gorm.Open(sqlite.Open("file:cancel?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	if err := db.AutoMigrate(&model.User{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+
+	// stand in for an expensive query: before every SELECT run one that never
+	// finishes on its own, using the context GORM was handed
+	started := make(chan struct{}, 1)
+	aborted := make(chan error, 1)
+	err = db.Callback().Query().Before("gorm:query").Register("test:slow_query", func(tx *gorm.DB) {
+		started <- struct{}{}
+		var n int64
+		err := tx.Statement.ConnPool.QueryRowContext(tx.Statement.Context,
+			"WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c) SELECT count(*) FROM c").Scan(&n)
+		aborted <- err
+		tx.AddError(err)
+	})
+	if err != nil {
+		t.Fatalf("Failed to register callback: %v", err)
+	}
+
+	conn, client := setupGrpcServer(t, usecase.NewUseCase(repository.NewRepo(db)))
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	// Call the method
+	_, err = client.GetUser(ctx, &pb.SingleUserRequest{Id: "1"})
+
+	// Assertions
+	assert.Equal(t, codes.Canceled, status.Code(err))
+	select {
+	case queryErr := <-aborted:
+		assert.Error(t, queryErr, "the in-flight query should have been interrupted")
+	case <-time.After(5 * time.Second):
+		t.Fatal("the in-flight query kept running after the RPC was cancelled")
+	}
 }
@@ -4,11 +4,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"time"
 
+	"github.com/yishak-cs/CleanGrpc/Internal/auth"
 	"github.com/yishak-cs/CleanGrpc/Internal/model"
 	interfaces "github.com/yishak-cs/CleanGrpc/pkg/v1"
 	pb "github.com/yishak-cs/CleanGrpc/proto"
 	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"gorm.io/gorm"
 )
 
@@ -28,45 +32,66 @@ func (server *UserServiceServer) CreateUser(ctx context.Context, creq *pb.Create
 	//transform the CreatUserRequest type to model.User type
 	model := server.transformMessageToModel(creq)
 
-	//validate that the models Name and Email are not empty strings
-	if model.Email == "" || model.Name == "" {
-		return &pb.Response{Status: "bad request"}, errors.New("please provide your name and email")
-	}
-
-	//call UseCase's CreateUser method which accepts User model
-	_, err := server.usecase.CreateUser(model)
+	//call UseCase's CreateUser method which accepts User model, it validates
+	//the name and email
+	_, err := server.usecase.CreateUser(ctx, model)
 	if err != nil {
-		return &pb.Response{Status: "Something went wrong"}, err
+		return &pb.Response{Status: "Something went wrong"}, toStatus(err)
 	}
 
 	return &pb.Response{Status: "User Created Successfully"}, nil
 }
 
-func (server *UserServiceServer) GetUsersList(ctx context.Context, empty *pb.Empty) (*pb.UsersList, error) {
-	//get all the user model instances
-	UserList := server.usecase.GetUsersList()
+func (server *UserServiceServer) GetUsersList(ctx context.Context, req *pb.UsersListRequest) (*pb.UsersList, error) {
+	//get the requested page of user model instances
+	page, err := server.usecase.GetUsersList(ctx, model.ListUsersParams{
+		PageSize:       int(req.PageSize),
+		PageToken:      req.PageToken,
+		Filter:         req.Filter,
+		OrderBy:        req.OrderBy,
+		IncludeDeleted: req.IncludeDeleted,
+	})
+	if err != nil {
+		return &pb.UsersList{}, toStatus(err)
+	}
 
 	//create a slice of pointers to UserResponse
 	userResponses := []*pb.UserResponse{}
 
 	// loop through the user transforming to and appending UserResponse
-	for _, k := range UserList {
+	for _, k := range page.Users {
 		userResponses = append(userResponses, server.transformModelToMessage(k))
 	}
 
 	// create the user list response according to the message definition
-	users := &pb.UsersList{Users: userResponses}
+	users := &pb.UsersList{Users: userResponses, NextPageToken: page.NextPageToken}
 
 	return users, nil
 }
 
+func (server *UserServiceServer) ListUsers(empty *pb.Empty, stream grpc.ServerStreamingServer[pb.UserResponse]) error {
+	//send each user as soon as it is read so neither side buffers the table
+	err := server.usecase.StreamUsers(stream.Context(), func(user *model.User) error {
+		return stream.Send(server.transformModelToMessage(user))
+	})
+	return toStatus(err)
+}
+
+func (server *UserServiceServer) ExportUsers(req *pb.ExportUsersRequest, stream grpc.ServerStreamingServer[pb.UserResponse]) error {
+	//send each user as its page is read, the export is never held in memory
+	err := server.usecase.ExportUsers(stream.Context(), req.Filter, req.OrderBy, func(user *model.User) error {
+		return stream.Send(server.transformModelToMessage(user))
+	})
+	return toStatus(err)
+}
+
 func (server *UserServiceServer) GetUser(ctx context.Context, req *pb.SingleUserRequest) (*pb.UserResponse, error) {
 	//call usecase's GetUser model which accepts id string and return a model instance
-	user, err := server.usecase.GetUser(req.Id)
+	user, err := server.usecase.GetUser(ctx, req.Id)
 
 	//handle error
 	if err != nil {
-		return &pb.UserResponse{}, err
+		return &pb.UserResponse{}, toStatus(err)
 	}
 
 	//transform the model to UserResponse
@@ -75,32 +100,274 @@ func (server *UserServiceServer) GetUser(ctx context.Context, req *pb.SingleUser
 	return userResponse, nil
 }
 
+func (server *UserServiceServer) SearchUsers(ctx context.Context, req *pb.SearchUsersRequest) (*pb.SearchUsersResponse, error) {
+	//get the hits ordered by relevance
+	hits, err := server.usecase.SearchUsers(ctx, req.Query, int(req.Limit))
+	if err != nil {
+		return &pb.SearchUsersResponse{}, toStatus(err)
+	}
+
+	results := make([]*pb.SearchResult, 0, len(hits))
+	for _, hit := range hits {
+		results = append(results, &pb.SearchResult{
+			User:         server.transformModelToMessage(hit.User),
+			Score:        hit.Score,
+			NameSnippet:  hit.NameSnippet,
+			EmailSnippet: hit.EmailSnippet,
+		})
+	}
+
+	return &pb.SearchUsersResponse{Results: results}, nil
+}
+
 func (server *UserServiceServer) UpdateUser(ctx context.Context, upreq *pb.UpdateUserRequest) (*pb.Response, error) {
 	// Create user model from request
 	user := &model.User{
-		Model: gorm.Model{ID: uint(upreq.Id)},
-		Name:  upreq.Name,
-		Email: upreq.Email,
+		Model:   gorm.Model{ID: uint(upreq.Id)},
+		Name:    upreq.Name,
+		Email:   upreq.Email,
+		Version: upreq.Version,
 	}
 
-	// Call usecase update method
-	err := server.usecase.UpdateUser(user)
+	// Call usecase update method, writing only what the mask names
+	err := server.usecase.UpdateUser(ctx, user, upreq.GetUpdateMask().GetPaths())
 	if err != nil {
-		return &pb.Response{Status: "Failed to update user"}, err
+		return &pb.Response{Status: "Failed to update user"}, toStatus(err)
 	}
 
 	return &pb.Response{Status: "User updated successfully"}, nil
 }
 
 func (server *UserServiceServer) DeleteUser(ctx context.Context, req *pb.SingleUserRequest) (*pb.Response, error) {
-	err := server.usecase.DeleteUser(req.Id)
+	err := server.usecase.DeleteUser(ctx, req.Id)
 	if err != nil {
-		return &pb.Response{Status: "Failed to delete user"}, err
+		return &pb.Response{Status: "Failed to delete user"}, toStatus(err)
 	}
 
 	return &pb.Response{Status: "User deleted successfully"}, nil
 }
 
+func (server *UserServiceServer) UndeleteUser(ctx context.Context, req *pb.SingleUserRequest) (*pb.UserResponse, error) {
+	user, err := server.usecase.UndeleteUser(ctx, req.Id)
+	if err != nil {
+		return &pb.UserResponse{}, toStatus(err)
+	}
+
+	return server.transformModelToMessage(user), nil
+}
+
+func (server *UserServiceServer) PurgeUser(ctx context.Context, req *pb.SingleUserRequest) (*pb.Response, error) {
+	err := server.usecase.PurgeUser(ctx, req.Id)
+	if err != nil {
+		return &pb.Response{Status: "Failed to purge user"}, toStatus(err)
+	}
+
+	return &pb.Response{Status: "User purged successfully"}, nil
+}
+
+func (server *UserServiceServer) SetPassword(ctx context.Context, req *pb.SetPasswordRequest) (*pb.Response, error) {
+	err := server.usecase.SetPassword(ctx, req.Id, req.CurrentPassword, req.NewPassword)
+	if err != nil {
+		return &pb.Response{Status: "Failed to set password"}, toStatus(err)
+	}
+
+	return &pb.Response{Status: "Password set successfully"}, nil
+}
+
+func (server *UserServiceServer) Login(ctx context.Context, req *pb.LoginRequest) (*pb.SessionTokens, error) {
+	tokens, err := server.usecase.Login(ctx, req.Email, req.Password)
+	if err != nil {
+		return &pb.SessionTokens{}, toStatus(err)
+	}
+
+	return server.transformTokensToMessage(tokens), nil
+}
+
+func (server *UserServiceServer) IssueSession(ctx context.Context, req *pb.IssueSessionRequest) (*pb.SessionTokens, error) {
+	tokens, err := server.usecase.IssueSession(ctx, req.UserId)
+	if err != nil {
+		return &pb.SessionTokens{}, toStatus(err)
+	}
+
+	return server.transformTokensToMessage(tokens), nil
+}
+
+func (server *UserServiceServer) RefreshSession(ctx context.Context, req *pb.RefreshSessionRequest) (*pb.SessionTokens, error) {
+	tokens, err := server.usecase.RefreshSession(ctx, req.RefreshToken)
+	if err != nil {
+		return &pb.SessionTokens{}, toStatus(err)
+	}
+
+	return server.transformTokensToMessage(tokens), nil
+}
+
+func (server *UserServiceServer) ListSessions(ctx context.Context, req *pb.ListSessionsRequest) (*pb.ListSessionsResponse, error) {
+	sessions, err := server.usecase.ListSessions(ctx, req.UserId)
+	if err != nil {
+		return &pb.ListSessionsResponse{}, toStatus(err)
+	}
+
+	messages := make([]*pb.Session, 0, len(sessions))
+	for _, session := range sessions {
+		messages = append(messages, &pb.Session{
+			Id:         session.ID,
+			UserId:     fmt.Sprintf("%d", session.UserID),
+			CreatedAt:  timestamppb.New(session.CreatedAt),
+			LastUsedAt: timestamppb.New(session.LastUsedAt),
+			ExpiresAt:  timestamppb.New(session.ExpiresAt),
+		})
+	}
+
+	return &pb.ListSessionsResponse{Sessions: messages}, nil
+}
+
+func (server *UserServiceServer) RevokeSession(ctx context.Context, req *pb.RevokeSessionRequest) (*pb.Response, error) {
+	err := server.usecase.RevokeSession(ctx, req.UserId, req.SessionId)
+	if err != nil {
+		return &pb.Response{Status: "Failed to revoke session"}, toStatus(err)
+	}
+
+	return &pb.Response{Status: "Session revoked successfully"}, nil
+}
+
+func (server *UserServiceServer) VerifyEmail(ctx context.Context, req *pb.VerifyEmailRequest) (*pb.Response, error) {
+	err := server.usecase.VerifyEmail(ctx, req.Token)
+	if err != nil {
+		return &pb.Response{Status: "Failed to verify email"}, toStatus(err)
+	}
+
+	return &pb.Response{Status: "Email verified successfully"}, nil
+}
+
+func (server *UserServiceServer) ResendVerification(ctx context.Context, req *pb.SingleUserRequest) (*pb.Response, error) {
+	err := server.usecase.ResendVerification(ctx, req.Id)
+	if err != nil {
+		return &pb.Response{Status: "Failed to resend verification email"}, toStatus(err)
+	}
+
+	return &pb.Response{Status: "Verification email sent"}, nil
+}
+
+func (server *UserServiceServer) RequestMagicLink(ctx context.Context, req *pb.RequestMagicLinkRequest) (*pb.Response, error) {
+	err := server.usecase.RequestMagicLink(ctx, req.Email)
+	if err != nil {
+		return &pb.Response{Status: "Failed to request sign-in link"}, toStatus(err)
+	}
+
+	// the same answer whether or not the email belongs to a user
+	return &pb.Response{Status: "If the email belongs to an account, a sign-in link has been sent"}, nil
+}
+
+func (server *UserServiceServer) RedeemMagicLink(ctx context.Context, req *pb.RedeemMagicLinkRequest) (*pb.SessionTokens, error) {
+	tokens, err := server.usecase.RedeemMagicLink(ctx, req.Token)
+	if err != nil {
+		return &pb.SessionTokens{}, toStatus(err)
+	}
+
+	return server.transformTokensToMessage(tokens), nil
+}
+
+func (server *UserServiceServer) BatchCreateUsers(ctx context.Context, req *pb.BatchCreateUsersRequest) (*pb.BatchUsersResponse, error) {
+	users := make([]*model.User, len(req.Users))
+	for i, user := range req.Users {
+		users[i] = server.transformMessageToModel(user)
+	}
+
+	results, err := server.usecase.BatchCreateUsers(ctx, users, batchMode(req.Mode))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return server.transformBatchResultsToMessage(results), nil
+}
+
+func (server *UserServiceServer) BatchUpdateUsers(ctx context.Context, req *pb.BatchUpdateUsersRequest) (*pb.BatchUsersResponse, error) {
+	updates := make([]model.UserUpdate, len(req.Users))
+	for i, upreq := range req.Users {
+		updates[i] = model.UserUpdate{
+			User: &model.User{
+				Model:   gorm.Model{ID: uint(upreq.Id)},
+				Name:    upreq.Name,
+				Email:   upreq.Email,
+				Version: upreq.Version,
+			},
+			Fields: upreq.GetUpdateMask().GetPaths(),
+		}
+	}
+
+	results, err := server.usecase.BatchUpdateUsers(ctx, updates, batchMode(req.Mode))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return server.transformBatchResultsToMessage(results), nil
+}
+
+func (server *UserServiceServer) BatchDeleteUsers(ctx context.Context, req *pb.BatchDeleteUsersRequest) (*pb.BatchUsersResponse, error) {
+	results, err := server.usecase.BatchDeleteUsers(ctx, req.Ids, batchMode(req.Mode))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return server.transformBatchResultsToMessage(results), nil
+}
+
+func (server *UserServiceServer) ImportUsers(stream grpc.ClientStreamingServer[pb.ImportUsersRequest, pb.ImportUsersResponse]) error {
+	// the policy comes with the first message, rows with any of them
+	var policy pb.ConflictPolicy
+	var rows []*pb.ImportRow
+	first, err := stream.Recv()
+	done := errors.Is(err, io.EOF)
+	if err != nil && !done {
+		return toStatus(err)
+	}
+	if first != nil {
+		policy, rows = first.OnConflict, first.Rows
+	}
+
+	//hand rows to the usecase one at a time as they arrive
+	next := func() (*model.ImportRow, error) {
+		for len(rows) == 0 {
+			if done {
+				return nil, io.EOF
+			}
+			req, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				done = true
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			rows = req.Rows
+		}
+		row := rows[0]
+		rows = rows[1:]
+		return &model.ImportRow{Line: row.Line, Name: row.Name, Email: row.Email}, nil
+	}
+
+	report, err := server.usecase.ImportUsers(stream.Context(), next, conflictPolicy(policy))
+	if err != nil {
+		return toStatus(err)
+	}
+	return stream.SendAndClose(server.transformImportReportToMessage(report))
+}
+
+func conflictPolicy(policy pb.ConflictPolicy) model.ConflictPolicy {
+	switch policy {
+	case pb.ConflictPolicy_OVERWRITE:
+		return model.ConflictOverwrite
+	case pb.ConflictPolicy_FAIL:
+		return model.ConflictFail
+	default:
+		return model.ConflictSkip
+	}
+}
+
+func batchMode(mode pb.BatchMode) model.BatchMode {
+	if mode == pb.BatchMode_BEST_EFFORT {
+		return model.BatchBestEffort
+	}
+	return model.BatchAllOrNothing
+}
+
 func (server *UserServiceServer) transformMessageToModel(message *pb.CreateUserRequest) *model.User {
 	model := model.User{
 		Name:  message.Name,
@@ -109,11 +376,63 @@ func (server *UserServiceServer) transformMessageToModel(message *pb.CreateUserR
 	return &model
 }
 
+func (server *UserServiceServer) transformTokensToMessage(tokens *auth.TokenPair) *pb.SessionTokens {
+	return &pb.SessionTokens{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(time.Until(tokens.ExpiresAt).Round(time.Second).Seconds()),
+		SessionId:    tokens.SessionID,
+	}
+}
+
 func (server *UserServiceServer) transformModelToMessage(model *model.User) *pb.UserResponse {
 	message := pb.UserResponse{
-		Id:    fmt.Sprintf("%d", model.ID),
-		Name:  model.Name,
-		Email: model.Email,
+		Id:        fmt.Sprintf("%d", model.ID),
+		Name:      model.Name,
+		Email:     model.Email,
+		Status:    model.Status,
+		Version:   model.Version,
+		CreatedAt: timestamppb.New(model.CreatedAt),
+		UpdatedAt: timestamppb.New(model.UpdatedAt),
+	}
+	if model.DeletedAt.Valid {
+		message.DeletedAt = timestamppb.New(model.DeletedAt.Time)
 	}
 	return &message
 }
+
+func (server *UserServiceServer) transformBatchResultsToMessage(results []model.BatchResult) *pb.BatchUsersResponse {
+	resp := &pb.BatchUsersResponse{Results: make([]*pb.BatchItemResult, len(results))}
+	for i, result := range results {
+		item := &pb.BatchItemResult{Index: int32(i)}
+		if result.Err != nil {
+			item.Error = toBatchItemError(result.Err)
+			resp.Failed++
+		} else {
+			if result.User != nil {
+				item.User = server.transformModelToMessage(result.User)
+			}
+			resp.Succeeded++
+		}
+		resp.Results[i] = item
+	}
+	return resp
+}
+
+func (server *UserServiceServer) transformImportReportToMessage(report *model.ImportReport) *pb.ImportUsersResponse {
+	resp := &pb.ImportUsersResponse{
+		Created: report.Created,
+		Updated: report.Updated,
+		Skipped: report.Skipped,
+		Aborted: report.Aborted,
+	}
+	for _, rejection := range report.Rejected {
+		resp.Rejected = append(resp.Rejected, &pb.ImportRejection{
+			Line:  rejection.Line,
+			Email: rejection.Email,
+			Error: toBatchItemError(rejection.Err),
+		})
+	}
+	return resp
+}
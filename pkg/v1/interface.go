@@ -1,31 +1,129 @@
 package interfaces
 
 import (
+	"context"
+	"time"
+
+	"github.com/yishak-cs/CleanGrpc/Internal/auth"
 	"github.com/yishak-cs/CleanGrpc/Internal/model"
 )
 
 type RepoInterface interface {
-	CreateUser(*model.User) (*model.User, error)
+	CreateUser(ctx context.Context, user *model.User) (*model.User, error)
+
+	GetUsersList(ctx context.Context, query model.UserQuery) ([]*model.User, error)
+
+	StreamUsers(ctx context.Context, fn func(*model.User) error) error
+
+	SearchUsers(ctx context.Context, match string, limit int) ([]*model.UserSearchResult, error)
+
+	GetUser(ctx context.Context, id string) (*model.User, error)
+
+	UpdateUser(ctx context.Context, user *model.User, fields []string) error
+
+	SetUserStatus(ctx context.Context, id uint, status string) error
+
+	DeleteUser(ctx context.Context, id string) error
+
+	GetUserIncludingDeleted(ctx context.Context, id string) (*model.User, error)
+
+	RestoreUser(ctx context.Context, id uint) error
+
+	PurgeUser(ctx context.Context, id uint) error
+
+	PurgeDeletedUsers(ctx context.Context, before time.Time) (int64, error)
+
+	GetUserByEmail(ctx context.Context, email string) (*model.User, error)
+
+	CreateUsers(ctx context.Context, users []*model.User) error
+
+	UpdateUsers(ctx context.Context, updates []model.UserUpdate) error
+
+	DeleteUsers(ctx context.Context, ids []uint) error
+
+	GetUsersByIDs(ctx context.Context, ids []uint) ([]*model.User, error)
+
+	GetUsersByEmails(ctx context.Context, emails []string) ([]*model.User, error)
+
+	SetPasswordHash(ctx context.Context, id uint, hash string) error
+
+	RecordLoginFailure(ctx context.Context, id uint) (int, error)
+
+	SetLoginLock(ctx context.Context, id uint, failures int, lockedUntil *time.Time) error
+
+	CreateSession(ctx context.Context, session *model.Session) error
+
+	GetSession(ctx context.Context, id string) (*model.Session, error)
+
+	ListSessions(ctx context.Context, userID uint, now time.Time) ([]*model.Session, error)
 
-	GetUsersList() []*model.User
+	RotateSession(ctx context.Context, id, oldHash, newHash string, usedAt, expiresAt time.Time) (bool, error)
 
-	GetUser(id string) (*model.User, error)
+	DeleteSession(ctx context.Context, id string) error
 
-	UpdateUser(*model.User) error
+	DeleteExpiredSessions(ctx context.Context, now time.Time) (int64, error)
 
-	DeleteUser(string) error
+	ReplaceEmailToken(ctx context.Context, token *model.EmailToken) error
 
-	GetUserByEmail(string) (*model.User, error)
+	ConsumeEmailToken(ctx context.Context, hash, purpose string) (*model.EmailToken, error)
+
+	DeleteExpiredEmailTokens(ctx context.Context, now time.Time) (int64, error)
+
+	ReplaceMagicLinkToken(ctx context.Context, token *model.MagicLinkToken) error
+
+	ConsumeMagicLinkToken(ctx context.Context, hash string) (*model.MagicLinkToken, error)
+
+	DeleteExpiredMagicLinkTokens(ctx context.Context, now time.Time) (int64, error)
 }
 
 type UseCaseInterface interface {
-	CreateUser(*model.User) (*model.User, error)
+	CreateUser(ctx context.Context, user *model.User) (*model.User, error)
+
+	GetUsersList(ctx context.Context, params model.ListUsersParams) (*model.UsersPage, error)
+
+	StreamUsers(ctx context.Context, fn func(*model.User) error) error
+
+	SearchUsers(ctx context.Context, query string, limit int) ([]*model.UserSearchResult, error)
+
+	GetUser(ctx context.Context, id string) (*model.User, error)
+
+	UpdateUser(ctx context.Context, user *model.User, fields []string) error
+
+	DeleteUser(ctx context.Context, id string) error
+
+	UndeleteUser(ctx context.Context, id string) (*model.User, error)
+
+	PurgeUser(ctx context.Context, id string) error
+
+	BatchCreateUsers(ctx context.Context, users []*model.User, mode model.BatchMode) ([]model.BatchResult, error)
+
+	BatchUpdateUsers(ctx context.Context, updates []model.UserUpdate, mode model.BatchMode) ([]model.BatchResult, error)
+
+	BatchDeleteUsers(ctx context.Context, ids []string, mode model.BatchMode) ([]model.BatchResult, error)
+
+	ExportUsers(ctx context.Context, filter, orderBy string, fn func(*model.User) error) error
+
+	ImportUsers(ctx context.Context, next func() (*model.ImportRow, error), policy model.ConflictPolicy) (*model.ImportReport, error)
+
+	SetPassword(ctx context.Context, id, currentPassword, newPassword string) error
+
+	Login(ctx context.Context, email, password string) (*auth.TokenPair, error)
+
+	IssueSession(ctx context.Context, userID string) (*auth.TokenPair, error)
+
+	RefreshSession(ctx context.Context, refreshToken string) (*auth.TokenPair, error)
+
+	ListSessions(ctx context.Context, userID string) ([]*model.Session, error)
+
+	RevokeSession(ctx context.Context, userID, sessionID string) error
+
+	VerifyEmail(ctx context.Context, token string) error
 
-	GetUsersList() []*model.User
+	ResendVerification(ctx context.Context, id string) error
 
-	GetUser(id string) (*model.User, error)
+	RequestMagicLink(ctx context.Context, email string) error
 
-	UpdateUser(*model.User) error
+	RedeemMagicLink(ctx context.Context, token string) (*auth.TokenPair, error)
 
-	DeleteUser(id string) error
+	Wait()
 }
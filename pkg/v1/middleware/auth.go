@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/yishak-cs/CleanGrpc/Internal/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// TokenVerifier turns a bearer token into the principal it was issued to
+type TokenVerifier interface {
+	Verify(token string) (*auth.Principal, error)
+}
+
+// Authenticator requires a valid bearer token in the authorization metadata
+// of every RPC except the public ones. a caller without a token that
+// presented a verified TLS client certificate is identified by it instead
+type Authenticator struct {
+	verifier TokenVerifier
+	public   map[string]bool
+	logger   *slog.Logger
+}
+
+// NewAuthenticator checks tokens with verifier. publicMethods are full method
+// names, e.g. /grpc.health.v1.Health/Check, callable without a token
+func NewAuthenticator(verifier TokenVerifier, publicMethods []string, logger *slog.Logger) *Authenticator {
+	public := make(map[string]bool, len(publicMethods))
+	for _, method := range publicMethods {
+		public[method] = true
+	}
+	return &Authenticator{verifier: verifier, public: public, logger: logger}
+}
+
+// Unary authenticates every unary RPC
+func (a *Authenticator) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := a.authenticate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Stream authenticates every streaming RPC before the handler sees it
+func (a *Authenticator) Stream() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.authenticate(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &wrappedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authenticate returns ctx carrying the caller's principal. public methods
+// pass through untouched. the reason a token was rejected is logged, the
+// caller only learns that it was
+func (a *Authenticator) authenticate(ctx context.Context, method string) (context.Context, error) {
+	if a.public[method] {
+		return ctx, nil
+	}
+	token, ok := bearerToken(ctx)
+	if !ok {
+		if principal, ok := peerPrincipal(ctx); ok {
+			return auth.WithPrincipal(ctx, principal), nil
+		}
+		return ctx, status.Error(codes.Unauthenticated, "missing bearer token")
+	}
+	principal, err := a.verifier.Verify(token)
+	if err != nil {
+		a.logger.DebugContext(ctx, "token rejected",
+			"method", method,
+			"request_id", RequestIDFromContext(ctx),
+			"err", err,
+		)
+		return ctx, status.Error(codes.Unauthenticated, "invalid bearer token")
+	}
+	return auth.WithPrincipal(ctx, principal), nil
+}
+
+// bearerToken extracts the token of an "authorization: Bearer <token>" header
+func bearerToken(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+	values := md.Get("authorization")
+	if len(values) != 1 {
+		return "", false
+	}
+	scheme, token, found := strings.Cut(values[0], " ")
+	if !found || !strings.EqualFold(scheme, "bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// peerPrincipal identifies a caller by the client certificate it completed
+// the TLS handshake with: the common name is the subject and the
+// organizational units are the roles. unverified certificates never count
+func peerPrincipal(ctx context.Context) (*auth.Principal, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+	cert := tlsInfo.State.VerifiedChains[0][0]
+	if cert.Subject.CommonName == "" {
+		return nil, false
+	}
+	return &auth.Principal{Subject: cert.Subject.CommonName, Roles: cert.Subject.OrganizationalUnit}, true
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"context"
+	"slices"
+
+	"github.com/yishak-cs/CleanGrpc/Internal/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Policy admits callers to methods by role. it runs after the Authenticator
+// and only ever narrows what an authenticated caller may do
+type Policy struct {
+	rules map[string][]string
+}
+
+// NewPolicy enforces rules, a list of roles per full method name. a caller
+// holding any listed role gets full access, auth.RoleAny admits every
+// authenticated caller without it and auth.RoleSelf admits them to their own
+// record only, which the UseCase layer enforces. methods without a rule are
+// open to every authenticated caller, as if their rule was auth.RoleAny
+func NewPolicy(rules map[string][]string) *Policy {
+	return &Policy{rules: rules}
+}
+
+// Unary authorizes every unary RPC
+func (p *Policy) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := p.authorize(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Stream authorizes every streaming RPC
+func (p *Policy) Stream() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := p.authorize(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &wrappedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authorize returns ctx carrying the grant the caller gets for method
+func (p *Policy) authorize(ctx context.Context, method string) (context.Context, error) {
+	roles, ruled := p.rules[method]
+	principal, ok := auth.PrincipalFromContext(ctx)
+	if !ruled {
+		if !ok {
+			// a public method, there is nobody to grant anything to
+			return ctx, nil
+		}
+		return auth.WithGrant(ctx, auth.GrantAny), nil
+	}
+	if !ok {
+		// a public method that still carries a rule
+		return ctx, status.Error(codes.PermissionDenied, "method requires an authenticated caller")
+	}
+
+	if slices.ContainsFunc(roles, principal.HasRole) {
+		return auth.WithGrant(ctx, auth.GrantFull), nil
+	}
+	if slices.Contains(roles, auth.RoleAny) {
+		return auth.WithGrant(ctx, auth.GrantAny), nil
+	}
+	if slices.Contains(roles, auth.RoleSelf) {
+		return auth.WithGrant(ctx, auth.GrantSelf), nil
+	}
+	return ctx, status.Errorf(codes.PermissionDenied, "caller lacks a role allowed to call %s", method)
+}
@@ -0,0 +1,79 @@
+// Package middleware holds the interceptors every RPC passes through before
+// it reaches a handler
+package middleware
+
+import (
+	"context"
+	"log/slog"
+
+	"google.golang.org/grpc"
+)
+
+// Chain describes the interceptors installed on the server. Metrics, Auth
+// and Policy are optional and skipped when nil
+type Chain struct {
+	Logger  *slog.Logger
+	Metrics *Metrics
+	Auth    *Authenticator
+	Policy  *Policy
+}
+
+// Unary returns the unary chain in the order it must run. the request ID
+// comes first so everything after it can log it, and recovery wraps the
+// rest so the access log and metrics record the codes.Internal a panic turns
+// into. authentication and then authorization run last so rejected calls
+// are still logged
+func (c Chain) Unary() []grpc.UnaryServerInterceptor {
+	chain := []grpc.UnaryServerInterceptor{UnaryRequestID()}
+	if c.Metrics != nil {
+		chain = append(chain, c.Metrics.Unary())
+	}
+	chain = append(chain,
+		UnaryLogging(c.Logger),
+		UnaryRecovery(c.Logger),
+	)
+	if c.Auth != nil {
+		chain = append(chain, c.Auth.Unary())
+	}
+	if c.Policy != nil {
+		chain = append(chain, c.Policy.Unary())
+	}
+	return chain
+}
+
+// Stream is the streaming counterpart of Unary
+func (c Chain) Stream() []grpc.StreamServerInterceptor {
+	chain := []grpc.StreamServerInterceptor{StreamRequestID()}
+	if c.Metrics != nil {
+		chain = append(chain, c.Metrics.Stream())
+	}
+	chain = append(chain,
+		StreamLogging(c.Logger),
+		StreamRecovery(c.Logger),
+	)
+	if c.Auth != nil {
+		chain = append(chain, c.Auth.Stream())
+	}
+	if c.Policy != nil {
+		chain = append(chain, c.Policy.Stream())
+	}
+	return chain
+}
+
+// ServerOptions installs both chains on a grpc.Server
+func (c Chain) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(c.Unary()...),
+		grpc.ChainStreamInterceptor(c.Stream()...),
+	}
+}
+
+// wrappedStream lets a stream interceptor hand the handler a derived context
+type wrappedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (w *wrappedStream) Context() context.Context {
+	return w.ctx
+}
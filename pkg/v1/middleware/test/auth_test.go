@@ -0,0 +1,104 @@
+package middleware_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/yishak-cs/CleanGrpc/Internal/auth"
+	"github.com/yishak-cs/CleanGrpc/pkg/v1/middleware"
+	pb "github.com/yishak-cs/CleanGrpc/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var secret = []byte("0123456789abcdef0123456789abcdef")
+
+func token(t *testing.T, sub string, exp time.Time) string {
+	t.Helper()
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": sub, "exp": exp.Unix()}).SignedString(secret)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return signed
+}
+
+func withAuthorization(value string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", value)
+}
+
+func setupAuthServer(t *testing.T, publicMethods ...string) (pb.UserServiceClient, *syncBuffer) {
+	verifier, err := auth.NewVerifier(auth.VerifierConfig{HMACSecret: secret})
+	if err != nil {
+		t.Fatalf("Failed to create verifier: %v", err)
+	}
+	return setupServer(t, middleware.Chain{Auth: middleware.NewAuthenticator(verifier, publicMethods, slog.New(slog.NewTextHandler(io.Discard, nil)))})
+}
+
+func TestAuth(t *testing.T) {
+	client, logs := setupAuthServer(t)
+	valid := token(t, "42", time.Now().Add(time.Hour))
+
+	// Test case: A valid bearer token reaches the handler with its principal
+	resp, err := client.GetUser(withAuthorization("Bearer "+valid), &pb.SingleUserRequest{Id: "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "42", resp.Email)
+
+	// Test case: The scheme is case insensitive
+	resp, err = client.GetUser(withAuthorization("bearer "+valid), &pb.SingleUserRequest{Id: "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "42", resp.Email)
+
+	// Test case: Missing, malformed and invalid credentials are Unauthenticated
+	cases := []struct {
+		name    string
+		ctx     context.Context
+		message string
+	}{
+		{"no metadata", context.Background(), "missing bearer token"},
+		{"basic auth", withAuthorization("Basic dXNlcjpwYXNz"), "missing bearer token"},
+		{"empty token", withAuthorization("Bearer "), "missing bearer token"},
+		{"expired", withAuthorization("Bearer " + token(t, "42", time.Now().Add(-time.Hour))), "invalid bearer token"},
+		{"tampered", withAuthorization("Bearer " + valid + "x"), "invalid bearer token"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := client.GetUser(tc.ctx, &pb.SingleUserRequest{Id: "1"})
+			st, _ := status.FromError(err)
+			assert.Equal(t, codes.Unauthenticated, st.Code())
+			assert.Equal(t, tc.message, st.Message())
+		})
+	}
+
+	// Test case: Streams are authenticated before the handler runs
+	stream, err := client.ListUsers(context.Background(), &pb.Empty{})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// Test case: Rejections are still access logged
+	access := logs.accessLogs(t)
+	if assert.NotEmpty(t, access) {
+		assert.Equal(t, "Unauthenticated", access[len(access)-1]["code"])
+	}
+}
+
+func TestAuth_PublicMethods(t *testing.T) {
+	client, _ := setupAuthServer(t, "/UserService/GetUser")
+
+	// Test case: Allowlisted methods need no token and carry no principal
+	resp, err := client.GetUser(context.Background(), &pb.SingleUserRequest{Id: "1"})
+	assert.NoError(t, err)
+	assert.Empty(t, resp.Email)
+
+	// Test case: Everything else still requires one
+	stream, err := client.ListUsers(context.Background(), &pb.Empty{})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
@@ -0,0 +1,203 @@
+package middleware_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yishak-cs/CleanGrpc/Internal/auth"
+	"github.com/yishak-cs/CleanGrpc/pkg/v1/middleware"
+	pb "github.com/yishak-cs/CleanGrpc/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+var rules = map[string][]string{
+	"/UserService/DeleteUser":   {"admin"},
+	"/UserService/UpdateUser":   {"admin", auth.RoleSelf},
+	"/UserService/GetUser":      {auth.RoleAny},
+	"/UserService/GetUsersList": {"admin", auth.RoleAny},
+}
+
+// authorize runs the policy for method as principal and returns the context
+// the handler would have seen
+func authorize(t *testing.T, method string, principal *auth.Principal) (context.Context, error) {
+	t.Helper()
+	ctx := context.Background()
+	if principal != nil {
+		ctx = auth.WithPrincipal(ctx, principal)
+	}
+	var seen context.Context
+	_, err := middleware.NewPolicy(rules).Unary()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method},
+		func(ctx context.Context, _ any) (any, error) {
+			seen = ctx
+			return nil, nil
+		})
+	return seen, err
+}
+
+func TestPolicy(t *testing.T) {
+	admin := &auth.Principal{Subject: "1", Roles: []string{"admin"}}
+	user := &auth.Principal{Subject: "2", Roles: []string{"user"}}
+	roleless := &auth.Principal{Subject: "3"}
+
+	cases := []struct {
+		name      string
+		principal *auth.Principal
+		method    string
+		code      codes.Code
+		grant     auth.Grant
+	}{
+		{"admin deletes", admin, "/UserService/DeleteUser", codes.OK, auth.GrantFull},
+		{"admin updates anyone", admin, "/UserService/UpdateUser", codes.OK, auth.GrantFull},
+		{"admin gets like anyone without a listed role", admin, "/UserService/GetUser", codes.OK, auth.GrantAny},
+		{"admin lists with full access", admin, "/UserService/GetUsersList", codes.OK, auth.GrantFull},
+		{"user cannot delete", user, "/UserService/DeleteUser", codes.PermissionDenied, 0},
+		{"user updates self only", user, "/UserService/UpdateUser", codes.OK, auth.GrantSelf},
+		{"user gets without full access", user, "/UserService/GetUser", codes.OK, auth.GrantAny},
+		{"user lists without full access", user, "/UserService/GetUsersList", codes.OK, auth.GrantAny},
+		{"user lists without a rule or full access", user, "/UserService/ListUsers", codes.OK, auth.GrantAny},
+		{"roleless cannot delete", roleless, "/UserService/DeleteUser", codes.PermissionDenied, 0},
+		{"roleless updates self only", roleless, "/UserService/UpdateUser", codes.OK, auth.GrantSelf},
+		{"anonymous cannot use a ruled method", nil, "/UserService/GetUser", codes.PermissionDenied, 0},
+		{"anonymous passes unruled methods", nil, "/grpc.health.v1.Health/Check", codes.OK, auth.GrantFull},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, err := authorize(t, tc.method, tc.principal)
+			assert.Equal(t, tc.code, status.Code(err))
+			if tc.code == codes.OK {
+				grant, granted := auth.GrantFromContext(ctx)
+				assert.Equal(t, tc.grant, grant)
+				// only anonymous callers of public methods go without a grant
+				assert.Equal(t, tc.principal != nil, granted)
+			}
+		})
+	}
+}
+
+// testPKI issues a CA, a server certificate and client certificates for
+// mutual TLS tests
+type testPKI struct {
+	ca     *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	pool   *x509.CertPool
+	serial int64
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	ca, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return &testPKI{ca: ca, caKey: key, pool: pool, serial: 1}
+}
+
+func (p *testPKI) issue(t *testing.T, subject pkix.Name, usage x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+	p.serial++
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(p.serial),
+		Subject:      subject,
+		DNSNames:     []string{"bufnet"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, p.ca, &key.PublicKey, p.caKey)
+	if err != nil {
+		t.Fatalf("Failed to issue certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestAuth_ClientCertificate(t *testing.T) {
+	pki := newTestPKI(t)
+	serverCert := pki.issue(t, pkix.Name{CommonName: "bufnet"}, x509.ExtKeyUsageServerAuth)
+
+	verifier, _ := auth.NewVerifier(auth.VerifierConfig{HMACSecret: secret})
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	chain := middleware.Chain{
+		Logger: logger,
+		Auth:   middleware.NewAuthenticator(verifier, nil, logger),
+		Policy: middleware.NewPolicy(rules),
+	}
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(append(chain.ServerOptions(), grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    pki.pool,
+	})))...)
+	pb.RegisterUserServiceServer(server, stubServer{})
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	dial := func(certs ...tls.Certificate) pb.UserServiceClient {
+		conn, err := grpc.NewClient("passthrough:///bufnet",
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return listener.DialContext(ctx)
+			}),
+			grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+				RootCAs:      pki.pool,
+				ServerName:   "bufnet",
+				Certificates: certs,
+			})),
+		)
+		if err != nil {
+			t.Fatalf("Failed to dial bufnet: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return pb.NewUserServiceClient(conn)
+	}
+
+	// Test case: The certificate's common name is the caller and its OUs are the roles
+	operator := dial(pki.issue(t, pkix.Name{CommonName: "7", OrganizationalUnit: []string{"admin"}}, x509.ExtKeyUsageClientAuth))
+	resp, err := operator.GetUser(context.Background(), &pb.SingleUserRequest{Id: "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "7", resp.Email)
+
+	_, err = operator.DeleteUser(context.Background(), &pb.SingleUserRequest{Id: "1"})
+	assert.NotEqual(t, codes.PermissionDenied, status.Code(err))
+	assert.NotEqual(t, codes.Unauthenticated, status.Code(err))
+
+	// Test case: Without the admin OU deletes are denied
+	plain := dial(pki.issue(t, pkix.Name{CommonName: "8"}, x509.ExtKeyUsageClientAuth))
+	_, err = plain.DeleteUser(context.Background(), &pb.SingleUserRequest{Id: "1"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// Test case: No certificate and no token is unauthenticated
+	anonymous := dial()
+	_, err = anonymous.GetUser(context.Background(), &pb.SingleUserRequest{Id: "1"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
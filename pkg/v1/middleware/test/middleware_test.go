@@ -0,0 +1,274 @@
+package middleware_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/yishak-cs/CleanGrpc/Internal/auth"
+	"github.com/yishak-cs/CleanGrpc/pkg/v1/middleware"
+	pb "github.com/yishak-cs/CleanGrpc/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// stubServer answers GetUser with the request ID and principal it saw and
+// panics for id "panic". ListUsers does the same for streams
+type stubServer struct {
+	pb.UnimplementedUserServiceServer
+}
+
+func (stubServer) GetUser(ctx context.Context, req *pb.SingleUserRequest) (*pb.UserResponse, error) {
+	if req.Id == "panic" {
+		panic("boom")
+	}
+	if req.Id == "missing" {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+	resp := &pb.UserResponse{Id: req.Id, Name: middleware.RequestIDFromContext(ctx)}
+	if principal, ok := auth.PrincipalFromContext(ctx); ok {
+		resp.Email = principal.Subject
+	}
+	return resp, nil
+}
+
+func (stubServer) ListUsers(_ *pb.Empty, stream grpc.ServerStreamingServer[pb.UserResponse]) error {
+	if err := stream.Send(&pb.UserResponse{Name: middleware.RequestIDFromContext(stream.Context())}); err != nil {
+		return err
+	}
+	panic("stream boom")
+}
+
+// syncBuffer collects log output written from server goroutines
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// records decodes every JSON log line written so far
+func (b *syncBuffer) records(t *testing.T) []map[string]any {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// accessLogs returns the "rpc" records
+func (b *syncBuffer) accessLogs(t *testing.T) []map[string]any {
+	var logs []map[string]any
+	for _, r := range b.records(t) {
+		if r["msg"] == "rpc" {
+			logs = append(logs, r)
+		}
+	}
+	return logs
+}
+
+// setupServer serves stubServer behind chain, logging to the returned buffer
+func setupServer(t *testing.T, chain middleware.Chain) (pb.UserServiceClient, *syncBuffer) {
+	logs := &syncBuffer{}
+	chain.Logger = slog.New(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(chain.ServerOptions()...)
+	pb.RegisterUserServiceServer(server, stubServer{})
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to dial bufnet: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewUserServiceClient(conn), logs
+}
+
+func TestRecovery(t *testing.T) {
+	client, logs := setupServer(t, middleware.Chain{})
+	ctx := context.Background()
+
+	// Test case: A panicking handler returns Internal without leaking the panic
+	_, err := client.GetUser(ctx, &pb.SingleUserRequest{Id: "panic"})
+	st, _ := status.FromError(err)
+	assert.Equal(t, codes.Internal, st.Code())
+	assert.Equal(t, "internal error", st.Message())
+
+	// Test case: The server keeps serving after a panic
+	resp, err := client.GetUser(ctx, &pb.SingleUserRequest{Id: "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "1", resp.Id)
+
+	// Test case: The panic and its stack are logged with the request ID
+	var panics []map[string]any
+	for _, r := range logs.records(t) {
+		if r["msg"] == "panic in handler" {
+			panics = append(panics, r)
+		}
+	}
+	if assert.Len(t, panics, 1) {
+		assert.Equal(t, "boom", panics[0]["panic"])
+		assert.Equal(t, "/UserService/GetUser", panics[0]["method"])
+		assert.NotEmpty(t, panics[0]["request_id"])
+		assert.Contains(t, panics[0]["stack"], "runtime/debug.Stack")
+	}
+
+	// Test case: The access log records the converted code
+	access := logs.accessLogs(t)
+	if assert.Len(t, access, 2) {
+		assert.Equal(t, "Internal", access[0]["code"])
+		assert.Equal(t, "ERROR", access[0]["level"])
+		assert.Equal(t, "OK", access[1]["code"])
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	client, logs := setupServer(t, middleware.Chain{})
+
+	// Test case: An ID is generated, seen by the handler and returned in the header
+	var header metadata.MD
+	resp, err := client.GetUser(context.Background(), &pb.SingleUserRequest{Id: "1"}, grpc.Header(&header))
+	assert.NoError(t, err)
+	generated := header.Get(middleware.RequestIDHeader)
+	if assert.Len(t, generated, 1) {
+		assert.Len(t, generated[0], 36)
+		assert.Equal(t, generated[0], resp.Name)
+	}
+
+	// Test case: A caller supplied ID is propagated unchanged
+	ctx := metadata.AppendToOutgoingContext(context.Background(), middleware.RequestIDHeader, "req-42")
+	header = nil
+	resp, err = client.GetUser(ctx, &pb.SingleUserRequest{Id: "1"}, grpc.Header(&header))
+	assert.NoError(t, err)
+	assert.Equal(t, "req-42", resp.Name)
+	assert.Equal(t, []string{"req-42"}, header.Get(middleware.RequestIDHeader))
+
+	// Test case: IDs unsafe to log are replaced
+	for _, bad := range []string{"has space", strings.Repeat("x", 129)} {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), middleware.RequestIDHeader, bad)
+		resp, err := client.GetUser(ctx, &pb.SingleUserRequest{Id: "1"})
+		assert.NoError(t, err)
+		assert.NotEqual(t, bad, resp.Name)
+		assert.Len(t, resp.Name, 36)
+	}
+
+	// Test case: The access log carries the same ID
+	access := logs.accessLogs(t)
+	if assert.Len(t, access, 4) {
+		assert.Equal(t, generated[0], access[0]["request_id"])
+		assert.Equal(t, "req-42", access[1]["request_id"])
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	client, logs := setupServer(t, middleware.Chain{})
+
+	_, err := client.GetUser(context.Background(), &pb.SingleUserRequest{Id: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// Test case: Method, duration, code, peer and error are logged at info for caller errors
+	access := logs.accessLogs(t)
+	if assert.Len(t, access, 1) {
+		record := access[0]
+		assert.Equal(t, "INFO", record["level"])
+		assert.Equal(t, "/UserService/GetUser", record["method"])
+		assert.Equal(t, "NotFound", record["code"])
+		assert.Equal(t, "user not found", record["err"])
+		assert.Equal(t, "bufconn", record["peer"])
+		assert.Contains(t, record, "duration")
+	}
+}
+
+func TestStreamInterceptors(t *testing.T) {
+	client, logs := setupServer(t, middleware.Chain{})
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), middleware.RequestIDHeader, "stream-1")
+	stream, err := client.ListUsers(ctx, &pb.Empty{})
+	assert.NoError(t, err)
+
+	// Test case: The stream handler sees the request ID and it is echoed back
+	first, err := stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, "stream-1", first.Name)
+	header, err := stream.Header()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"stream-1"}, header.Get(middleware.RequestIDHeader))
+
+	// Test case: A panic mid-stream ends it with Internal
+	_, err = stream.Recv()
+	assert.NotEqual(t, io.EOF, err)
+	assert.Equal(t, codes.Internal, status.Code(err))
+
+	access := logs.accessLogs(t)
+	if assert.Len(t, access, 1) {
+		assert.Equal(t, "/UserService/ListUsers", access[0]["method"])
+		assert.Equal(t, "Internal", access[0]["code"])
+		assert.Equal(t, "stream-1", access[0]["request_id"])
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	client, _ := setupServer(t, middleware.Chain{Metrics: middleware.NewMetrics(reg)})
+	ctx := context.Background()
+
+	client.GetUser(ctx, &pb.SingleUserRequest{Id: "1"})
+	client.GetUser(ctx, &pb.SingleUserRequest{Id: "2"})
+	client.GetUser(ctx, &pb.SingleUserRequest{Id: "missing"})
+	client.GetUser(ctx, &pb.SingleUserRequest{Id: "panic"})
+	stream, err := client.ListUsers(ctx, &pb.Empty{})
+	assert.NoError(t, err)
+	for err == nil {
+		_, err = stream.Recv()
+	}
+
+	// Test case: Requests are counted per method and status code, panics included
+	expected := `
+# HELP cleangrpc_grpc_requests_total RPCs handled, by full method name and status code.
+# TYPE cleangrpc_grpc_requests_total counter
+cleangrpc_grpc_requests_total{code="Internal",method="/UserService/GetUser"} 1
+cleangrpc_grpc_requests_total{code="Internal",method="/UserService/ListUsers"} 1
+cleangrpc_grpc_requests_total{code="NotFound",method="/UserService/GetUser"} 1
+cleangrpc_grpc_requests_total{code="OK",method="/UserService/GetUser"} 2
+`
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected), "cleangrpc_grpc_requests_total"))
+
+	// Test case: Every method and code pair gets a latency histogram
+	count, err := testutil.GatherAndCount(reg, "cleangrpc_grpc_request_duration_seconds")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, count)
+}